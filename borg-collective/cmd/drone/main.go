@@ -18,6 +18,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/integrii/flaggy"
@@ -29,6 +37,8 @@ import (
 	"github.com/vemilyus/borg-collective/internal/drone/borg/api"
 	"github.com/vemilyus/borg-collective/internal/drone/config"
 	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/drone/container/podman"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
 	"github.com/vemilyus/borg-collective/internal/drone/worker"
 	"github.com/vemilyus/borg-collective/internal/logging"
 )
@@ -40,10 +50,29 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == validateCommand {
+		validate(os.Args[2:])
+		return
+	}
+
 	parseArgs()
-	logging.InitLogging()
 
-	ctx := context.Background()
+	// keep stdout clean for the plan and job list
+	if config.DryRun || config.ListJobs {
+		logging.InitLoggingTo(os.Stderr)
+	} else {
+		logging.InitLogging()
+	}
+
+	// the first signal starts a graceful shutdown, a second one terminates
+	// immediately
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	if config.Verbose {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
@@ -61,37 +90,47 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to create Borg client")
 	}
 
-	var dockerClient *docker.Client
+	containerClients := make([]*docker.Client, 0, 2)
 	rawDockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		log.Warn().Err(err).Msg("Docker not available")
 	} else {
-		dockerClient = docker.NewClient(rawDockerClient)
+		containerClients = append(containerClients, docker.NewClient(rawDockerClient))
+	}
+
+	if podmanClient := newPodmanClient(); podmanClient != nil {
+		containerClients = append(containerClients, podmanClient)
 	}
 
 	cronLogger := logging.NewZerologCronLogger(config.Verbose)
 
 	scheduler := cron.New(
 		cron.WithLogger(cronLogger),
-		cron.WithChain(cron.SkipIfStillRunning(cronLogger), cron.Recover(cronLogger)),
+		cron.WithChain(cron.Recover(cronLogger)),
 	)
 
-	wrk := worker.NewWorker(ctx, configPath, borgClient, dockerClient, scheduler)
+	wrk := worker.NewWorker(ctx, configPath, borgClient, containerClients, scheduler)
+	wrk.Configure(*initialConfig)
 	wrk.ScheduleRepoCompaction(*initialConfig)
 	wrk.ScheduleStaticBackups(initialConfig.Backups)
 
-	if dockerClient != nil {
-		dockerBackups, err := dockerClient.ReadProjects(ctx)
+	for _, containerClient := range containerClients {
+		containerBackups, err := containerClient.ReadProjects(ctx)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to load docker container state")
+			log.Fatal().Err(err).Str("engine", string(containerClient.Engine())).Msg("failed to load container state")
 		}
 
-		err = wrk.ScheduleContainerBackups(dockerBackups)
+		err = wrk.ScheduleContainerBackups(containerBackups)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to schedule container backups")
+			log.Fatal().Err(err).Str("engine", string(containerClient.Engine())).Msg("failed to schedule container backups")
 		}
 	}
 
+	if config.ListJobs {
+		printJobs(wrk.ListJobs())
+		return
+	}
+
 	initializeRepository := false
 	info, err := borgClient.Info()
 
@@ -109,23 +148,36 @@ func main() {
 			Msg("retrieved borg repository info")
 	}
 
-	if !config.DryRun {
+	if config.DryRun {
 		if initializeRepository {
-			err = borgClient.Init()
-			if err != nil {
-				log.Fatal().Err(err).Msg("failed to initialize borg repository")
-			}
+			log.Info().Msg("repository would be initialized")
 		}
 
-		if config.Once {
-			err = wrk.RunOnce()
-		} else {
-			err = wrk.Run()
+		err = printPlan(wrk, *initialConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to plan backup jobs")
 		}
+
+		return
 	}
 
-	if err != nil {
-		log.Fatal().Err(err).Msg("scheduler failed")
+	if initializeRepository {
+		err = borgClient.Init()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to initialize borg repository")
+		}
+	}
+
+	if config.Once {
+		err = wrk.RunOnce(onceOptions())
+		if err != nil {
+			log.Fatal().Err(err).Msg("backup jobs failed")
+		}
+	} else {
+		err = wrk.Run()
+		if err != nil {
+			log.Fatal().Err(err).Msg("scheduler failed")
+		}
 	}
 }
 
@@ -133,11 +185,177 @@ func parseArgs() {
 	flaggy.SetName("borgd")
 	flaggy.SetDescription("Schedules and controls the execution of borg backups")
 	flaggy.SetVersion(version)
+	flaggy.DefaultParser.AdditionalHelpAppend = "\nCommands:\n  " + validateCommand +
+		" CONFIG-PATH  Check the configuration file and container labels for problems"
 
 	flaggy.AddPositionalValue(&configPath, "CONFIG-PATH", 1, true, "Path to the configuration file")
-	flaggy.Bool(&config.DryRun, "", "dry-run", "Configure all backups without actually running them")
+	flaggy.Bool(&config.DryRun, "", "dry-run", "Print the execution plan of all backups without running them")
+	flaggy.Bool(&config.JsonOutput, "", "json", "Print the execution plan as JSON with --dry-run")
 	flaggy.Bool(&config.Once, "", "once", "Run all configured backups once and exit")
+	flaggy.StringSlice(&config.Only, "", "only", "Only run this backup, project or project/service with --once (repeatable)")
+	flaggy.Bool(&config.SkipCompaction, "", "skip-compaction", "Don't compact the repository with --once")
+	flaggy.Bool(&config.IgnoreWindows, "", "ignore-windows", "Run backups outside their allowed windows with --once")
+	flaggy.Bool(&config.ListJobs, "", "list-jobs", "List all backup jobs and exit")
 	flaggy.Bool(&config.Verbose, "", "verbose", "Enable verbose log output")
 
 	flaggy.Parse()
+
+	if (len(config.Only) > 0 || config.SkipCompaction || config.IgnoreWindows) && !config.Once {
+		flaggy.ShowHelpAndExit("--only, --skip-compaction and --ignore-windows require --once")
+	}
+
+	if config.JsonOutput && !config.DryRun {
+		flaggy.ShowHelpAndExit("--json requires --dry-run")
+	}
+}
+
+func printPlan(wrk *worker.Worker, cfg config.Config) error {
+	var plan worker.Plan
+	if config.Once {
+		var err error
+		plan, err = wrk.PlanOnce(cfg, onceOptions())
+		if err != nil {
+			return err
+		}
+	} else {
+		plan = wrk.Plan(cfg)
+	}
+
+	if config.JsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(plan)
+	}
+
+	fmt.Printf("repository: %s\n", plan.Repository)
+	fmt.Printf(
+		"options: state dir %s, catch-up delay %s, jitter %s, shutdown grace period %s\n",
+		plan.Options.StateDir,
+		plan.Options.CatchUpDelay.Duration(),
+		plan.Options.Jitter.Duration(),
+		plan.Options.ShutdownGracePeriod.Duration(),
+	)
+
+	for _, job := range plan.Jobs {
+		fmt.Printf("\n%s (%s, priority %d", job.Name, job.Kind, job.Priority)
+		if job.Next != nil {
+			fmt.Printf(", next run %s", job.Next.Format(time.RFC3339))
+		}
+
+		if job.Timeout != nil {
+			fmt.Printf(", timeout %s", job.Timeout.Duration())
+		}
+
+		fmt.Println(")")
+
+		if job.Windows != nil {
+			fmt.Printf("  windows: %s\n", formatWindows(*job.Windows))
+		}
+
+		if len(job.After) > 0 {
+			fmt.Printf("  after: %s\n", strings.Join(job.After, ", "))
+		}
+
+		if len(job.Requires) > 0 {
+			fmt.Printf("  requires: %s\n", strings.Join(job.Requires, ", "))
+		}
+
+		for i, step := range job.Steps {
+			line := fmt.Sprintf("  %d. %s", i+1, step.Action)
+			if step.Container != "" {
+				line += " " + step.Container
+			}
+
+			if len(step.Command) > 0 {
+				command := make([]string, 0, len(step.Command))
+				for _, arg := range step.Command {
+					if strings.ContainsAny(arg, " \t\n\"'") {
+						arg = strconv.Quote(arg)
+					}
+
+					command = append(command, arg)
+				}
+
+				line += ": " + strings.Join(command, " ")
+			}
+
+			if step.Note != "" {
+				line += " (" + step.Note + ")"
+			}
+
+			fmt.Println(line)
+		}
+	}
+
+	return nil
+}
+
+// newPodmanClient returns a client for Podman if its API socket exists.
+func newPodmanClient() *docker.Client {
+	socketPath, err := podman.SocketPath()
+	if err != nil {
+		log.Warn().Err(err).Msg("Podman not available")
+		return nil
+	}
+
+	if _, err = os.Stat(socketPath); err != nil {
+		log.Debug().Err(err).Str("socket", socketPath).Msg("Podman not available")
+		return nil
+	}
+
+	podmanClient, err := podman.NewClient(socketPath)
+	if err != nil {
+		log.Warn().Err(err).Str("socket", socketPath).Msg("Podman not available")
+		return nil
+	}
+
+	return podmanClient
+}
+
+func onceOptions() worker.OnceOptions {
+	return worker.OnceOptions{
+		Only:           config.Only,
+		SkipCompaction: config.SkipCompaction,
+		IgnoreWindows:  config.IgnoreWindows,
+	}
+}
+
+func formatWindows(windows window.Windows) string {
+	specs := make([]string, 0, len(windows.Allowed)+len(windows.Blackout))
+	for _, w := range windows.Allowed {
+		specs = append(specs, "allowed "+w.String())
+	}
+
+	for _, w := range windows.Blackout {
+		specs = append(specs, "blackout "+w.String())
+	}
+
+	return strings.Join(specs, ", ") + " (" + string(windows.Policy) + ")"
+}
+
+func printJobs(jobs []worker.JobInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAME\tKIND\tPRIORITY\tNEXT RUN\tSERVICES")
+
+	for _, job := range jobs {
+		priority := strconv.Itoa(job.Priority)
+		if job.Kind == "compaction" {
+			priority = "-"
+		}
+
+		next := "-"
+		if !job.Next.IsZero() {
+			next = job.Next.Format(time.RFC3339)
+		}
+
+		services := "-"
+		if len(job.Services) > 0 {
+			services = strings.Join(job.Services, ",")
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", job.Name, job.Kind, priority, next, services)
+	}
+
+	_ = tw.Flush()
 }
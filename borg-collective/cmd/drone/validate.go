@@ -0,0 +1,159 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/docker/docker/client"
+	"github.com/integrii/flaggy"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/borg"
+	"github.com/vemilyus/borg-collective/internal/drone/borg/api"
+	"github.com/vemilyus/borg-collective/internal/drone/config"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/logging"
+)
+
+const validateCommand = "validate"
+
+// validate checks the config file and the labels of all containers enabled
+// for borgd, and exits with a non-zero code if any problem was found.
+func validate(args []string) {
+	var validateConfigPath string
+
+	parser := flaggy.NewParser("borgd " + validateCommand)
+	parser.Description = "Checks the configuration file and container labels for problems"
+	parser.Version = version
+	parser.AddPositionalValue(&validateConfigPath, "CONFIG-PATH", 1, true, "Path to the configuration file")
+	parser.Bool(&config.Verbose, "", "verbose", "Enable verbose log output")
+
+	if err := parser.ParseArgs(args); err != nil {
+		parser.ShowHelpAndExit(err.Error())
+	}
+
+	logging.InitLogging()
+
+	if config.Verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	ctx := context.Background()
+	problems := 0
+
+	cfg, err := config.LoadConfig(validateConfigPath)
+	if err != nil {
+		log.Error().Err(err).Str("path", validateConfigPath).Msg("failed to load config file")
+		problems++
+	} else {
+		for _, problem := range cfg.Validate() {
+			log.Error().Err(problem).Msg("invalid config")
+			problems++
+		}
+
+		problems += validateRepository(*cfg)
+	}
+
+	problems += validateContainerLabels(ctx, cfg)
+
+	if problems > 0 {
+		log.Error().Int("problems", problems).Msg("validation failed")
+		os.Exit(1)
+	}
+
+	log.Info().Msg("validation successful")
+}
+
+func validateRepository(cfg config.Config) int {
+	borgClient, err := borg.NewClient(cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("borg not usable")
+		return 1
+	}
+
+	_, err = borgClient.Info()
+	if err != nil {
+		var borgError api.Error
+		if errors.As(err, &borgError) && borgError.IsRecoverable() {
+			log.Warn().
+				Err(err).
+				Str("repository", cfg.Repo.Location).
+				Msg("repository not found, it will be initialized on the first run")
+
+			return 0
+		}
+
+		log.Error().
+			Err(err).
+			Str("repository", cfg.Repo.Location).
+			Msg("repository not reachable")
+
+		return 1
+	}
+
+	log.Info().Str("repository", cfg.Repo.Location).Msg("repository is reachable")
+
+	return 0
+}
+
+// validateContainerLabels checks the labels of all engines, and that the
+// project names don't collide with the jobs of cfg, if it could be loaded.
+func validateContainerLabels(ctx context.Context, cfg *config.Config) int {
+	problems := 0
+
+	rawDockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		log.Warn().Err(err).Msg("Docker not available, skipping container labels")
+	} else {
+		problems += validateEngineLabels(ctx, docker.NewClient(rawDockerClient), cfg)
+	}
+
+	if podmanClient := newPodmanClient(); podmanClient != nil {
+		problems += validateEngineLabels(ctx, podmanClient, cfg)
+	}
+
+	return problems
+}
+
+func validateEngineLabels(ctx context.Context, containerClient *docker.Client, cfg *config.Config) int {
+	engine := string(containerClient.Engine())
+
+	projectNames, problems, err := containerClient.ValidateProjects(ctx)
+	if err != nil {
+		if client.IsErrConnectionFailed(err) {
+			log.Warn().Err(err).Str("engine", engine).Msg("container engine not available, skipping container labels")
+			return 0
+		}
+
+		log.Error().Err(err).Str("engine", engine).Msg("failed to inspect containers")
+		return 1
+	}
+
+	if cfg != nil {
+		problems = append(problems, cfg.ValidateProjectNames(projectNames)...)
+	}
+
+	for _, problem := range problems {
+		log.Error().Err(problem).Str("engine", engine).Msg("invalid container labels")
+	}
+
+	return len(problems)
+}
@@ -27,9 +27,16 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/utils"
 )
 
 func Run(ctx context.Context, command []string, env map[string]string, input io.Reader, result any) (returnCode ReturnCode, logMessages []LogMessage, err error) {
+	return RunInDir(ctx, "", command, env, input, result)
+}
+
+// RunInDir is like Run, but runs borg in dir, relative paths passed to borg
+// are resolved against it.
+func RunInDir(ctx context.Context, dir string, command []string, env map[string]string, input io.Reader, result any) (returnCode ReturnCode, logMessages []LogMessage, err error) {
 	logTag := rand.Text()
 
 	finalCommand := []string{"--log-json"}
@@ -37,12 +44,8 @@ func Run(ctx context.Context, command []string, env map[string]string, input io.
 
 	log.Debug().Ctx(ctx).Str("tag", logTag).Msgf("command: borg %s", strings.Join(finalCommand, " "))
 
-	var cmd *exec.Cmd
-	if ctx != nil {
-		cmd = exec.CommandContext(ctx, "borg", finalCommand...)
-	} else {
-		cmd = exec.Command("borg", finalCommand...)
-	}
+	cmd := utils.Command(ctx, "borg", finalCommand...)
+	cmd.Dir = dir
 
 	if input != nil {
 		log.Debug().Str("tag", logTag).Msg("providing data to stdin")
@@ -92,9 +95,9 @@ func Run(ctx context.Context, command []string, env map[string]string, input io.
 		err = cmd.Run()
 	}
 
-	if ctx != nil && errors.Is(ctx.Err(), context.Canceled) {
-		log.Debug().Ctx(ctx).Str("tag", logTag).Msg("context canceled")
-		return -1, nil, ctx.Err()
+	if ctx != nil && ctx.Err() != nil {
+		log.Debug().Ctx(ctx).Str("tag", logTag).Err(context.Cause(ctx)).Msg("context done")
+		return -1, nil, context.Cause(ctx)
 	}
 
 	log.Debug().Ctx(ctx).Str("tag", logTag).Err(err).Msgf("command exited with code %d", cmd.ProcessState.ExitCode())
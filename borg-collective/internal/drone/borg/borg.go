@@ -17,11 +17,13 @@ package borg
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -72,6 +74,13 @@ func (b *Client) SetConfig(config config.Config) {
 	b.config = config
 }
 
+func (b *Client) RepositoryLocation() string {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	return b.config.Repo.Location
+}
+
 func (b *Client) Version() (*semver.Version, error) {
 	log.Debug().Msg("determining borg version")
 
@@ -135,28 +144,44 @@ func (b *Client) Init() error {
 	return api.HandleBorgReturnCode(returnCode, logMessages)
 }
 
-func (b *Client) CreateWithPaths(archiveName string, paths []string) (api.CreateOutput, error) {
+func (b *Client) CreateWithPaths(ctx context.Context, archiveName string, paths []string) (api.CreateOutput, error) {
 	for _, path := range paths {
 		if !filepath.IsAbs(path) {
 			return api.CreateOutput{}, fmt.Errorf("path %s is not an absolute path", path)
 		}
 	}
 
-	args := []string{"create", "--json", "--compression", "zlib,6"}
+	args, env := b.createArgs(archiveName, paths...)
 
-	b.configLock.RLock()
-	args = b.setRsh(args)
-	args = append(args, fmt.Sprintf("%s::%s", b.config.Repo.Location, archiveName))
-	args = append(args, paths...)
+	log.Info().Ctx(ctx).Strs("paths", paths).Msgf("creating archive: %v", archiveName)
 
-	env := b.env()
-	b.configLock.RUnlock()
+	var stats api.CreateOutput
+	returnCode, logMessages, err := api.Run(ctx, args, env, nil, &stats)
+	if err != nil {
+		if ctx.Err() != nil {
+			return api.CreateOutput{}, err
+		}
+
+		return api.CreateOutput{}, fmt.Errorf("failed to run borg create with paths: %w", err)
+	}
 
-	log.Info().Strs("paths", paths).Msgf("creating archive: %v", archiveName)
+	return stats, api.HandleBorgReturnCode(returnCode, logMessages)
+}
+
+// CreateWithPathsIn archives the paths with dir as the working directory, so
+// relative paths are resolved against dir and stored in the archive as given.
+func (b *Client) CreateWithPathsIn(ctx context.Context, archiveName, dir string, paths []string) (api.CreateOutput, error) {
+	args, env := b.createArgs(archiveName, paths...)
+
+	log.Info().Ctx(ctx).Str("dir", dir).Strs("paths", paths).Msgf("creating archive: %v", archiveName)
 
 	var stats api.CreateOutput
-	returnCode, logMessages, err := api.Run(nil, args, env, nil, &stats)
+	returnCode, logMessages, err := api.RunInDir(ctx, dir, args, env, nil, &stats)
 	if err != nil {
+		if ctx.Err() != nil {
+			return api.CreateOutput{}, err
+		}
+
 		return api.CreateOutput{}, fmt.Errorf("failed to run borg create with paths: %w", err)
 	}
 
@@ -168,22 +193,14 @@ func (b *Client) CreateWithInput(ctx context.Context, archiveName string, input
 		panic("input cannot be nil")
 	}
 
-	args := []string{"create", "--json", "--compression", "zlib,6"}
-
-	b.configLock.RLock()
-	args = b.setRsh(args)
-	args = append(args, fmt.Sprintf("%s::%s", b.config.Repo.Location, archiveName))
-	args = append(args, "-")
-
-	env := b.env()
-	b.configLock.RUnlock()
+	args, env := b.createArgs(archiveName, "-")
 
 	log.Info().Ctx(ctx).Msgf("creating archive from input: %v", archiveName)
 
 	var stats api.CreateOutput
 	returnCode, logMessages, err := api.Run(ctx, args, env, input, &stats)
 	if err != nil {
-		if errors.Is(err, context.Canceled) {
+		if ctx.Err() != nil {
 			return api.CreateOutput{}, err
 		}
 
@@ -193,21 +210,36 @@ func (b *Client) CreateWithInput(ctx context.Context, archiveName string, input
 	return stats, api.HandleBorgReturnCode(returnCode, logMessages)
 }
 
-func (b *Client) Compact() error {
-	args := []string{"compact"}
+// ImportTar creates the archive from the tar stream, the entries are stored in
+// the archive as named in the stream.
+func (b *Client) ImportTar(ctx context.Context, archiveName string, input io.Reader) (api.CreateOutput, error) {
+	if input == nil {
+		panic("input cannot be nil")
+	}
 
-	b.configLock.RLock()
-	args = b.setRsh(args)
+	args, env := b.archiveArgs("import-tar", archiveName, "-")
 
-	repoLocation := b.config.Repo.Location
-	args = append(args, repoLocation)
+	log.Info().Ctx(ctx).Msgf("creating archive from tar stream: %v", archiveName)
 
-	env := b.env()
-	b.configLock.RUnlock()
+	var stats api.CreateOutput
+	returnCode, logMessages, err := api.Run(ctx, args, env, input, &stats)
+	if err != nil {
+		if ctx.Err() != nil {
+			return api.CreateOutput{}, err
+		}
 
-	log.Info().Msgf("compacting repository: %v", repoLocation)
+		return api.CreateOutput{}, fmt.Errorf("failed to run borg import-tar: %w", err)
+	}
 
-	returnCode, logMessages, err := api.Run(nil, args, env, nil, nil)
+	return stats, api.HandleBorgReturnCode(returnCode, logMessages)
+}
+
+func (b *Client) Compact(ctx context.Context) error {
+	args, env := b.compactArgs()
+
+	log.Info().Ctx(ctx).Msgf("compacting repository: %v", args[len(args)-1])
+
+	returnCode, logMessages, err := api.Run(ctx, args, env, nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed to run borg compact: %w", err)
 	}
@@ -215,6 +247,141 @@ func (b *Client) Compact() error {
 	return api.HandleBorgReturnCode(returnCode, logMessages)
 }
 
+// KeepRules select the archives kept by Prune, an archive kept by one rule
+// doesn't count towards the following ones.
+type KeepRules struct {
+	Within  string
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+// Prune deletes the archives matching the glob that aren't kept by the rules.
+// The space is only freed by compacting the repository.
+func (b *Client) Prune(ctx context.Context, glob string, keep KeepRules) error {
+	args, env := b.pruneArgs(glob, keep)
+
+	log.Info().Ctx(ctx).Str("glob", glob).Msgf("pruning archives: %v", args[len(args)-1])
+
+	returnCode, logMessages, err := api.Run(ctx, args, env, nil, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+
+		return fmt.Errorf("failed to run borg prune: %w", err)
+	}
+
+	return api.HandleBorgReturnCode(returnCode, logMessages)
+}
+
+// CreateCommandLine returns the command line that CreateWithPaths would run,
+// use "-" as the only path for CreateWithInput. Secrets are masked.
+func (b *Client) CreateCommandLine(archiveName string, paths []string) []string {
+	args, env := b.createArgs(archiveName, paths...)
+	return commandLine(args, env)
+}
+
+// ImportTarCommandLine returns the command line that ImportTar would run.
+// Secrets are masked.
+func (b *Client) ImportTarCommandLine(archiveName string) []string {
+	args, env := b.archiveArgs("import-tar", archiveName, "-")
+	return commandLine(args, env)
+}
+
+// CompactCommandLine returns the command line that Compact would run. Secrets
+// are masked.
+func (b *Client) CompactCommandLine() []string {
+	args, env := b.compactArgs()
+	return commandLine(args, env)
+}
+
+// PruneCommandLine returns the command line that Prune would run. Secrets are
+// masked.
+func (b *Client) PruneCommandLine(glob string, keep KeepRules) []string {
+	args, env := b.pruneArgs(glob, keep)
+	return commandLine(args, env)
+}
+
+func (b *Client) createArgs(archiveName string, inputs ...string) ([]string, map[string]string) {
+	return b.archiveArgs("create", archiveName, inputs...)
+}
+
+func (b *Client) archiveArgs(command, archiveName string, inputs ...string) ([]string, map[string]string) {
+	args := []string{command, "--json", "--compression", "zlib,6"}
+
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	args = b.setRsh(args)
+	args = append(args, fmt.Sprintf("%s::%s", b.config.Repo.Location, archiveName))
+	args = append(args, inputs...)
+
+	return args, b.env()
+}
+
+func (b *Client) pruneArgs(glob string, keep KeepRules) ([]string, map[string]string) {
+	args := []string{"prune", "--glob-archives", glob}
+	if keep.Within != "" {
+		args = append(args, "--keep-within", keep.Within)
+	}
+
+	for _, rule := range []struct {
+		flag  string
+		count int
+	}{
+		{"--keep-hourly", keep.Hourly},
+		{"--keep-daily", keep.Daily},
+		{"--keep-weekly", keep.Weekly},
+		{"--keep-monthly", keep.Monthly},
+		{"--keep-yearly", keep.Yearly},
+	} {
+		if rule.count > 0 {
+			args = append(args, rule.flag, strconv.Itoa(rule.count))
+		}
+	}
+
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	args = b.setRsh(args)
+	args = append(args, b.config.Repo.Location)
+
+	return args, b.env()
+}
+
+func (b *Client) compactArgs() ([]string, map[string]string) {
+	args := []string{"compact"}
+
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	args = b.setRsh(args)
+	args = append(args, b.config.Repo.Location)
+
+	return args, b.env()
+}
+
+const maskedSecret = "********"
+
+func commandLine(args []string, env map[string]string) []string {
+	result := make([]string, 0, len(env)+len(args)+1)
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		value := env[key]
+		if key == "BORG_PASSPHRASE" {
+			value = maskedSecret
+		}
+
+		result = append(result, key+"="+value)
+	}
+
+	result = append(result, "borg")
+
+	return append(result, args...)
+}
+
 func defaultEnv() map[string]string {
 	return map[string]string{
 		"LANG":            "en_US.UTF-8",
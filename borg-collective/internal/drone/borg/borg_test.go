@@ -101,7 +101,7 @@ func TestBorgCreateWithPaths(t *testing.T) {
 	err = os.WriteFile(file, randomData, 0644)
 	assert.NoError(t, err)
 
-	result, err := borgClient.CreateWithPaths("some-backup", []string{dir})
+	result, err := borgClient.CreateWithPaths(context.Background(), "some-backup", []string{dir})
 	assert.NoError(t, err)
 	assert.NotNil(t, result.Archive.Stats)
 }
@@ -135,6 +135,66 @@ func TestBorgCompact(t *testing.T) {
 	err = borgClient.Init()
 	assert.NoError(t, err)
 
-	err = borgClient.Compact()
+	err = borgClient.Compact(context.Background())
 	assert.NoError(t, err)
 }
+
+func TestBorgCreateCommandLine(t *testing.T) {
+	secret := "s3cret"
+	identityFile := "/root/.ssh/id_borg"
+	borgClient := &Client{config: config.Config{
+		Repo:       config.RepositoryConfig{Location: "ssh://backup/repo", IdentityFile: &identityFile},
+		Encryption: &config.EncryptionConfig{Secret: &secret},
+	}}
+
+	assert.Equal(
+		t,
+		[]string{
+			"BORG_EXIT_CODES=modern",
+			"BORG_PASSPHRASE=" + maskedSecret,
+			"LANG=en_US.UTF-8",
+			"LC_CTYPE=en_US.UTF-8",
+			"borg", "create", "--json", "--compression", "zlib,6",
+			"--rsh", "ssh -i /root/.ssh/id_borg",
+			"ssh://backup/repo::archive",
+			"/data",
+		},
+		borgClient.CreateCommandLine("archive", []string{"/data"}),
+	)
+}
+
+func TestBorgImportTarCommandLine(t *testing.T) {
+	borgClient := &Client{config: config.Config{Repo: config.RepositoryConfig{Location: "/backup/repo"}}}
+
+	assert.Equal(
+		t,
+		[]string{
+			"BORG_EXIT_CODES=modern",
+			"LANG=en_US.UTF-8",
+			"LC_CTYPE=en_US.UTF-8",
+			"borg", "import-tar", "--json", "--compression", "zlib,6",
+			"/backup/repo::archive",
+			"-",
+		},
+		borgClient.ImportTarCommandLine("archive"),
+	)
+}
+
+func TestBorgPruneCommandLine(t *testing.T) {
+	borgClient := &Client{config: config.Config{Repo: config.RepositoryConfig{Location: "/backup/repo"}}}
+
+	assert.Equal(
+		t,
+		[]string{
+			"BORG_EXIT_CODES=modern",
+			"LANG=en_US.UTF-8",
+			"LC_CTYPE=en_US.UTF-8",
+			"borg", "prune", "--glob-archives", "paperless_db-[0-9]",
+			"--keep-within", "2d",
+			"--keep-daily", "7",
+			"--keep-monthly", "6",
+			"/backup/repo",
+		},
+		borgClient.PruneCommandLine("paperless_db-[0-9]", KeepRules{Within: "2d", Daily: 7, Monthly: 6}),
+	)
+}
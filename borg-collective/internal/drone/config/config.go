@@ -19,26 +19,94 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/pelletier/go-toml/v2"
 	"github.com/robfig/cron/v3"
+	"github.com/vemilyus/borg-collective/internal/drone/graph"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
 )
 
+// CompactionName is the name by which backups refer to repository compaction
+// in After and Requires.
+const CompactionName = "compaction"
+
 var (
-	DryRun  = false
-	Once    = false
-	Verbose = false
+	DryRun         = false
+	JsonOutput     = false
+	Once           = false
+	Only           []string
+	SkipCompaction = false
+	IgnoreWindows  = false
+	ListJobs       = false
+	Verbose        = false
 )
 
 type Config struct {
-	Options    *OptionsConfig
-	Repo       RepositoryConfig
-	Encryption *EncryptionConfig
-	Backups    []BackupConfig
+	// Include lists glob patterns of files whose backups are added to the
+	// config, relative patterns are resolved against the config file.
+	Include         []string `toml:",omitempty"`
+	includePatterns []string
+	Options         *OptionsConfig
+	Repo            RepositoryConfig
+	Encryption      *EncryptionConfig
+	Backups         []BackupConfig
 }
 
 type OptionsConfig struct {
-	TempDir string
+	TempDir             string
+	StateDir            *string
+	CatchUpDelay        *Duration
+	Jitter              *Duration
+	ShutdownGracePeriod *Duration
+}
+
+const (
+	defaultStateDir            = "/var/lib/borgd"
+	defaultCatchUpDelay        = 5 * time.Minute
+	defaultShutdownGracePeriod = time.Minute
+)
+
+func (c Config) TempDir() string {
+	if c.Options == nil || c.Options.TempDir == "" {
+		return os.TempDir()
+	}
+
+	return c.Options.TempDir
+}
+
+func (c Config) StateDir() string {
+	if c.Options == nil || c.Options.StateDir == nil || *c.Options.StateDir == "" {
+		return defaultStateDir
+	}
+
+	return *c.Options.StateDir
+}
+
+func (c Config) CatchUpDelay() time.Duration {
+	if c.Options == nil || c.Options.CatchUpDelay == nil {
+		return defaultCatchUpDelay
+	}
+
+	return c.Options.CatchUpDelay.Duration()
+}
+
+func (c Config) ShutdownGracePeriod() time.Duration {
+	if c.Options == nil || c.Options.ShutdownGracePeriod == nil {
+		return defaultShutdownGracePeriod
+	}
+
+	return c.Options.ShutdownGracePeriod.Duration()
+}
+
+func (c Config) Jitter() time.Duration {
+	if c.Options == nil || c.Options.Jitter == nil {
+		return 0
+	}
+
+	return c.Options.Jitter.Duration()
 }
 
 type RepositoryConfig struct {
@@ -46,6 +114,8 @@ type RepositoryConfig struct {
 	IdentityFile             *string
 	CompactionScheduleValue  *string `toml:"CompactionSchedule"`
 	compactionScheduleParsed cron.Schedule
+	CompactionAfter          []string
+	CompactionRequires       []string
 }
 
 func (rc RepositoryConfig) CompactionSchedule() cron.Schedule {
@@ -58,20 +128,40 @@ type EncryptionConfig struct {
 }
 
 type BackupConfig struct {
-	Name           string
-	ScheduleValue  string `toml:"Schedule"`
-	scheduleParsed cron.Schedule
-	Exec           *ExecBackupConfig
-	Paths          *PathsBackupConfig
-	PreCommand     []string
-	PostCommand    []string
-	FinallyCommand []string
+	Name            string
+	ScheduleValue   string `toml:"Schedule"`
+	scheduleParsed  cron.Schedule
+	Priority        int
+	CatchUp         bool
+	Jitter          *Duration
+	Timeout         *Duration
+	AllowedWindows  []string
+	BlackoutWindows []string
+	WindowPolicy    string
+	windowsParsed   window.Windows
+	After           []string
+	Requires        []string
+	Exec            *ExecBackupConfig
+	Paths           *PathsBackupConfig
+	PreCommand      []string
+	PostCommand     []string
+	FinallyCommand  []string
 }
 
 func (bc BackupConfig) Schedule() cron.Schedule {
 	return bc.scheduleParsed
 }
 
+func (bc BackupConfig) Windows() window.Windows {
+	return bc.windowsParsed
+}
+
+// HasPredecessors reports whether the backup is run after other jobs, in which
+// case its schedule is optional.
+func (bc BackupConfig) HasPredecessors() bool {
+	return len(bc.After) > 0 || len(bc.Requires) > 0
+}
+
 type ExecBackupConfig struct {
 	Command []string
 	Stdout  *bool
@@ -80,27 +170,28 @@ type ExecBackupConfig struct {
 
 type PathsBackupConfig struct {
 	Paths []string
+	// Snapshot is the provider used to snapshot the paths before they are
+	// backed up, the paths are backed up directly if it's empty.
+	Snapshot snapshot.Kind `toml:",omitempty"`
 }
 
+// LoadConfig loads the config file at path and the files it includes, and
+// interpolates references to environment variables and files.
 func LoadConfig(path string) (*Config, error) {
-	configReader, err := os.Open(path)
-	if err != nil {
+	var conf Config
+	if err := decodeFile(path, &conf, false); err != nil {
 		return nil, err
 	}
 
-	defer func() { _ = configReader.Close() }()
-
-	decoder := toml.NewDecoder(configReader)
-
-	var conf Config
-	if err = decoder.Decode(&conf); err != nil {
+	if err := conf.loadIncludes(path); err != nil {
 		return nil, err
 	}
 
+	var errs []error
 	if conf.Repo.CompactionScheduleValue != nil {
 		schedule, err := cron.ParseStandard(*conf.Repo.CompactionScheduleValue)
 		if err != nil {
-			return nil, fmt.Errorf("invalid compaction schedule %s: %v", *conf.Repo.CompactionScheduleValue, err)
+			errs = append(errs, fmt.Errorf("invalid compaction schedule %s: %v", *conf.Repo.CompactionScheduleValue, err))
 		}
 
 		conf.Repo.compactionScheduleParsed = schedule
@@ -108,18 +199,48 @@ func LoadConfig(path string) (*Config, error) {
 
 	if conf.Encryption != nil {
 		if conf.Encryption.Secret == nil && conf.Encryption.SecretCommand == nil {
-			return nil, errors.New("encryption config must specify either Secret or SecretCommand")
+			errs = append(errs, errors.New("encryption config must specify either Secret or SecretCommand"))
 		}
 	}
 
-	for _, backup := range conf.Backups {
-		schedule, err := cron.ParseStandard(backup.ScheduleValue)
+	for i := range conf.Backups {
+		backup := &conf.Backups[i]
+		if backup.ScheduleValue != "" || !backup.HasPredecessors() {
+			schedule, err := cron.ParseStandard(backup.ScheduleValue)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid backup schedule for %s (%s): %v", backup.Name, backup.ScheduleValue, err))
+			}
+
+			backup.scheduleParsed = schedule
+		}
+
+		windows, err := window.ParseWindows(backup.AllowedWindows, backup.BlackoutWindows, backup.WindowPolicy)
 		if err != nil {
-			return nil, fmt.Errorf("invalid backup schedule for %s (%s): %v", backup.Name, backup.ScheduleValue, err)
+			errs = append(errs, fmt.Errorf("invalid backup windows for %s: %v", backup.Name, err))
 		}
 
-		backup.scheduleParsed = schedule
+		backup.windowsParsed = windows
+	}
+
+	if cycle := graph.FindCycle(conf.predecessors()); cycle != nil {
+		errs = append(errs, fmt.Errorf("dependency cycle between backups: %s", strings.Join(cycle, " -> ")))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
 	return &conf, nil
 }
+
+// predecessors returns the jobs each backup and compaction are run after.
+func (c Config) predecessors() map[string][]string {
+	edges := make(map[string][]string, len(c.Backups)+1)
+	edges[CompactionName] = slices.Concat(c.Repo.CompactionAfter, c.Repo.CompactionRequires)
+
+	for _, backup := range c.Backups {
+		edges[backup.Name] = slices.Concat(backup.After, backup.Requires)
+	}
+
+	return edges
+}
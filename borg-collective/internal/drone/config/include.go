@@ -0,0 +1,121 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// includedConfig is the part of the config an included file may contain.
+type includedConfig struct {
+	Backups []BackupConfig
+}
+
+// decodeFile decodes the TOML file at path into v and interpolates it. With
+// strict set, keys that don't exist in v are an error.
+func decodeFile(path string, v any, strict bool) error {
+	reader, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	decoder := toml.NewDecoder(reader)
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err = decoder.Decode(v); err != nil {
+		return err
+	}
+
+	return interpolate(v, filepath.Dir(path))
+}
+
+// loadIncludes adds the backups of all included files to the config, in the
+// lexical order of their paths. A backup replaces an earlier backup with the
+// same name.
+func (c *Config) loadIncludes(path string) error {
+	baseDir := filepath.Dir(path)
+	seen := map[string]struct{}{filepath.Clean(path): {}}
+
+	c.includePatterns = make([]string, 0, len(c.Include))
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+
+		c.includePatterns = append(c.includePatterns, pattern)
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %s: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			if _, found := seen[match]; found {
+				continue
+			}
+
+			seen[match] = struct{}{}
+
+			var included includedConfig
+			if err = decodeFile(match, &included, true); err != nil {
+				return fmt.Errorf("included file %s: %w", match, err)
+			}
+
+			for _, backup := range included.Backups {
+				index := slices.IndexFunc(c.Backups, func(b BackupConfig) bool { return b.Name == backup.Name })
+				if index < 0 {
+					c.Backups = append(c.Backups, backup)
+				} else {
+					c.Backups[index] = backup
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// includeDirs returns the directories containing the included files.
+func (c Config) includeDirs() []string {
+	dirs := make([]string, 0, len(c.includePatterns))
+	for _, pattern := range c.includePatterns {
+		if dir := filepath.Dir(pattern); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// isIncluded reports whether the file at path matches an include pattern.
+func (c Config) isIncluded(path string) bool {
+	for _, pattern := range c.includePatterns {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+
+	return false
+}
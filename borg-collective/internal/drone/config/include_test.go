@@ -0,0 +1,120 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig_Include(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	assert.NoError(t, os.Mkdir(confDir, 0755))
+
+	cfgFile := filepath.Join(dir, "config.toml")
+	assert.NoError(t, os.WriteFile(cfgFile, []byte(`
+Include = ["conf.d/*.toml"]
+
+[Repo]
+Location = "/tmp/repo"
+
+[[Backups]]
+Name = "etc"
+Schedule = "@daily"
+Paths = { Paths = ["/etc"] }
+
+[[Backups]]
+Name = "home"
+Schedule = "@daily"
+Paths = { Paths = ["/home"] }
+`), 0644))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(confDir, "10-db.toml"), []byte(`
+[[Backups]]
+Name = "db"
+Schedule = "@hourly"
+Exec = { Command = ["pg_dumpall"], Stdout = true }
+`), 0644))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(confDir, "20-home.toml"), []byte(`
+[[Backups]]
+Name = "home"
+Schedule = "@weekly"
+Paths = { Paths = ["/home/user"] }
+`), 0644))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(confDir, "ignored.txt"), []byte(`not toml`), 0644))
+
+	cfg, err := LoadConfig(cfgFile)
+	assert.NoError(t, err)
+
+	names := make([]string, 0, len(cfg.Backups))
+	for _, backup := range cfg.Backups {
+		names = append(names, backup.Name)
+	}
+
+	assert.Equal(t, []string{"etc", "home", "db"}, names)
+	assert.Equal(t, []string{"/home/user"}, cfg.Backups[1].Paths.Paths)
+	assert.Equal(t, []string{confDir}, cfg.includeDirs())
+	assert.True(t, cfg.isIncluded(filepath.Join(confDir, "30-new.toml")))
+	assert.False(t, cfg.isIncluded(filepath.Join(confDir, "ignored.txt")))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(confDir, "30-repo.toml"), []byte(`
+[Repo]
+Location = "/tmp/other"
+`), 0644))
+
+	_, err = LoadConfig(cfgFile)
+	assert.ErrorContains(t, err, "included file "+filepath.Join(confDir, "30-repo.toml"))
+}
+
+func TestLoadConfig_Interpolation(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BORGD_TEST_REPO", "ssh://backup@example.com/./repo")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "secret"), []byte("s3cr3t\n"), 0600))
+
+	cfgFile := filepath.Join(dir, "config.toml")
+	assert.NoError(t, os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "${BORGD_TEST_REPO}"
+
+[Encryption]
+Secret = "${file:secret}"
+
+[[Backups]]
+Name = "literal"
+Schedule = "@daily"
+Exec = { Command = ["sh", "-c", "echo $${HOME}"], Paths = ["/tmp"] }
+`), 0644))
+
+	cfg, err := LoadConfig(cfgFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "ssh://backup@example.com/./repo", cfg.Repo.Location)
+	assert.Equal(t, "s3cr3t", *cfg.Encryption.Secret)
+	assert.Equal(t, []string{"sh", "-c", "echo ${HOME}"}, cfg.Backups[0].Exec.Command)
+
+	assert.NoError(t, os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "${BORGD_TEST_UNSET}"
+`), 0644))
+
+	_, err = LoadConfig(cfgFile)
+	assert.ErrorContains(t, err, "environment variable BORGD_TEST_UNSET is not set")
+}
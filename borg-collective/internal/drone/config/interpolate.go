@@ -0,0 +1,128 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// interpolate replaces ${NAME} with the value of the environment variable NAME
+// and ${file:PATH} with the contents of the file at PATH, without trailing
+// newlines, in every exported string field of v. Relative paths are resolved
+// against baseDir, $${ is replaced with a literal ${.
+func interpolate(v any, baseDir string) error {
+	return interpolateValue(reflect.ValueOf(v), baseDir)
+}
+
+func interpolateValue(v reflect.Value, baseDir string) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return interpolateValue(v.Elem(), baseDir)
+	case reflect.Struct:
+		var errs []error
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				errs = append(errs, interpolateValue(v.Field(i), baseDir))
+			}
+		}
+
+		return errors.Join(errs...)
+	case reflect.Slice, reflect.Array:
+		var errs []error
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, interpolateValue(v.Index(i), baseDir))
+		}
+
+		return errors.Join(errs...)
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+
+		expanded, err := expand(v.String(), baseDir)
+		if err != nil {
+			return err
+		}
+
+		v.SetString(expanded)
+	}
+
+	return nil
+}
+
+func expand(s string, baseDir string) (string, error) {
+	var result strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			result.WriteString(s)
+			return result.String(), nil
+		}
+
+		if start > 0 && s[start-1] == '$' {
+			result.WriteString(s[:start-1])
+			result.WriteString("${")
+			s = s[start+2:]
+
+			continue
+		}
+
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+
+		value, err := resolve(s[start+2:start+end], baseDir)
+		if err != nil {
+			return "", err
+		}
+
+		result.WriteString(s[:start])
+		result.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+func resolve(reference string, baseDir string) (string, error) {
+	if path, found := strings.CutPrefix(reference, "file:"); found {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ${%s}: %w", reference, err)
+		}
+
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	value, found := os.LookupEnv(reference)
+	if !found {
+		return "", fmt.Errorf("environment variable %s is not set", reference)
+	}
+
+	return value, nil
+}
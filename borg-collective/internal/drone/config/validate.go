@@ -0,0 +1,142 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Validate checks the config for problems that LoadConfig doesn't catch, and
+// that would otherwise only show up once a backup is run. Every problem found
+// is returned.
+func (c Config) Validate() []error {
+	problems := make([]error, 0)
+
+	if c.Repo.Location == "" {
+		problems = append(problems, errors.New("repository location must not be empty"))
+	}
+
+	if c.Repo.IdentityFile != nil {
+		problems = append(problems, checkPath("repository identity file", *c.Repo.IdentityFile, true)...)
+	}
+
+	if c.Options != nil && c.Options.StateDir != nil && *c.Options.StateDir != "" {
+		problems = append(problems, checkPath("state directory", *c.Options.StateDir, false)...)
+	}
+
+	names := make(map[string]struct{}, len(c.Backups))
+	for i, backup := range c.Backups {
+		if backup.Name == "" {
+			problems = append(problems, fmt.Errorf("backup #%d: name must not be empty", i+1))
+			continue
+		}
+
+		if _, found := names[backup.Name]; found {
+			problems = append(problems, fmt.Errorf("backup %s: duplicate name", backup.Name))
+		}
+
+		if backup.Name == CompactionName {
+			problems = append(problems, fmt.Errorf("backup %s: name is reserved for repository compaction", backup.Name))
+		}
+
+		names[backup.Name] = struct{}{}
+
+		for _, problem := range backup.validate() {
+			problems = append(problems, fmt.Errorf("backup %s: %w", backup.Name, problem))
+		}
+	}
+
+	return problems
+}
+
+// ValidateProjectNames checks that no container backup project is named like a
+// static backup or compaction. Jobs are referred to by name, so the worker
+// doesn't schedule a project with such a name.
+func (c Config) ValidateProjectNames(projectNames []string) []error {
+	problems := make([]error, 0)
+
+	for _, name := range projectNames {
+		if name == CompactionName {
+			problems = append(problems, fmt.Errorf("project %s: name is reserved for repository compaction", name))
+		} else if slices.ContainsFunc(c.Backups, func(backup BackupConfig) bool { return backup.Name == name }) {
+			problems = append(problems, fmt.Errorf("project %s: name is already used by a static backup", name))
+		}
+	}
+
+	return problems
+}
+
+func (bc BackupConfig) validate() []error {
+	problems := make([]error, 0)
+
+	if bc.Exec != nil && bc.Paths != nil {
+		problems = append(problems, errors.New("must not have both Exec and Paths"))
+	} else if bc.Exec == nil && bc.Paths == nil {
+		problems = append(problems, errors.New("must have either Exec or Paths"))
+	}
+
+	if bc.Exec != nil {
+		stdout := bc.Exec.Stdout != nil && *bc.Exec.Stdout
+
+		if len(bc.Exec.Command) == 0 {
+			problems = append(problems, errors.New("exec command must not be empty"))
+		}
+
+		if stdout && len(bc.Exec.Paths) > 0 {
+			problems = append(problems, errors.New("exec must not have both stdout and paths"))
+		} else if !stdout && len(bc.Exec.Paths) == 0 {
+			problems = append(problems, errors.New("exec must have either stdout or paths"))
+		}
+
+		// paths are only created by the exec command, so they can't be
+		// expected to exist yet
+		for _, path := range bc.Exec.Paths {
+			if !filepath.IsAbs(path) {
+				problems = append(problems, fmt.Errorf("exec path %s is not an absolute path", path))
+			}
+		}
+	}
+
+	if bc.Paths != nil {
+		if len(bc.Paths.Paths) == 0 {
+			problems = append(problems, errors.New("paths must not be empty"))
+		}
+
+		for _, path := range bc.Paths.Paths {
+			problems = append(problems, checkPath("path", path, true)...)
+		}
+	}
+
+	return problems
+}
+
+func checkPath(description, path string, mustExist bool) []error {
+	if !filepath.IsAbs(path) {
+		return []error{fmt.Errorf("%s %s is not an absolute path", description, path)}
+	}
+
+	if mustExist {
+		if _, err := os.Stat(path); err != nil {
+			return []error{fmt.Errorf("%s %s: %w", description, path, err)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
+)
+
+func TestConfigValidate(t *testing.T) {
+	existing := t.TempDir()
+	stdout := true
+
+	cfg := Config{
+		Repo: RepositoryConfig{Location: "/tmp/repo"},
+		Backups: []BackupConfig{
+			{Name: "ok", Paths: &PathsBackupConfig{Paths: []string{existing}}},
+			{Name: "missing", Paths: &PathsBackupConfig{Paths: []string{existing + "/missing", "relative"}}},
+			{Name: "both", Exec: &ExecBackupConfig{Command: []string{"true"}, Stdout: &stdout}, Paths: &PathsBackupConfig{Paths: []string{existing}}},
+			{Name: "ok"},
+		},
+	}
+
+	problems := cfg.Validate()
+
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+
+	assert.Equal(t, []string{
+		"backup missing: path " + existing + "/missing: stat " + existing + "/missing: no such file or directory",
+		"backup missing: path relative is not an absolute path",
+		"backup both: must not have both Exec and Paths",
+		"backup ok: duplicate name",
+		"backup ok: must have either Exec or Paths",
+	}, messages)
+}
+
+func TestLoadConfig_ReportsAllSchedules(t *testing.T) {
+	cfgFile := t.TempDir() + "/config.toml"
+	err := os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "/tmp/repo"
+
+[[Backups]]
+Name = "a"
+Schedule = "invalid"
+
+[[Backups]]
+Name = "b"
+Schedule = "also invalid"
+`), 0644)
+	assert.NoError(t, err)
+
+	_, err = LoadConfig(cfgFile)
+	assert.ErrorContains(t, err, "invalid backup schedule for a")
+	assert.ErrorContains(t, err, "invalid backup schedule for b")
+}
+
+func TestLoadConfig_Dependencies(t *testing.T) {
+	cfgFile := t.TempDir() + "/config.toml"
+	err := os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "/tmp/repo"
+CompactionRequires = ["db-dump", "app"]
+
+[[Backups]]
+Name = "db-dump"
+Schedule = "@daily"
+
+[[Backups]]
+Name = "files"
+After = ["db-dump"]
+`), 0644)
+	assert.NoError(t, err)
+
+	cfg, err := LoadConfig(cfgFile)
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.Backups[1].Schedule())
+
+	err = os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "/tmp/repo"
+CompactionAfter = ["a"]
+
+[[Backups]]
+Name = "a"
+Schedule = "@daily"
+Requires = ["b"]
+
+[[Backups]]
+Name = "b"
+After = ["compaction"]
+`), 0644)
+	assert.NoError(t, err)
+
+	_, err = LoadConfig(cfgFile)
+	assert.ErrorContains(t, err, "dependency cycle between backups: a -> b -> compaction -> a")
+}
+
+func TestLoadConfig_Snapshot(t *testing.T) {
+	cfgFile := t.TempDir() + "/config.toml"
+	err := os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "/tmp/repo"
+
+[[Backups]]
+Name = "files"
+Schedule = "@daily"
+
+[Backups.Paths]
+Paths = ["/srv/files"]
+Snapshot = "btrfs"
+`), 0644)
+	assert.NoError(t, err)
+
+	cfg, err := LoadConfig(cfgFile)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot.KindBtrfs, cfg.Backups[0].Paths.Snapshot)
+
+	err = os.WriteFile(cfgFile, []byte(`
+[Repo]
+Location = "/tmp/repo"
+
+[[Backups]]
+Name = "files"
+Schedule = "@daily"
+
+[Backups.Paths]
+Paths = ["/srv/files"]
+Snapshot = "ext4"
+`), 0644)
+	assert.NoError(t, err)
+
+	_, err = LoadConfig(cfgFile)
+	assert.ErrorContains(t, err, "unrecognized snapshot provider: ext4")
+}
+
+func TestConfigValidateProjectNames(t *testing.T) {
+	cfg := Config{Backups: []BackupConfig{{Name: "paperless"}}}
+
+	problems := cfg.ValidateProjectNames([]string{"gitea", "paperless", CompactionName})
+
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+
+	assert.Equal(t, []string{
+		"project paperless: name is already used by a static backup",
+		"project compaction: name is reserved for repository compaction",
+	}, messages)
+}
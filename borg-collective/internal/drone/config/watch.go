@@ -18,15 +18,21 @@ package config
 import (
 	"context"
 	"fmt"
-	"github.com/rs/zerolog"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// includeDebounce is how long to wait for further changes to included files
+// before reloading, so that creating and then writing a file reloads once.
+const includeDebounce = 100 * time.Millisecond
+
 type Watch struct {
 	updates chan Config
 	err     chan error
@@ -40,7 +46,35 @@ func (w *Watch) Errors() <-chan error {
 	return w.err
 }
 
+// publish sends the reloaded config, returns false if the watch ended before
+// it was received.
+func (w *Watch) publish(ctx context.Context, config Config) bool {
+	select {
+	case w.updates <- config:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// publishError sends the error that ends the watch, unless the watch ended
+// before it was received.
+func (w *Watch) publishError(ctx context.Context, err error) {
+	select {
+	case w.err <- err:
+	case <-ctx.Done():
+	}
+}
+
+// NewWatch watches the config file at path, the files it includes and their
+// directories, and publishes the reloaded config whenever the config file is
+// written or an included file is added, changed or removed.
 func NewWatch(ctx context.Context, path string) (*Watch, error) {
+	initial, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -51,15 +85,29 @@ func NewWatch(ctx context.Context, path string) (*Watch, error) {
 		err:     make(chan error),
 	}
 
+	state := &watchState{
+		watcher: watcher,
+		path:    path,
+		current: *initial,
+		watched: make(map[string]struct{}),
+	}
+
+	if err = state.sync(); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
 	go func() {
-		watchingParentDir := false
+		// an error ends the watch
+		defer func() { _ = watcher.Close() }()
+
 		var lastOp fsnotify.Op
+		var reload <-chan time.Time
 
 		for {
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
-					_ = watcher.Close()
 					return
 				}
 
@@ -70,89 +118,165 @@ func NewWatch(ctx context.Context, path string) (*Watch, error) {
 						Msg("received config watch event")
 				}
 
-				if event.Has(fsnotify.Remove) {
-					if watchingParentDir {
-						watch.err <- fmt.Errorf("config file parent directory was removed: %s", filepath.Dir(path))
-						return
+				if event.Has(fsnotify.Remove) && state.isWatchedDir(event.Name) {
+					watch.publishError(ctx, fmt.Errorf("watched config directory was removed: %s", event.Name))
+					return
+				}
+
+				if event.Name != path {
+					if state.current.isIncluded(event.Name) {
+						log.Debug().Str("path", event.Name).Msg("included config file changed")
+						reload = time.After(includeDebounce)
 					}
 
+					continue
+				}
+
+				if event.Has(fsnotify.Remove) {
 					log.Debug().Msg("config file was removed")
 					lastOp = fsnotify.Remove
 
 					// need to add dir-watch due to removal
-					err = watcher.Add(filepath.Dir(event.Name))
-					if err != nil {
-						watch.err <- err
+					delete(state.watched, path)
+					state.mainRemoved = true
+
+					if err = state.sync(); err != nil {
+						watch.publishError(ctx, err)
 						return
 					}
 
-					watchingParentDir = true
-
 					continue
 				}
 
 				lastOp = 0
 
-				if event.Has(fsnotify.Write) && event.Name == path {
-					if watchingParentDir {
-						err = watcher.Remove(filepath.Dir(event.Name))
-						if err != nil {
-							watch.err <- err
-							return
-						}
-						watchingParentDir = false
+				if event.Has(fsnotify.Write) {
+					if state.mainRemoved {
+						state.mainRemoved = false
 
-						err = watcher.Add(path)
-						if err != nil {
-							watch.err <- err
+						if err = state.sync(); err != nil {
+							watch.publishError(ctx, err)
 							return
 						}
 					}
 
 					log.Info().Msg("config file changed")
-					config, err := LoadConfig(path)
-					if err != nil {
-						var evt *zerolog.Event
-						if strings.HasPrefix(err.Error(), "toml:") {
-							evt = log.Debug()
-						} else {
-							evt = log.Warn()
-						}
-
-						if evt.Enabled() {
-							evt.Err(err).Str("path", path).Msg("failed to load config file")
-						}
-
-						continue
+					if state.reload() && !watch.publish(ctx, state.current) {
+						return
 					}
+				}
+			case <-reload:
+				reload = nil
 
-					watch.updates <- *config
+				log.Info().Msg("included config files changed")
+				if state.reload() && !watch.publish(ctx, state.current) {
+					return
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
 				}
-				watch.err <- err
+				watch.publishError(ctx, err)
 				return
 			case <-time.After(500 * time.Millisecond):
 				if lastOp > 0 {
-					watch.err <- fmt.Errorf("timed out after last op %s", lastOp.String())
+					watch.publishError(ctx, fmt.Errorf("timed out after last op %s", lastOp.String()))
 					return
 				}
 			case <-ctx.Done():
-				_ = watcher.Close()
 				return
 			}
 		}
 	}()
 
-	err = watcher.Add(path)
+	log.Info().Msgf("watching config file for changes: %s", path)
+
+	return watch, nil
+}
+
+// watchState tracks what is watched for a config file. The config file is
+// watched through its parent directory while it is removed, or if the parent
+// directory is watched anyway as include directory.
+type watchState struct {
+	watcher     *fsnotify.Watcher
+	path        string
+	current     Config
+	watched     map[string]struct{}
+	mainRemoved bool
+}
+
+func (s *watchState) isWatchedDir(path string) bool {
+	_, found := s.watched[path]
+	return found && path != s.path
+}
+
+// reload loads the config and updates the watched include directories.
+// Returns whether the config was loaded successfully.
+func (s *watchState) reload() bool {
+	config, err := LoadConfig(s.path)
 	if err != nil {
-		_ = watcher.Close()
-		return nil, err
+		var evt *zerolog.Event
+		if strings.HasPrefix(err.Error(), "toml:") {
+			evt = log.Debug()
+		} else {
+			evt = log.Warn()
+		}
+
+		if evt.Enabled() {
+			evt.Err(err).Str("path", s.path).Msg("failed to load config file")
+		}
+
+		return false
 	}
 
-	log.Info().Msgf("watching config file for changes: %s", path)
+	s.current = *config
+	if err = s.sync(); err != nil {
+		log.Warn().Err(err).Str("path", s.path).Msg("failed to update config watch")
+	}
 
-	return watch, nil
+	return true
+}
+
+// sync adds and removes watches, so that exactly the config file or its parent
+// directory and the include directories are watched. Include directories that
+// can't be watched are skipped.
+func (s *watchState) sync() error {
+	parentDir := filepath.Dir(s.path)
+
+	wanted := make(map[string]struct{})
+	for _, dir := range s.current.includeDirs() {
+		wanted[dir] = struct{}{}
+	}
+
+	if s.mainRemoved {
+		wanted[parentDir] = struct{}{}
+	} else if _, found := wanted[parentDir]; !found {
+		wanted[s.path] = struct{}{}
+	}
+
+	for path := range s.watched {
+		if _, found := wanted[path]; !found {
+			_ = s.watcher.Remove(path)
+			delete(s.watched, path)
+		}
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(wanted)) {
+		if _, found := s.watched[path]; found {
+			continue
+		}
+
+		if err := s.watcher.Add(path); err != nil {
+			if path == s.path || path == parentDir {
+				return err
+			}
+
+			log.Warn().Err(err).Str("path", path).Msg("failed to watch include directory")
+			continue
+		}
+
+		s.watched[path] = struct{}{}
+	}
+
+	return nil
 }
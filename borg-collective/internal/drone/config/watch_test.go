@@ -153,3 +153,50 @@ func TestConfigWatch_ConfigFileRemovedTimeout(t *testing.T) {
 	assert.Empty(t, cfgs)
 	assert.Equal(t, 1, len(errs))
 }
+
+func TestConfigWatch_IncludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	confDir := dir + "/conf.d"
+	assert.NoError(t, os.Mkdir(confDir, 0755))
+
+	cfgFile := dir + "/config.toml"
+	cfg := Config{Include: []string{"conf.d/*.toml"}, Repo: RepositoryConfig{Location: "/tmp/" + rand.Text()}}
+
+	err := writeConfig(cfgFile, cfg)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watch, err := NewWatch(ctx, cfgFile)
+	assert.NoError(t, err)
+
+	backupCounts := make([]int, 0)
+	errs := make([]error, 0)
+
+	go func() {
+		defer cancel()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case update := <-watch.Updates():
+				backupCounts = append(backupCounts, len(update.Backups))
+			case err = <-watch.Errors():
+				errs = append(errs, err)
+			}
+		}
+	}()
+
+	fragment := confDir + "/backup.toml"
+	err = os.WriteFile(fragment, []byte("[[Backups]]\nName = \"etc\"\nSchedule = \"@daily\"\n"), 0644)
+	assert.NoError(t, err)
+
+	// must exceed the debounce delay, otherwise both changes are reloaded once
+	time.Sleep(200 * time.Millisecond)
+
+	err = os.Remove(fragment)
+	assert.NoError(t, err)
+
+	<-ctx.Done()
+
+	assert.Empty(t, errs)
+	assert.Equal(t, []int{1, 0}, backupCounts)
+}
@@ -0,0 +1,379 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package compose reads the x-borgd extension fields of compose files. They
+// configure a project and its services like the io.v47.borgd labels do, and
+// are translated into those labels.
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// labels set by compose on the containers of a project
+const (
+	LabelProject     = "com.docker.compose.project"
+	LabelConfigFiles = "com.docker.compose.project.config_files"
+	LabelWorkingDir  = "com.docker.compose.project.working_dir"
+	LabelService     = "com.docker.compose.service"
+)
+
+// Project is the x-borgd field at the top level of a compose file.
+type Project struct {
+	ProjectName    string           `yaml:"project_name"`
+	When           string           `yaml:"when"`
+	Priority       *int             `yaml:"priority"`
+	CatchUp        *bool            `yaml:"catch_up"`
+	Jitter         string           `yaml:"jitter"`
+	Timeout        string           `yaml:"timeout"`
+	Window         string           `yaml:"window"`
+	WindowPolicy   string           `yaml:"window_policy"`
+	After          []string         `yaml:"after"`
+	Requires       []string         `yaml:"requires"`
+	PreExec        Command          `yaml:"pre_exec"`
+	PostExec       Command          `yaml:"post_exec"`
+	FinallyExec    Command          `yaml:"finally_exec"`
+	HooksContainer string           `yaml:"hooks_container"`
+	Retention      *model.Retention `yaml:"retention"`
+}
+
+// Service is the x-borgd field of a service, services with the field are
+// enabled for borgd unless enabled is false.
+type Service struct {
+	Enabled        *bool           `yaml:"enabled"`
+	ServiceName    string          `yaml:"service_name"`
+	When           string          `yaml:"when"`
+	Mode           string          `yaml:"mode"`
+	MaxDowntime    string          `yaml:"max_downtime"`
+	Dependencies   []string        `yaml:"dependencies"`
+	Exec           *Exec           `yaml:"exec"`
+	Execs          map[string]Exec `yaml:"execs"`
+	Volumes        Volumes         `yaml:"volumes"`
+	VolumesExclude []string        `yaml:"volumes_exclude"`
+	Snapshot       string          `yaml:"snapshot"`
+	Preset         string          `yaml:"preset"`
+	Database       string          `yaml:"database"`
+	Archives       string          `yaml:"archives"`
+	PreExec        Command         `yaml:"pre_exec"`
+	PostExec       Command         `yaml:"post_exec"`
+	FinallyExec    Command         `yaml:"finally_exec"`
+	HooksOnHost    *bool           `yaml:"hooks_on_host"`
+}
+
+type Exec struct {
+	Command     Command  `yaml:"command"`
+	Stdout      bool     `yaml:"stdout"`
+	Paths       []string `yaml:"paths"`
+	PathsSource string   `yaml:"paths_source"`
+}
+
+// Command is given either as a command line or as a list of arguments.
+type Command []string
+
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*c = utils.SplitCommandLine(node.Value)
+		return nil
+	}
+
+	var args []string
+	if err := node.Decode(&args); err != nil {
+		return err
+	}
+
+	*c = args
+
+	return nil
+}
+
+// Volumes is given either as all or named, or as a list of destinations.
+type Volumes struct {
+	Mode         string
+	Destinations []string
+}
+
+func (v *Volumes) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		v.Mode = node.Value
+		return nil
+	}
+
+	return node.Decode(&v.Destinations)
+}
+
+type file struct {
+	Borgd    *Project `yaml:"x-borgd"`
+	Services map[string]struct {
+		Borgd *Service `yaml:"x-borgd"`
+	} `yaml:"services"`
+}
+
+// Config holds the x-borgd fields of the compose files of a project, as
+// labels.
+type Config struct {
+	project  map[string]string
+	services map[string]map[string]string
+}
+
+// HasService reports whether the service has an x-borgd field.
+func (c *Config) HasService(service string) bool {
+	_, found := c.services[service]
+	return found
+}
+
+// Labels returns the labels of the service, including the project labels.
+// It returns nil if neither the project nor the service have an x-borgd
+// field.
+func (c *Config) Labels(service string) map[string]string {
+	if len(c.project) == 0 && !c.HasService(service) {
+		return nil
+	}
+
+	labels := maps.Clone(c.project)
+	maps.Copy(labels, c.services[service])
+
+	return labels
+}
+
+// Loader loads compose files, the files are only read again once they
+// change.
+type Loader struct {
+	mutex sync.Mutex
+	files map[string]cachedFile
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	config  *Config
+}
+
+func NewLoader() *Loader {
+	return &Loader{files: make(map[string]cachedFile)}
+}
+
+// LoadForContainer loads the compose files of the container from the labels
+// set by compose. It returns nil if the container wasn't created by compose.
+func (l *Loader) LoadForContainer(labels map[string]string) (*Config, error) {
+	configFiles := strings.TrimSpace(labels[LabelConfigFiles])
+	if configFiles == "" {
+		return nil, nil
+	}
+
+	var paths []string
+	for _, path := range strings.Split(configFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(labels[LabelWorkingDir], path)
+		}
+
+		paths = append(paths, path)
+	}
+
+	return l.Load(paths)
+}
+
+// Load loads the compose files, later files override the fields of earlier
+// ones like compose does.
+func (l *Loader) Load(paths []string) (*Config, error) {
+	result := &Config{project: make(map[string]string), services: make(map[string]map[string]string)}
+	for _, path := range paths {
+		config, err := l.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		maps.Copy(result.project, config.project)
+		for service, labels := range config.services {
+			if result.services[service] == nil {
+				result.services[service] = make(map[string]string)
+			}
+
+			maps.Copy(result.services[service], labels)
+		}
+	}
+
+	return result, nil
+}
+
+func (l *Loader) loadFile(path string) (*Config, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if cached, found := l.files[path]; found && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.config, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+
+	config, err := parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compose file %s: %w", path, err)
+	}
+
+	l.files[path] = cachedFile{modTime: stat.ModTime(), size: stat.Size(), config: config}
+
+	return config, nil
+}
+
+func parse(content []byte) (*Config, error) {
+	var f file
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, err
+	}
+
+	result := &Config{project: make(map[string]string), services: make(map[string]map[string]string)}
+	if f.Borgd != nil {
+		result.project = f.Borgd.labels()
+	}
+
+	var errs []error
+	for name, service := range f.Services {
+		if service.Borgd == nil {
+			continue
+		}
+
+		labels, err := service.Borgd.labels(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", name, err))
+			continue
+		}
+
+		result.services[name] = labels
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return result, nil
+}
+
+func (p *Project) labels() map[string]string {
+	labels := make(map[string]string)
+	setString(labels, model.LabelProjectName, p.ProjectName)
+	setString(labels, model.LabelProjectWhen, p.When)
+	setString(labels, model.LabelProjectJitter, p.Jitter)
+	setString(labels, model.LabelProjectTimeout, p.Timeout)
+	setString(labels, model.LabelProjectWindow, p.Window)
+	setString(labels, model.LabelProjectWindowPolicy, p.WindowPolicy)
+	setString(labels, model.LabelProjectAfter, strings.Join(p.After, ","))
+	setString(labels, model.LabelProjectRequires, strings.Join(p.Requires, ","))
+	setString(labels, model.LabelProjectPreExec, utils.JoinCommandLine(p.PreExec))
+	setString(labels, model.LabelProjectPostExec, utils.JoinCommandLine(p.PostExec))
+	setString(labels, model.LabelProjectFinallyExec, utils.JoinCommandLine(p.FinallyExec))
+	setString(labels, model.LabelProjectHooksContainer, p.HooksContainer)
+
+	if p.Priority != nil {
+		labels[model.LabelProjectPriority] = strconv.Itoa(*p.Priority)
+	}
+
+	if p.CatchUp != nil {
+		labels[model.LabelProjectCatchUp] = strconv.FormatBool(*p.CatchUp)
+	}
+
+	if p.Retention != nil {
+		labels[model.LabelProjectRetention] = p.Retention.String()
+	}
+
+	return labels
+}
+
+func (s *Service) labels(name string) (map[string]string, error) {
+	labels := map[string]string{model.LabelBorgdEnabled: "true", model.LabelServiceName: name}
+	if s.Enabled != nil {
+		labels[model.LabelBorgdEnabled] = strconv.FormatBool(*s.Enabled)
+	}
+
+	setString(labels, model.LabelServiceName, s.ServiceName)
+	setString(labels, model.LabelServiceWhen, s.When)
+	setString(labels, model.LabelBackupMode, s.Mode)
+	setString(labels, model.LabelMaxDowntime, s.MaxDowntime)
+	setString(labels, model.LabelVolumes, s.Volumes.Mode)
+	setString(labels, model.LabelVolumesExclude, strings.Join(s.VolumesExclude, ","))
+	setString(labels, model.LabelSnapshot, s.Snapshot)
+	setString(labels, model.LabelPreset, s.Preset)
+	setString(labels, model.LabelPresetDatabase, s.Database)
+	setString(labels, model.LabelArchives, s.Archives)
+	setString(labels, model.LabelPreExec, utils.JoinCommandLine(s.PreExec))
+	setString(labels, model.LabelPostExec, utils.JoinCommandLine(s.PostExec))
+	setString(labels, model.LabelFinallyExec, utils.JoinCommandLine(s.FinallyExec))
+
+	for i, dependency := range s.Dependencies {
+		labels[model.LabelDependenciesPfx+strconv.Itoa(i)] = dependency
+	}
+
+	for i, destination := range s.Volumes.Destinations {
+		labels[model.LabelVolumesPfx+strconv.Itoa(i)] = destination
+	}
+
+	if s.HooksOnHost != nil {
+		labels[model.LabelHooksOnHost] = strconv.FormatBool(*s.HooksOnHost)
+	}
+
+	if s.Exec != nil {
+		setExec(labels, model.LabelExec, model.LabelExecStdout, model.LabelExecPathsPfx, model.LabelExecPathsSource, *s.Exec)
+	}
+
+	for execName, exec := range s.Execs {
+		if execName == "" || strings.ContainsAny(execName, ". ") {
+			return nil, fmt.Errorf("invalid exec name %q", execName)
+		}
+
+		pfx := model.LabelExecPfx + execName
+		setExec(labels, pfx, pfx+".stdout", pfx+".paths.", pfx+".paths_source", exec)
+	}
+
+	return labels, nil
+}
+
+func setExec(labels map[string]string, commandKey, stdoutKey, pathsPfx, pathsSourceKey string, exec Exec) {
+	setString(labels, commandKey, utils.JoinCommandLine(exec.Command))
+	setString(labels, pathsSourceKey, exec.PathsSource)
+
+	if exec.Stdout {
+		labels[stdoutKey] = "true"
+	}
+
+	for i, path := range exec.Paths {
+		labels[pathsPfx+strconv.Itoa(i)] = path
+	}
+}
+
+func setString(labels map[string]string, key, value string) {
+	if value != "" {
+		labels[key] = value
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+const composeFile = `
+x-borgd:
+  when: "0 3 * * *"
+  priority: 10
+  retention:
+    within: 2d
+    daily: 7
+  post_exec: [curl, -fsS, "https://hc.example.com/ping"]
+
+services:
+  db:
+    image: postgres
+    x-borgd:
+      mode: dependent-paused
+      preset: postgres
+      database: paperless
+      volumes: [/var/lib/postgresql/data]
+      execs:
+        export:
+          command: sh -c "pg_dumpall --globals-only"
+          stdout: true
+  server:
+    image: paperless
+    x-borgd:
+      service_name: web
+      when: "@hourly"
+      dependencies: [db]
+      volumes: named
+      volumes_exclude: [/tmp]
+      exec:
+        command: [document_exporter, ../export]
+        paths: [/usr/src/paperless/export]
+  broker:
+    image: redis
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	loader := NewLoader()
+
+	config, err := loader.Load([]string{writeFile(t, "compose.yml", composeFile)})
+	assert.NoError(t, err)
+
+	assert.False(t, config.HasService("broker"))
+	assert.Equal(t, "0 3 * * *", config.Labels("broker")[model.LabelProjectWhen])
+	assert.Empty(t, config.Labels("broker")[model.LabelBorgdEnabled])
+	assert.Equal(t, map[string]string{
+		model.LabelBorgdEnabled:              "true",
+		model.LabelProjectWhen:               "0 3 * * *",
+		model.LabelProjectPriority:           "10",
+		model.LabelProjectRetention:          "within=2d,daily=7",
+		model.LabelProjectPostExec:           "curl -fsS https://hc.example.com/ping",
+		model.LabelServiceName:               "db",
+		model.LabelBackupMode:                "dependent-paused",
+		model.LabelPreset:                    "postgres",
+		model.LabelPresetDatabase:            "paperless",
+		model.LabelVolumesPfx + "0":          "/var/lib/postgresql/data",
+		model.LabelExecPfx + "export":        `sh -c "pg_dumpall --globals-only"`,
+		model.LabelExecPfx + "export.stdout": "true",
+	}, config.Labels("db"))
+
+	server := config.Labels("server")
+	assert.Equal(t, "web", server[model.LabelServiceName])
+	assert.Equal(t, "@hourly", server[model.LabelServiceWhen])
+	assert.Equal(t, "db", server[model.LabelDependenciesPfx+"0"])
+	assert.Equal(t, "named", server[model.LabelVolumes])
+	assert.Equal(t, "/tmp", server[model.LabelVolumesExclude])
+	assert.Equal(t, "document_exporter ../export", server[model.LabelExec])
+	assert.Equal(t, "/usr/src/paperless/export", server[model.LabelExecPathsPfx+"0"])
+}
+
+func TestLoad_Override(t *testing.T) {
+	loader := NewLoader()
+	override := writeFile(t, "compose.override.yml", `
+x-borgd:
+  when: "@daily"
+services:
+  db:
+    x-borgd:
+      enabled: false
+`)
+
+	config, err := loader.Load([]string{writeFile(t, "compose.yml", composeFile), override})
+	assert.NoError(t, err)
+	assert.Equal(t, "@daily", config.Labels("db")[model.LabelProjectWhen])
+	assert.Equal(t, "false", config.Labels("db")[model.LabelBorgdEnabled])
+	assert.Equal(t, "postgres", config.Labels("db")[model.LabelPreset])
+}
+
+func TestLoadForContainer(t *testing.T) {
+	loader := NewLoader()
+
+	config, err := loader.LoadForContainer(map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	path := writeFile(t, "compose.yml", composeFile)
+	config, err = loader.LoadForContainer(map[string]string{
+		LabelConfigFiles: filepath.Base(path),
+		LabelWorkingDir:  filepath.Dir(path),
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, config.Labels("db"))
+
+	// files are read again once they change
+	assert.NoError(t, os.WriteFile(path, []byte("services: {}\n# changed\n"), 0o600))
+	config, err = loader.LoadForContainer(map[string]string{LabelConfigFiles: path})
+	assert.NoError(t, err)
+	assert.False(t, config.HasService("db"))
+	assert.Nil(t, config.Labels("db"))
+
+	_, err = loader.LoadForContainer(map[string]string{LabelConfigFiles: path + ".missing"})
+	assert.ErrorContains(t, err, "failed to read compose file")
+
+	assert.NoError(t, os.WriteFile(path, []byte("services: [\n"), 0o600))
+	_, err = loader.LoadForContainer(map[string]string{LabelConfigFiles: path})
+	assert.ErrorContains(t, err, "failed to parse compose file")
+}
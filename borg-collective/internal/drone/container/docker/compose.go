@@ -0,0 +1,90 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"context"
+	"maps"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/container/compose"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+// hasComposeService reports whether the service of the container has an
+// x-borgd field in its compose files.
+func (c *Client) hasComposeService(ctx context.Context, ctnr container.Summary) bool {
+	config, err := c.compose.LoadForContainer(ctnr.Labels)
+	if err != nil {
+		log.Debug().
+			Ctx(ctx).
+			Err(err).
+			Str("engine", (string)(c.engine)).
+			Str("container", ctnr.ID).
+			Msg("failed to load compose files")
+
+		return false
+	}
+
+	return config != nil && config.HasService(ctnr.Labels[compose.LabelService])
+}
+
+// withComposeLabels returns the inspection with the labels from the x-borgd
+// fields of its compose files added, the labels of the container take
+// precedence. The project name defaults to the name of the compose project.
+func (c *Client) withComposeLabels(ctx context.Context, inspect container.InspectResponse) container.InspectResponse {
+	if inspect.Config == nil {
+		return inspect
+	}
+
+	config, err := c.compose.LoadForContainer(inspect.Config.Labels)
+	if err != nil {
+		// the compose files of other containers needn't be readable
+		level := zerolog.DebugLevel
+		if isBorgdEnabled(inspect) {
+			level = zerolog.WarnLevel
+		}
+
+		log.WithLevel(level).
+			Ctx(ctx).
+			Err(err).
+			Str("engine", (string)(c.engine)).
+			Str("container", inspect.ID).
+			Msg("failed to load compose files, ignoring x-borgd fields")
+
+		return inspect
+	} else if config == nil {
+		return inspect
+	}
+
+	labels := config.Labels(inspect.Config.Labels[compose.LabelService])
+	if labels == nil {
+		return inspect
+	}
+
+	maps.Copy(labels, inspect.Config.Labels)
+	if _, found := labels[model.LabelProjectName]; !found && labels[compose.LabelProject] != "" {
+		labels[model.LabelProjectName] = labels[compose.LabelProject]
+	}
+
+	inspectConfig := *inspect.Config
+	inspectConfig.Labels = labels
+	inspect.Config = &inspectConfig
+
+	return inspect
+}
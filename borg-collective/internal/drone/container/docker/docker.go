@@ -23,6 +23,7 @@ import (
 	"io"
 	"maps"
 	"os"
+	"reflect"
 	"regexp"
 	"slices"
 	"strings"
@@ -32,9 +33,12 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/container/compose"
 	"github.com/vemilyus/borg-collective/internal/drone/container/model"
 	"github.com/vemilyus/borg-collective/internal/utils"
 )
@@ -44,15 +48,51 @@ const defaultTimeout = 30 * time.Second
 
 type Client struct {
 	dc         *client.Client
+	engine     model.ContainerEngine
+	podName    func(ctx context.Context, containerID string) (string, error)
 	cacheMutex sync.Mutex
 	cache      map[string]model.ContainerBackupProject
+	compose    *compose.Loader
+	// swarmManager is set if the engine is a Swarm manager, guarded by the
+	// cache mutex
+	swarmManager bool
+}
+
+// EngineOptions describe a container engine that provides a Docker compatible
+// API.
+type EngineOptions struct {
+	Engine model.ContainerEngine
+	// PodName returns the name of the pod of a container, or an empty string if
+	// it isn't part of one. Containers in a pod without a project name label
+	// belong to the project named after the pod.
+	PodName func(ctx context.Context, containerID string) (string, error)
 }
 
 func NewClient(dc *client.Client) *Client {
+	return NewEngineClient(dc, EngineOptions{Engine: model.ContainerEngineDocker})
+}
+
+func NewEngineClient(dc *client.Client, opts EngineOptions) *Client {
 	return &Client{
-		dc:    dc,
-		cache: make(map[string]model.ContainerBackupProject),
+		dc:      dc,
+		engine:  opts.Engine,
+		podName: opts.PodName,
+		cache:   make(map[string]model.ContainerBackupProject),
+		compose: compose.NewLoader(),
+	}
+}
+
+func (c *Client) Engine() model.ContainerEngine {
+	return c.engine
+}
+
+func (c *Client) IsContainerRunning(ctx context.Context, containerID string) (bool, error) {
+	inspect, err := c.dc.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, err
 	}
+
+	return inspect.State.Running, nil
 }
 
 func (c *Client) EnsureContainerRunning(ctx context.Context, containerID string) error {
@@ -61,7 +101,7 @@ func (c *Client) EnsureContainerRunning(ctx context.Context, containerID string)
 
 	log.Debug().
 		Ctx(ctx).
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
 		Str("container", containerID).
 		Msg("checking if container is running:")
 
@@ -69,7 +109,7 @@ func (c *Client) EnsureContainerRunning(ctx context.Context, containerID string)
 		if !inspect.State.Running {
 			log.Info().
 				Ctx(ctx).
-				Str("engine", (string)(model.ContainerEngineDocker)).
+				Str("engine", (string)(c.engine)).
 				Str("container", containerID).
 				Msg("starting container")
 
@@ -124,7 +164,7 @@ func (c *Client) EnsureContainerStopped(ctx context.Context, containerID string)
 
 	log.Debug().
 		Ctx(ctx).
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
 		Str("container", containerID).
 		Msg("checking if container is stopped")
 
@@ -132,7 +172,7 @@ func (c *Client) EnsureContainerStopped(ctx context.Context, containerID string)
 		if inspect.State.Status != container.StateExited {
 			log.Info().
 				Ctx(ctx).
-				Str("engine", (string)(model.ContainerEngineDocker)).
+				Str("engine", (string)(c.engine)).
 				Str("container", containerID).
 				Msg("stopping container")
 
@@ -149,10 +189,64 @@ func (c *Client) EnsureContainerStopped(ctx context.Context, containerID string)
 	return nil
 }
 
-func (c *Client) Exec(ctx context.Context, containerID string, cmd []string) error {
+func (c *Client) PauseContainer(ctx context.Context, containerID string) (bool, error) {
+	inspect, err := c.dc.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, err
+	}
+
+	if !inspect.State.Running || inspect.State.Paused {
+		log.Debug().
+			Ctx(ctx).
+			Str("engine", (string)(c.engine)).
+			Str("container", containerID).
+			Str("status", inspect.State.Status).
+			Msg("not pausing container")
+
+		return false, nil
+	}
+
 	log.Info().
 		Ctx(ctx).
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
+		Str("container", containerID).
+		Msg("pausing container")
+
+	err = c.dc.ContainerPause(ctx, containerID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Client) UnpauseContainer(ctx context.Context, containerID string) error {
+	log.Info().
+		Ctx(ctx).
+		Str("engine", (string)(c.engine)).
+		Str("container", containerID).
+		Msg("unpausing container")
+
+	return c.dc.ContainerUnpause(ctx, containerID)
+}
+
+// DisplayCommand returns the command as Exec and ExecWithOutput would run it,
+// for showing it to the user. References to the environment of the container
+// are replaced by a mask instead of their values, as they are usually secrets.
+// References to variables that aren't set are kept as they are.
+func (c *Client) DisplayCommand(ctx context.Context, containerID string, cmd []string) ([]string, error) {
+	inspect, err := c.dc.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return maskCmd(cmd, utils.ToMap(inspect.Config.Env)), nil
+}
+
+func (c *Client) Exec(ctx context.Context, containerID string, cmd []string, env []string) error {
+	log.Info().
+		Ctx(ctx).
+		Str("engine", (string)(c.engine)).
 		Str("command", strings.Join(cmd, " ")).
 		Str("container", containerID).
 		Msg("executing command in container")
@@ -168,7 +262,7 @@ func (c *Client) Exec(ctx context.Context, containerID string, cmd []string) err
 	exec, err := c.dc.ContainerExecCreate(
 		ctx,
 		containerID,
-		container.ExecOptions{Cmd: cmd},
+		container.ExecOptions{Cmd: cmd, Env: env},
 	)
 
 	if err != nil {
@@ -183,6 +277,22 @@ func (c *Client) Exec(ctx context.Context, containerID string, cmd []string) err
 	return c.waitForExec(ctx, exec.ID)
 }
 
+func (c *Client) CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, error) {
+	log.Info().
+		Ctx(ctx).
+		Str("engine", (string)(c.engine)).
+		Str("container", containerID).
+		Str("path", srcPath).
+		Msg("copying path from container")
+
+	content, _, err := c.dc.CopyFromContainer(ctx, containerID, srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
 type execAttachWrapper struct {
 	io.Reader
 	response    types.HijackedResponse
@@ -210,7 +320,7 @@ func (e *execAttachWrapper) Error() error {
 func (c *Client) ExecWithOutput(ctx context.Context, containerID string, cmd []string) (utils.ErrorReader, error) {
 	log.Info().
 		Ctx(ctx).
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
 		Strs("command", cmd).
 		Str("container", containerID).
 		Msg("executing command (for output) in container")
@@ -253,11 +363,20 @@ func (c *Client) ExecWithOutput(ctx context.Context, containerID string, cmd []s
 		err:    make(chan error, 1),
 	}
 
+	// unblocks the copy below if the context is done before the exec finishes
+	stopClose := context.AfterFunc(ctx, attach.Close)
+
 	go func() {
 		defer func() { _ = writer.Close() }()
+		defer stopClose()
 
 		_, err = stdcopy.StdCopy(writer, nil, attach.Reader)
 		if err != nil {
+			if ctx.Err() != nil {
+				c.killExec(exec.ID)
+				err = context.Cause(ctx)
+			}
+
 			wrapper.err <- err
 			return
 		}
@@ -273,6 +392,11 @@ func (c *Client) waitForExec(ctx context.Context, execID string) error {
 	for {
 		execInspect, err := c.dc.ContainerExecInspect(ctx, execID)
 		if err != nil {
+			if ctx.Err() != nil {
+				c.killExec(execID)
+				return context.Cause(ctx)
+			}
+
 			return err
 		}
 
@@ -282,7 +406,7 @@ func (c *Client) waitForExec(ctx context.Context, execID string) error {
 				log.Error().
 					Ctx(ctx).
 					Err(err).
-					Str("engine", (string)(model.ContainerEngineDocker)).
+					Str("engine", (string)(c.engine)).
 					Str("container", execInspect.ContainerID).
 					Int("exitCode", execInspect.ExitCode).
 					Msg("container exec failed")
@@ -293,7 +417,12 @@ func (c *Client) waitForExec(ctx context.Context, execID string) error {
 			break
 		}
 
-		time.Sleep(loopBackoff)
+		select {
+		case <-ctx.Done():
+			c.killExec(execID)
+			return context.Cause(ctx)
+		case <-time.After(loopBackoff):
+		}
 	}
 
 	return nil
@@ -302,12 +431,139 @@ func (c *Client) waitForExec(ctx context.Context, execID string) error {
 func (c *Client) ReadProjects(ctx context.Context) ([]model.ContainerBackupProject, error) {
 	log.Info().
 		Ctx(ctx).
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
 		Msg("reading container backup projects")
 
 	c.cacheMutex.Lock()
 	defer c.cacheMutex.Unlock()
 
+	projects, err := c.readProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Collect(maps.Values(projects)), nil
+}
+
+// Resync reads the projects again and returns those that changed since they
+// were last read or updated by a watch, to catch up on the events missed while
+// not watching. Projects that no longer exist are returned without containers.
+func (c *Client) Resync(ctx context.Context) ([]model.ContainerBackupProject, error) {
+	log.Info().
+		Ctx(ctx).
+		Str("engine", (string)(c.engine)).
+		Msg("resyncing container backup projects")
+
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	previous := c.cache
+	projects, err := c.readProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []model.ContainerBackupProject
+	for _, name := range slices.Sorted(maps.Keys(projects)) {
+		if prev, found := previous[name]; !found || !reflect.DeepEqual(prev, projects[name]) {
+			changed = append(changed, projects[name])
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(previous)) {
+		if _, found := projects[name]; !found {
+			removed := previous[name]
+			removed.Containers = make(map[string]model.ContainerBackup)
+			changed = append(changed, removed)
+		}
+	}
+
+	return changed, nil
+}
+
+// readProjects reads the projects of all containers and replaces the cache
+// with them. Must be called with the cache mutex held.
+func (c *Client) readProjects(ctx context.Context) (map[string]model.ContainerBackupProject, error) {
+	c.refreshSwarmState(ctx)
+
+	containerList, err := c.listContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	projects, problems, err := c.collectProjects(ctx, containerList)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, problem := range problems {
+		log.Warn().
+			Ctx(ctx).
+			Err(problem.Err).
+			Str("engine", (string)(c.engine)).
+			Str("container", problem.ContainerID).
+			Msg("invalid borgd labels, skipping container")
+	}
+
+	c.cache = projects
+
+	return projects, nil
+}
+
+// LabelError describes a container whose borgd labels are invalid.
+type LabelError struct {
+	ContainerID   string
+	ContainerName string
+	Err           error
+}
+
+func (e LabelError) Error() string {
+	return fmt.Sprintf("container %s (%s): %v", e.ContainerName, e.ContainerID, e.Err)
+}
+
+func (e LabelError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateProjects inspects all containers enabled for borgd and returns the
+// names of their projects and every problem with their labels, including
+// references between the containers of a project.
+func (c *Client) ValidateProjects(ctx context.Context) ([]string, []error, error) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	c.refreshSwarmState(ctx)
+
+	containerList, err := c.listContainers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	projects, labelErrors, err := c.collectProjects(ctx, containerList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	problems := make([]error, 0, len(labelErrors))
+	for _, labelError := range labelErrors {
+		problems = append(problems, labelError)
+	}
+
+	for _, projectName := range slices.Sorted(maps.Keys(projects)) {
+		for _, problem := range projects[projectName].Validate() {
+			problems = append(problems, fmt.Errorf("project %s: %w", projectName, problem))
+		}
+	}
+
+	return slices.Sorted(maps.Keys(projects)), problems, nil
+}
+
+// listContainers lists the containers enabled for borgd, including the tasks
+// of services enabled for borgd and the containers enabled in their compose
+// files. Services are only listed on Swarm managers, on worker nodes only
+// tasks whose containers are labeled for borgd are found. Must be called with
+// the cache mutex held.
+func (c *Client) listContainers(ctx context.Context) ([]container.Summary, error) {
 	containerList, err := c.dc.ContainerList(
 		ctx,
 		container.ListOptions{
@@ -320,43 +576,114 @@ func (c *Client) ReadProjects(ctx context.Context) ([]model.ContainerBackupProje
 		return nil, err
 	}
 
+	composeList, err := c.dc.ContainerList(
+		ctx,
+		container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", compose.LabelConfigFiles)),
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ctnr := range composeList {
+		if !containsContainer(containerList, ctnr.ID) && c.hasComposeService(ctx, ctnr) {
+			containerList = append(containerList, ctnr)
+		}
+	}
+
+	if !c.swarmManager {
+		return containerList, nil
+	}
+
+	services, err := c.dc.ServiceList(
+		ctx,
+		swarm.ServiceListOptions{Filters: filters.NewArgs(filters.Arg("label", model.LabelBorgdEnabled))},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list swarm services: %w", err)
+	}
+
+	for _, service := range services {
+		tasks, err := c.serviceContainers(ctx, service.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range tasks {
+			if !containsContainer(containerList, task.ID) {
+				containerList = append(containerList, task)
+			}
+		}
+	}
+
+	return containerList, nil
+}
+
+func containsContainer(containerList []container.Summary, containerID string) bool {
+	return slices.ContainsFunc(containerList, func(ctnr container.Summary) bool { return ctnr.ID == containerID })
+}
+
+// resolveLabels adds the borgd labels configured outside the container, in its
+// Swarm service or its compose files. Must be called with the cache mutex
+// held.
+func (c *Client) resolveLabels(ctx context.Context, inspect container.InspectResponse) (container.InspectResponse, error) {
+	inspect, err := c.withServiceLabels(ctx, inspect)
+	if err != nil {
+		return inspect, err
+	}
+
+	return c.withComposeLabels(ctx, inspect), nil
+}
+
+func (c *Client) collectProjects(
+	ctx context.Context,
+	containerList []container.Summary,
+) (map[string]model.ContainerBackupProject, []LabelError, error) {
 	projects := make(map[string]model.ContainerBackupProject)
+	problems := make([]LabelError, 0)
 	for _, ctnr := range containerList {
 		inspect, err := c.dc.ContainerInspect(ctx, ctnr.ID)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		inspect, err = c.resolveLabels(ctx, inspect)
+		if err != nil {
+			return nil, nil, err
 		}
 
 		if !isBorgdEnabled(inspect) {
 			log.Info().
 				Ctx(ctx).
-				Str("engine", (string)(model.ContainerEngineDocker)).
+				Str("engine", (string)(c.engine)).
 				Str("container", ctnr.ID).
 				Msg("container not enabled for borgd")
 
 			continue
 		}
 
-		project, err := findOrCreateProject(projects, inspect)
-		if err != nil {
-			log.Warn().
+		if isInactiveTask(inspect) {
+			log.Debug().
 				Ctx(ctx).
-				Err(err).
-				Str("engine", (string)(model.ContainerEngineDocker)).
+				Str("engine", (string)(c.engine)).
 				Str("container", ctnr.ID).
-				Msg("failed to find or create project")
+				Msg("swarm task not running, skipping container")
 
 			continue
 		}
 
-		backup, err := mapInspectToContainerBackup(inspect)
-		if err != nil {
-			log.Warn().
-				Ctx(ctx).
-				Err(err).
-				Str("engine", (string)(model.ContainerEngineDocker)).
-				Str("container", ctnr.ID).
-				Msg("failed to map inspect to container backup")
+		project, projectErr := c.findOrCreateProject(ctx, projects, inspect)
+		backup, backupErr := c.mapInspectToContainerBackup(ctx, inspect)
+		if projectErr != nil || backupErr != nil {
+			problems = append(problems, LabelError{
+				ContainerID:   inspect.ID,
+				ContainerName: strings.TrimPrefix(inspect.Name, "/"),
+				Err:           errors.Join(projectErr, backupErr),
+			})
 
 			continue
 		}
@@ -366,7 +693,7 @@ func (c *Client) ReadProjects(ctx context.Context) ([]model.ContainerBackupProje
 				projectJson, _ := json.Marshal(project)
 				log.Debug().
 					Ctx(ctx).
-					Str("engine", (string)(model.ContainerEngineDocker)).
+					Str("engine", (string)(c.engine)).
 					RawJSON("project", projectJson).
 					Str("projectName", project.ProjectName).
 					Msg("found container backup project")
@@ -376,24 +703,47 @@ func (c *Client) ReadProjects(ctx context.Context) ([]model.ContainerBackupProje
 				backupJson, _ := json.Marshal(backup)
 				log.Debug().
 					Ctx(ctx).
-					Str("engine", (string)(model.ContainerEngineDocker)).
+					Str("engine", (string)(c.engine)).
 					RawJSON("backup", backupJson).
 					Str("container", ctnr.ID).
 					Msg("found container backup")
 			}
 		}
 
+		if existing, found := project.Containers[backup.ServiceName]; found && existing.SwarmTask && backup.SwarmTask {
+			log.Info().
+				Ctx(ctx).
+				Str("engine", (string)(c.engine)).
+				Str("container", ctnr.ID).
+				Str("backedUp", existing.ID).
+				Str("service", backup.ServiceName).
+				Msg("more than one task of the service runs on this node, skipping container")
+
+			continue
+		}
+
 		project.Containers[backup.ServiceName] = *backup
 		projects[project.ProjectName] = project
 	}
 
-	c.cache = projects
-
-	return slices.Collect(maps.Values(projects)), nil
+	return projects, problems, nil
 }
 
-func findOrCreateProject(projects map[string]model.ContainerBackupProject, inspect container.InspectResponse) (model.ContainerBackupProject, error) {
-	newProject, err := mapInspectToProject(inspect)
+func (c *Client) findOrCreateProject(
+	ctx context.Context,
+	projects map[string]model.ContainerBackupProject,
+	inspect container.InspectResponse,
+) (model.ContainerBackupProject, error) {
+	podName := ""
+	if _, found := inspect.Config.Labels[model.LabelProjectName]; !found && c.podName != nil {
+		var err error
+		podName, err = c.podName(ctx, inspect.ID)
+		if err != nil {
+			return model.ContainerBackupProject{}, fmt.Errorf("failed to determine pod of container %s: %w", inspect.ID, err)
+		}
+	}
+
+	newProject, err := mapInspectToProject(inspect, c.engine, podName)
 	if err != nil {
 		return model.ContainerBackupProject{}, err
 	}
@@ -401,9 +751,64 @@ func findOrCreateProject(projects map[string]model.ContainerBackupProject, inspe
 	project, found := projects[newProject.ProjectName]
 	if !found {
 		return *newProject, nil
-	} else {
-		return project, nil
 	}
+
+	serviceName := strings.TrimSpace(inspect.Config.Labels[model.LabelServiceName])
+	if !hasOtherServices(project, serviceName) {
+		// the container replaces the only one of the project, e.g. when it's
+		// recreated with changed labels
+		newProject.Containers = project.Containers
+		return *newProject, nil
+	}
+
+	if project.Schedule == nil {
+		// the other containers might only have schedules of their own
+		project.Schedule = newProject.Schedule
+	} else if newProject.Schedule != nil && !reflect.DeepEqual(project.Schedule, newProject.Schedule) {
+		return model.ContainerBackupProject{}, fmt.Errorf(
+			"project schedule %s conflicts with the schedule of the other containers of project %s",
+			strings.TrimSpace(inspect.Config.Labels[model.LabelProjectWhen]),
+			project.ProjectName,
+		)
+	}
+
+	return project, nil
+}
+
+func hasOtherServices(project model.ContainerBackupProject, serviceName string) bool {
+	for name := range project.Containers {
+		if name != serviceName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapInspectToContainerBackup maps the container and resolves the sources of
+// named volumes the engine doesn't report in the container itself.
+func (c *Client) mapInspectToContainerBackup(ctx context.Context, inspect container.InspectResponse) (*model.ContainerBackup, error) {
+	backup, err := mapInspectToContainerBackup(inspect, c.engine)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volumes := range [][]model.Volume{backup.BackupVolumes, backup.AllVolumes} {
+		for i := range volumes {
+			if volumes[i].Source != "" || volumes[i].Type != string(mount.TypeVolume) {
+				continue
+			}
+
+			volume, err := c.dc.VolumeInspect(ctx, volumes[i].Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to inspect volume %s: %w", volumes[i].Name, err)
+			}
+
+			volumes[i].Source = volume.Mountpoint
+		}
+	}
+
+	return backup, nil
 }
 
 func isBorgdEnabled(inspect container.InspectResponse) bool {
@@ -414,6 +819,19 @@ func isBorgdEnabled(inspect container.InspectResponse) bool {
 var envVarRegex = regexp.MustCompile(`&\{(\S+?)}|&\S+?`)
 
 func expandCmd(cmd []string, env map[string]string) []string {
+	return replaceEnvRefs(cmd, env, func(value string) string { return value })
+}
+
+// maskedValue replaces the values of environment references in commands that
+// are shown to the user.
+const maskedValue = "***"
+
+func maskCmd(cmd []string, env map[string]string) []string {
+	return replaceEnvRefs(cmd, env, func(string) string { return maskedValue })
+}
+
+func replaceEnvRefs(cmd []string, env map[string]string, replace func(value string) string) []string {
+	cmd = slices.Clone(cmd)
 	for i := range cmd {
 		cmd[i] = envVarRegex.ReplaceAllStringFunc(cmd[i], func(s string) string {
 			var name string
@@ -426,7 +844,7 @@ func expandCmd(cmd []string, env map[string]string) []string {
 			if !found {
 				return s
 			} else {
-				return value
+				return replace(value)
 			}
 		})
 	}
@@ -84,10 +84,10 @@ func TestDockerExec(t *testing.T) {
 
 	cn := containerName(proj, "test-container")
 	client := newClient()
-	err = client.Exec(context.Background(), cn, []string{"ash", "-c", "sleep 1; exit 0"})
+	err = client.Exec(context.Background(), cn, []string{"ash", "-c", "sleep 1; exit 0"}, nil)
 	assert.NoError(t, err)
 
-	err = client.Exec(context.Background(), cn, []string{"ash", "-c", "sleep 1; exit 1"})
+	err = client.Exec(context.Background(), cn, []string{"ash", "-c", "sleep 1; exit 1"}, nil)
 	assert.Error(t, err)
 }
 
@@ -0,0 +1,903 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package dockertest provides an in-process fake of the Docker Engine API, so
+// code using the Docker client can be tested without a Docker daemon.
+package dockertest
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/storage"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Container describes a container known to the fake engine.
+type Container struct {
+	ID      string
+	Name    string
+	Labels  map[string]string
+	Env     []string
+	Mounts  []container.MountPoint
+	Running bool
+	Paused  bool
+	// Health is the status the health check reports once the container has
+	// started, containers without it don't have a health check.
+	Health container.HealthStatus
+	// UpperDir is reported as the upper directory of the overlay2 graph driver.
+	UpperDir string
+	// Files maps in-container paths to the content of regular files, they're
+	// served by the archive API.
+	Files map[string]string
+}
+
+// ExecFunc runs a command in a container and returns its output and exit
+// code.
+type ExecFunc func(containerName string, cmd []string) (stdout []byte, exitCode int)
+
+type fakeContainer struct {
+	Container
+	healthStatus container.HealthStatus
+	// healthChecks is the number of inspections that still report the
+	// container as starting
+	healthChecks int
+}
+
+type fakeExec struct {
+	id          string
+	containerID string
+	cmd         []string
+	pid         int
+	running     bool
+	exitCode    int
+}
+
+// ExecPid is the PID reported for the first exec instance, every further
+// instance reports the next PID.
+const ExecPid = 1001
+
+// Service describes a Swarm service known to the fake engine, its tasks are
+// containers labelled with the ID of the service.
+type Service struct {
+	ID     string
+	Name   string
+	Labels map[string]string
+}
+
+// Server is a fake Docker Engine API server. It supports listing, inspecting,
+// starting, stopping, pausing and unpausing containers, running commands in
+// them, copying files out of them, inspecting volumes and Swarm services and
+// streaming container and service events.
+type Server struct {
+	server      *httptest.Server
+	mutex       sync.Mutex
+	containers  map[string]*fakeContainer
+	services    map[string]Service
+	swarm       swarm.Info
+	volumes     map[string]volume.Volume
+	execs       map[string]*fakeExec
+	execFunc    ExecFunc
+	seq         int
+	ops         []string
+	subscribers map[chan events.Message]struct{}
+	stopped     bool
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+// NewServer starts a fake engine without any containers, it must be closed
+// once it isn't needed anymore.
+func NewServer() *Server {
+	s := &Server{
+		containers:  make(map[string]*fakeContainer),
+		services:    make(map[string]Service),
+		swarm:       swarm.Info{LocalNodeState: swarm.LocalNodeStateInactive},
+		volumes:     make(map[string]volume.Volume),
+		execs:       make(map[string]*fakeExec),
+		subscribers: make(map[chan events.Message]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_ping", s.handlePing)
+	mux.HandleFunc("HEAD /_ping", s.handlePing)
+	mux.HandleFunc("GET /info", s.handleInfo)
+	mux.HandleFunc("GET /containers/json", s.handleContainerList)
+	mux.HandleFunc("GET /containers/{id}/json", s.handleContainerInspect)
+	mux.HandleFunc("POST /containers/{id}/start", s.handleContainerStart)
+	mux.HandleFunc("POST /containers/{id}/stop", s.handleContainerStop)
+	mux.HandleFunc("POST /containers/{id}/pause", s.handleContainerPause)
+	mux.HandleFunc("POST /containers/{id}/unpause", s.handleContainerUnpause)
+	mux.HandleFunc("GET /containers/{id}/archive", s.handleContainerArchive)
+	mux.HandleFunc("POST /containers/{id}/exec", s.handleExecCreate)
+	mux.HandleFunc("POST /exec/{id}/start", s.handleExecStart)
+	mux.HandleFunc("GET /exec/{id}/json", s.handleExecInspect)
+	mux.HandleFunc("GET /volumes/{name}", s.handleVolumeInspect)
+	mux.HandleFunc("GET /services", s.handleServiceList)
+	mux.HandleFunc("GET /services/{id}", s.handleServiceInspect)
+	mux.HandleFunc("GET /events", s.handleEvents)
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		stopped := s.stopped
+		s.mutex.Unlock()
+
+		if stopped {
+			writeError(w, http.StatusServiceUnavailable, "engine is stopped")
+			return
+		}
+
+		r.URL.Path = versionPrefix.ReplaceAllString(r.URL.Path, "/")
+		mux.ServeHTTP(w, r)
+	}))
+
+	return s
+}
+
+// Client returns a new Docker client connected to the fake engine.
+func (s *Server) Client() (*client.Client, error) {
+	return client.NewClientWithOpts(
+		client.WithHost("tcp://"+s.server.Listener.Addr().String()),
+		client.WithAPIVersionNegotiation(),
+	)
+}
+
+func (s *Server) Close() {
+	s.mutex.Lock()
+	for subscriber := range s.subscribers {
+		close(subscriber)
+	}
+
+	clear(s.subscribers)
+	s.mutex.Unlock()
+
+	s.server.Close()
+}
+
+// StopEngine simulates the daemon going away, e.g. for a restart. Event
+// streams end and all requests fail until StartEngine is called. Containers
+// can still be changed in the meantime, but no events are sent for them.
+func (s *Server) StopEngine() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopped = true
+	for subscriber := range s.subscribers {
+		close(subscriber)
+	}
+
+	clear(s.subscribers)
+}
+
+// StartEngine lets requests succeed again after StopEngine.
+func (s *Server) StartEngine() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopped = false
+}
+
+// SetExecFunc sets the function that runs commands in containers, by default
+// every command succeeds without output.
+func (s *Server) SetExecFunc(execFunc ExecFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.execFunc = execFunc
+}
+
+// AddContainer adds the container or replaces the one with the same ID, and
+// emits a create event.
+func (s *Server) AddContainer(ctnr Container) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := &fakeContainer{Container: ctnr, healthStatus: container.Starting}
+	if ctnr.Running && ctnr.Health != "" {
+		fc.healthStatus = ctnr.Health
+	}
+
+	s.containers[ctnr.ID] = fc
+	s.emit(events.ActionCreate, fc)
+}
+
+// RemoveContainer removes the container and emits a destroy event.
+func (s *Server) RemoveContainer(idOrName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(idOrName)
+	if fc == nil {
+		return
+	}
+
+	delete(s.containers, fc.ID)
+	s.emit(events.ActionDestroy, fc)
+}
+
+// SetRunning starts or stops the container without a client, and emits a start
+// or die event, like a container restarted by the engine.
+func (s *Server) SetRunning(idOrName string, running bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(idOrName)
+	if fc == nil || fc.Running == running {
+		return
+	}
+
+	fc.Running = running
+	if running {
+		if fc.Health != "" {
+			fc.healthStatus = fc.Health
+		}
+
+		s.emit(events.ActionStart, fc)
+	} else {
+		fc.Paused = false
+		s.emit(events.ActionDie, fc)
+	}
+}
+
+// JoinSwarm makes the engine a node of a Swarm, only managers can inspect
+// services.
+func (s *Server) JoinSwarm(nodeID string, manager bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.swarm = swarm.Info{
+		NodeID:           nodeID,
+		LocalNodeState:   swarm.LocalNodeStateActive,
+		ControlAvailable: manager,
+	}
+}
+
+// AddService adds the service or replaces the one with the same ID. A service
+// that is replaced emits an update event.
+func (s *Server) AddService(service Service) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, found := s.services[service.ID]
+	s.services[service.ID] = service
+
+	if found {
+		s.broadcast(events.Message{
+			Type:   events.ServiceEventType,
+			Action: events.ActionUpdate,
+			Actor: events.Actor{
+				ID:         service.ID,
+				Attributes: map[string]string{"name": service.Name},
+			},
+			Scope:    "swarm",
+			Time:     time.Now().Unix(),
+			TimeNano: time.Now().UnixNano(),
+		})
+	}
+}
+
+// AddVolume adds a named volume with the given mount point.
+func (s *Server) AddVolume(name, mountpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.volumes[name] = volume.Volume{Name: name, Driver: "local", Mountpoint: mountpoint}
+}
+
+// IsPaused reports whether the container exists and is paused.
+func (s *Server) IsPaused(idOrName string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(idOrName)
+	return fc != nil && fc.Paused
+}
+
+// IsRunning reports whether the container exists and is running.
+func (s *Server) IsRunning(idOrName string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(idOrName)
+	return fc != nil && fc.Running
+}
+
+// Ops returns the operations performed by clients in the order they happened,
+// as "start NAME", "stop NAME", "pause NAME", "unpause NAME" and
+// "exec NAME COMMAND".
+func (s *Server) Ops() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Clone(s.ops)
+}
+
+func (s *Server) find(idOrName string) *fakeContainer {
+	if fc, found := s.containers[idOrName]; found {
+		return fc
+	}
+
+	for _, fc := range s.containers {
+		if fc.Name == strings.TrimPrefix(idOrName, "/") {
+			return fc
+		}
+	}
+
+	return nil
+}
+
+// emit sends the event to all subscribers, must be called with the mutex held.
+// Events are dropped for subscribers that fall too far behind.
+func (s *Server) emit(action events.Action, fc *fakeContainer) {
+	// the engine adds the labels of the container to the attributes
+	attributes := maps.Clone(fc.Labels)
+	if attributes == nil {
+		attributes = make(map[string]string)
+	}
+
+	attributes["name"] = fc.Name
+
+	s.broadcast(events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         fc.ID,
+			Attributes: attributes,
+		},
+		Scope:    "local",
+		Time:     time.Now().Unix(),
+		TimeNano: time.Now().UnixNano(),
+	})
+}
+
+// broadcast sends the event to all subscribers, must be called with the mutex
+// held.
+func (s *Server) broadcast(event events.Message) {
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("API-Version", api.DefaultVersion)
+	w.Header().Set("OSType", "linux")
+	_, _ = w.Write([]byte("OK"))
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, _ *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, system.Info{
+		ID:            "fake",
+		Name:          "fake",
+		OSType:        "linux",
+		ServerVersion: api.DefaultVersion,
+		Swarm:         s.swarm,
+	})
+}
+
+func (s *Server) handleServiceList(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.swarm.ControlAvailable {
+		writeError(w, http.StatusServiceUnavailable, "This node is not a swarm manager.")
+		return
+	}
+
+	result := make([]swarm.Service, 0, len(s.services))
+	for _, id := range slices.Sorted(maps.Keys(s.services)) {
+		if args.MatchKVList("label", s.services[id].Labels) {
+			result = append(result, s.services[id].spec())
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleServiceInspect(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.swarm.ControlAvailable {
+		writeError(w, http.StatusServiceUnavailable, "This node is not a swarm manager.")
+		return
+	}
+
+	service, found := s.services[r.PathValue("id")]
+	if !found {
+		for _, candidate := range s.services {
+			if candidate.Name == r.PathValue("id") {
+				service, found = candidate, true
+				break
+			}
+		}
+	}
+
+	if !found {
+		writeError(w, http.StatusNotFound, "service "+r.PathValue("id")+" not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, service.spec())
+}
+
+func (s Service) spec() swarm.Service {
+	return swarm.Service{
+		ID: s.ID,
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{Name: s.Name, Labels: s.Labels},
+		},
+	}
+}
+
+func (s *Server) handleContainerList(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make([]container.Summary, 0, len(s.containers))
+	for _, id := range slices.Sorted(maps.Keys(s.containers)) {
+		fc := s.containers[id]
+		if (!all && !fc.Running) || !args.MatchKVList("label", fc.Labels) {
+			continue
+		}
+
+		result = append(result, container.Summary{
+			ID:     fc.ID,
+			Names:  []string{"/" + fc.Name},
+			Labels: fc.Labels,
+			State:  fc.state(),
+			Mounts: fc.Mounts,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleContainerInspect(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	state := &container.State{
+		Status:  fc.state(),
+		Running: fc.Running,
+		Paused:  fc.Paused,
+	}
+
+	if fc.Health != "" {
+		if fc.Running && fc.healthStatus == container.Starting {
+			if fc.healthChecks > 0 {
+				fc.healthChecks--
+			} else {
+				fc.healthStatus = fc.Health
+			}
+		}
+
+		state.Health = &container.Health{Status: fc.healthStatus}
+	}
+
+	writeJSON(w, http.StatusOK, container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:    fc.ID,
+			Name:  "/" + fc.Name,
+			State: state,
+			GraphDriver: storage.DriverData{
+				Name: "overlay2",
+				Data: map[string]string{"UpperDir": fc.UpperDir},
+			},
+		},
+		Mounts: fc.Mounts,
+		Config: &container.Config{
+			Labels: fc.Labels,
+			Env:    fc.Env,
+		},
+	})
+}
+
+func (s *Server) handleContainerStart(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	if fc.Running {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	fc.Running = true
+	fc.healthStatus = container.Starting
+	fc.healthChecks = 1
+	s.ops = append(s.ops, "start "+fc.Name)
+	s.emit(events.ActionStart, fc)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleContainerStop(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	if !fc.Running {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	fc.Running = false
+	fc.Paused = false
+	s.ops = append(s.ops, "stop "+fc.Name)
+	s.emit(events.ActionDie, fc)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleContainerPause(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	if !fc.Running {
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s is not running", fc.ID))
+		return
+	}
+
+	if fc.Paused {
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s is already paused", fc.ID))
+		return
+	}
+
+	fc.Paused = true
+	s.ops = append(s.ops, "pause "+fc.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleContainerUnpause(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	if !fc.Paused {
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s is not paused", fc.ID))
+		return
+	}
+
+	fc.Paused = false
+	s.ops = append(s.ops, "unpause "+fc.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleExecCreate(w http.ResponseWriter, r *http.Request) {
+	var options container.ExecOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	if !fc.Running {
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s is not running", fc.ID))
+		return
+	}
+
+	if fc.Paused {
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s is paused, unpause the container before exec", fc.ID))
+		return
+	}
+
+	s.seq++
+	exec := &fakeExec{
+		id:          fmt.Sprintf("exec-%d", s.seq),
+		containerID: fc.ID,
+		cmd:         options.Cmd,
+		pid:         ExecPid + s.seq - 1,
+		running:     true,
+	}
+
+	s.execs[exec.id] = exec
+
+	writeJSON(w, http.StatusCreated, container.ExecCreateResponse{ID: exec.id})
+}
+
+// handleExecStart runs the command right away. Clients attaching to the exec
+// instance get its output as a multiplexed raw stream over the hijacked
+// connection.
+func (s *Server) handleExecStart(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	exec, found := s.execs[r.PathValue("id")]
+	if !found || !exec.running {
+		s.mutex.Unlock()
+		writeError(w, http.StatusNotFound, "No such exec instance: "+r.PathValue("id"))
+		return
+	}
+
+	fc := s.containers[exec.containerID]
+	if fc == nil || !fc.Running {
+		exec.running = false
+		exec.exitCode = 126
+		s.mutex.Unlock()
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s is not running", exec.containerID))
+		return
+	}
+
+	s.ops = append(s.ops, "exec "+fc.Name+" "+strings.Join(exec.cmd, " "))
+	execFunc := s.execFunc
+	name := fc.Name
+	s.mutex.Unlock()
+
+	var stdout []byte
+	exitCode := 0
+	if execFunc != nil {
+		stdout, exitCode = execFunc(name, exec.cmd)
+	}
+
+	s.mutex.Lock()
+	exec.running = false
+	exec.exitCode = exitCode
+	s.mutex.Unlock()
+
+	if r.Header.Get("Upgrade") == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "connection cannot be hijacked")
+		return
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	_, _ = buf.WriteString("HTTP/1.1 101 UPGRADED\r\n" +
+		"Content-Type: application/vnd.docker.multiplexed-stream\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Upgrade: tcp\r\n\r\n")
+	_ = buf.Flush()
+
+	if len(stdout) > 0 {
+		_, _ = stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write(stdout)
+	}
+}
+
+// handleContainerArchive writes the files below the requested path as a tar
+// stream, named relative to the parent of the path like the Docker daemon
+// does.
+func (s *Server) handleContainerArchive(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fc := s.find(r.PathValue("id"))
+	if fc == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+
+	srcPath := path.Clean(r.URL.Query().Get("path"))
+
+	var files []string
+	for file := range fc.Files {
+		if file == srcPath || strings.HasPrefix(file, strings.TrimSuffix(srcPath, "/")+"/") {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		writeError(w, http.StatusNotFound, "Could not find the file "+srcPath+" in container "+fc.Name)
+		return
+	}
+
+	slices.Sort(files)
+
+	isDir := len(files) > 1 || files[0] != srcPath
+	stat := container.PathStat{Name: path.Base(srcPath), Mode: 0o644}
+	if isDir {
+		stat.Mode = os.ModeDir | 0o755
+	}
+
+	statJson, _ := json.Marshal(stat)
+	w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(statJson))
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+
+	tw := tar.NewWriter(w)
+	if isDir {
+		_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: path.Base(srcPath) + "/", Mode: 0o755})
+	}
+
+	for _, file := range files {
+		content := fc.Files[file]
+		_ = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(file, path.Dir(srcPath)+"/"),
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		})
+		_, _ = tw.Write([]byte(content))
+	}
+
+	_ = tw.Close()
+}
+
+func (s *Server) handleExecInspect(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	exec, found := s.execs[r.PathValue("id")]
+	if !found {
+		writeError(w, http.StatusNotFound, "No such exec instance: "+r.PathValue("id"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, container.ExecInspect{
+		ExecID:      exec.id,
+		ContainerID: exec.containerID,
+		Running:     exec.running,
+		ExitCode:    exec.exitCode,
+		Pid:         exec.pid,
+	})
+}
+
+func (s *Server) handleVolumeInspect(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vol, found := s.volumes[r.PathValue("name")]
+	if !found {
+		writeError(w, http.StatusNotFound, "no such volume: "+r.PathValue("name"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, vol)
+}
+
+// handleEvents streams container events until the client disconnects or the
+// server is closed. Filters are ignored.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	subscriber := make(chan events.Message, 16)
+
+	s.mutex.Lock()
+	s.subscribers[subscriber] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.subscribers, subscriber)
+		s.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event, ok := <-subscriber:
+			if !ok {
+				return
+			}
+
+			if encoder.Encode(event) != nil {
+				return
+			}
+
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (fc *fakeContainer) state() container.ContainerState {
+	if fc.Paused {
+		return container.StatePaused
+	}
+
+	if fc.Running {
+		return container.StateRunning
+	}
+
+	return container.StateExited
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
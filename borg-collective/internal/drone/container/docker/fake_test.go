@@ -0,0 +1,590 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/compose"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+func TestFakeEnsureContainerRunning_WithHealth(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "healthy", Name: "healthy", Health: container.Healthy})
+	server.AddContainer(dockertest.Container{ID: "unhealthy", Name: "unhealthy", Health: container.Unhealthy})
+
+	err := client.EnsureContainerRunning(context.Background(), "healthy")
+	assert.NoError(t, err)
+
+	err = client.EnsureContainerRunning(context.Background(), "unhealthy")
+	assert.ErrorContains(t, err, "container is unhealthy: unhealthy")
+
+	assert.Equal(t, []string{"start healthy", "start unhealthy"}, server.Ops())
+}
+
+func TestFakeEnsureContainerStopped(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "running", Name: "running", Running: true})
+	server.AddContainer(dockertest.Container{ID: "stopped", Name: "stopped"})
+
+	assert.NoError(t, client.EnsureContainerStopped(context.Background(), "running"))
+	assert.NoError(t, client.EnsureContainerStopped(context.Background(), "stopped"))
+	assert.Error(t, client.EnsureContainerStopped(context.Background(), "unknown"))
+
+	assert.False(t, server.IsRunning("running"))
+	assert.Equal(t, []string{"stop running"}, server.Ops())
+}
+
+func TestFakePauseContainer(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "running", Name: "running", Running: true})
+	server.AddContainer(dockertest.Container{ID: "stopped", Name: "stopped"})
+
+	paused, err := client.PauseContainer(context.Background(), "running")
+	assert.NoError(t, err)
+	assert.True(t, paused)
+	assert.True(t, server.IsPaused("running"))
+
+	paused, err = client.PauseContainer(context.Background(), "running")
+	assert.NoError(t, err)
+	assert.False(t, paused)
+
+	paused, err = client.PauseContainer(context.Background(), "stopped")
+	assert.NoError(t, err)
+	assert.False(t, paused)
+
+	assert.NoError(t, client.UnpauseContainer(context.Background(), "running"))
+	assert.Error(t, client.UnpauseContainer(context.Background(), "stopped"))
+
+	assert.False(t, server.IsPaused("running"))
+	assert.Equal(t, []string{"pause running", "unpause running"}, server.Ops())
+}
+
+func TestFakeExec(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "db", Name: "db", Env: []string{"DB_USER=paperless"}, Running: true})
+	server.AddContainer(dockertest.Container{ID: "stopped", Name: "stopped"})
+	server.SetExecFunc(func(_ string, cmd []string) ([]byte, int) {
+		if cmd[0] == "fail" {
+			return nil, 3
+		}
+
+		return nil, 0
+	})
+
+	err := client.Exec(context.Background(), "db", []string{"pg_dump", "-U", "&{DB_USER}"}, nil)
+	assert.NoError(t, err)
+
+	err = client.Exec(context.Background(), "db", []string{"fail"}, nil)
+	assert.ErrorContains(t, err, "exec container exited with 3")
+
+	err = client.Exec(context.Background(), "stopped", []string{"true"}, nil)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"exec db pg_dump -U paperless", "exec db fail"}, server.Ops())
+}
+
+func TestFakeDisplayCommand(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{
+		ID:   "db",
+		Name: "db",
+		Labels: map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  "wiki",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelServiceName:  "db",
+			model.LabelPreset:       "mysql",
+		},
+		Env:     []string{"MYSQL_ROOT_PASSWORD=secret"},
+		Running: true,
+	})
+
+	projects, err := client.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+
+	exec := projects[0].Containers["db"].Execs[0]
+	cmd, err := client.DisplayCommand(context.Background(), "db", append(exec.Command, "&{UNSET}"))
+	assert.NoError(t, err)
+	assert.Contains(t, cmd, "--password=***")
+	assert.Contains(t, cmd, "&{UNSET}")
+	assert.NotContains(t, strings.Join(cmd, " "), "secret")
+}
+
+func TestFakeExecWithOutput(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "db", Name: "db", Running: true})
+
+	expected := bytes.Repeat([]byte("0123456789abcdef"), 32768)
+	server.SetExecFunc(func(_ string, cmd []string) ([]byte, int) {
+		if cmd[0] == "fail" {
+			return expected, 1
+		}
+
+		return expected, 0
+	})
+
+	output, err := client.ExecWithOutput(context.Background(), "db", []string{"dump"})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(output)
+	assert.NoError(t, err)
+	assert.NoError(t, output.Error())
+	assert.Equal(t, expected, buf.Bytes())
+
+	failingOutput, err := client.ExecWithOutput(context.Background(), "db", []string{"fail"})
+	assert.NoError(t, err)
+
+	buf.Reset()
+	_, err = buf.ReadFrom(failingOutput)
+	assert.NoError(t, err)
+	assert.Error(t, failingOutput.Error())
+}
+
+func TestFakeReadProjects(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddVolume("paperless_data", "/var/lib/docker/volumes/paperless_data/_data")
+	server.AddContainer(dockertest.Container{
+		ID:   "server",
+		Name: "paperless-server-1",
+		Labels: map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  "paperless",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelServiceName:  "server",
+			model.LabelVolumesPfx:   "/data",
+		},
+		Mounts: []container.MountPoint{{Type: mount.TypeVolume, Name: "paperless_data", Destination: "/data"}},
+	})
+	server.AddContainer(dockertest.Container{
+		ID:   "invalid",
+		Name: "paperless-invalid-1",
+		Labels: map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  "paperless",
+		},
+	})
+	server.AddContainer(dockertest.Container{ID: "other", Name: "other"})
+
+	projects, err := client.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+	assert.Equal(t, "paperless", projects[0].ProjectName)
+	assert.Len(t, projects[0].Containers, 1)
+	assert.Equal(t, "/var/lib/docker/volumes/paperless_data/_data", projects[0].Containers["server"].BackupVolumes[0].Source)
+}
+
+func TestFakeWatch(t *testing.T) {
+	client, server := newFakeClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	projects, err := client.ReadProjects(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, projects)
+
+	watch, err := client.Watch(ctx)
+	assert.NoError(t, err)
+
+	labels := func(service string) map[string]string {
+		return map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  "paperless",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelServiceName:  service,
+		}
+	}
+
+	nextUpdate := func() int {
+		select {
+		case project := <-watch.Updates():
+			return len(project.Containers)
+		case err := <-watch.Errors():
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for project update")
+		}
+
+		return -1
+	}
+
+	server.AddContainer(dockertest.Container{ID: "server", Name: "paperless-server-1", Labels: labels("server")})
+	assert.Equal(t, 1, nextUpdate())
+
+	server.AddContainer(dockertest.Container{ID: "db", Name: "paperless-db-1", Labels: labels("db")})
+	assert.Equal(t, 2, nextUpdate())
+
+	// containers not enabled for borgd don't update any project
+	server.AddContainer(dockertest.Container{ID: "other", Name: "other"})
+
+	server.RemoveContainer("server")
+	assert.Equal(t, 1, nextUpdate())
+
+	server.RemoveContainer("db")
+	assert.Equal(t, 0, nextUpdate())
+}
+
+func swarmTask(id, service, stack string, running bool, labels map[string]string) dockertest.Container {
+	taskLabels := map[string]string{
+		labelSwarmServiceID:   service + "-id",
+		labelSwarmServiceName: stack + "_" + service,
+		labelSwarmTaskID:      id,
+		labelStackNamespace:   stack,
+	}
+
+	maps.Copy(taskLabels, labels)
+
+	return dockertest.Container{ID: id, Name: stack + "_" + service + "." + id, Labels: taskLabels, Running: running}
+}
+
+func TestFakeReadProjects_Swarm(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.JoinSwarm("node-1", true)
+	server.AddService(dockertest.Service{
+		ID:   "db-id",
+		Name: "paperless_db",
+		Labels: map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelPreset:       "postgres",
+		},
+	})
+	server.AddService(dockertest.Service{
+		ID:   "cache-id",
+		Name: "paperless_cache",
+		Labels: map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelBackupMode:   "offline",
+		},
+	})
+
+	server.AddService(dockertest.Service{ID: "server-id", Name: "paperless_server"})
+
+	// previous tasks stay around after they've been rescheduled
+	server.AddContainer(swarmTask("old", "db", "paperless", false, nil))
+	server.AddContainer(swarmTask("current", "db", "paperless", true, nil))
+	server.AddContainer(swarmTask("cache", "cache", "paperless", true, nil))
+
+	// labels of the container take precedence over the labels of the service
+	server.AddContainer(swarmTask("server", "server", "paperless", true, map[string]string{
+		model.LabelBorgdEnabled: "true",
+		model.LabelProjectWhen:  "0 3 * * *",
+		model.LabelServiceName:  "web",
+	}))
+
+	projects, err := client.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+	assert.Equal(t, "paperless", projects[0].ProjectName)
+	assert.NotNil(t, projects[0].Schedule)
+	assert.Equal(t, []string{"db", "web"}, slices.Sorted(maps.Keys(projects[0].Containers)))
+	assert.Equal(t, "current", projects[0].Containers["db"].ID)
+	assert.True(t, projects[0].Containers["db"].SwarmTask)
+	assert.Equal(t, "db-id", projects[0].Containers["db"].SwarmServiceID)
+
+	_, problems, err := client.ValidateProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, problems, 1)
+	assert.ErrorContains(t, problems[0], "swarm task cannot have offline backup mode")
+}
+
+func TestFakeReadProjects_SwarmWorker(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.JoinSwarm("node-2", false)
+	server.AddService(dockertest.Service{
+		ID:     "db-id",
+		Name:   "paperless_db",
+		Labels: map[string]string{model.LabelBorgdEnabled: "true", model.LabelProjectWhen: "0 3 * * *"},
+	})
+
+	// workers can't read the labels of services
+	server.AddContainer(swarmTask("db", "db", "paperless", true, nil))
+	server.AddContainer(swarmTask("server", "server", "paperless", true, map[string]string{
+		model.LabelBorgdEnabled: "true",
+		model.LabelProjectWhen:  "0 3 * * *",
+	}))
+
+	projects, err := client.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+	assert.Equal(t, []string{"server"}, slices.Collect(maps.Keys(projects[0].Containers)))
+}
+
+func TestFakeWatch_Swarm(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.JoinSwarm("node-1", true)
+
+	service := dockertest.Service{
+		ID:     "db-id",
+		Name:   "paperless_db",
+		Labels: map[string]string{model.LabelBorgdEnabled: "true", model.LabelProjectWhen: "0 3 * * *"},
+	}
+
+	server.AddService(service)
+	server.AddContainer(swarmTask("first", "db", "paperless", true, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	projects, err := client.ReadProjects(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+
+	watch, err := client.Watch(ctx)
+	assert.NoError(t, err)
+
+	nextUpdate := func() model.ContainerBackupProject {
+		select {
+		case project := <-watch.Updates():
+			return project
+		case err := <-watch.Errors():
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for project update")
+		}
+
+		return model.ContainerBackupProject{}
+	}
+
+	// the task is rescheduled on this node
+	server.SetRunning("first", false)
+	assert.Empty(t, nextUpdate().Containers)
+
+	server.AddContainer(swarmTask("second", "db", "paperless", false, nil))
+	server.SetRunning("second", true)
+	assert.Equal(t, "second", nextUpdate().Containers["db"].ID)
+
+	service.Labels[model.LabelBackupMode] = "paused"
+	server.AddService(service)
+	assert.Equal(t, model.BackupModePaused, nextUpdate().Containers["db"].Mode)
+
+	delete(service.Labels, model.LabelBorgdEnabled)
+	server.AddService(service)
+	assert.Empty(t, nextUpdate().Containers)
+}
+
+func TestFakeReadProjects_Compose(t *testing.T) {
+	composeFile := filepath.Join(t.TempDir(), "compose.yml")
+	assert.NoError(t, os.WriteFile(composeFile, []byte(`
+x-borgd:
+  when: "0 3 * * *"
+  retention: {daily: 7}
+services:
+  db:
+    x-borgd:
+      mode: paused
+      volumes: [/data]
+  server:
+    x-borgd:
+      enabled: false
+  broker: {}
+`), 0o600))
+
+	composeLabels := func(service string, labels map[string]string) map[string]string {
+		labels[compose.LabelProject] = "paperless"
+		labels[compose.LabelService] = service
+		labels[compose.LabelConfigFiles] = composeFile
+
+		return labels
+	}
+
+	client, server := newFakeClient(t)
+	server.AddVolume("paperless_data", "/var/lib/docker/volumes/paperless_data/_data")
+	server.AddContainer(dockertest.Container{
+		ID:     "db",
+		Name:   "paperless-db-1",
+		Labels: composeLabels("db", map[string]string{model.LabelBackupMode: "default"}),
+		Mounts: []container.MountPoint{{Type: mount.TypeVolume, Name: "paperless_data", Destination: "/data"}},
+	})
+	server.AddContainer(dockertest.Container{
+		ID:     "server",
+		Name:   "paperless-server-1",
+		Labels: composeLabels("server", map[string]string{}),
+	})
+
+	// labels enable services without an x-borgd field
+	server.AddContainer(dockertest.Container{
+		ID:   "broker",
+		Name: "paperless-broker-1",
+		Labels: composeLabels("broker", map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelServiceName:  "broker",
+			model.LabelProjectName:  "paperless",
+		}),
+	})
+
+	projects, err := client.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+	assert.Equal(t, "paperless", projects[0].ProjectName)
+	assert.Equal(t, &model.Retention{Daily: 7}, projects[0].Retention)
+	assert.Equal(t, []string{"broker", "db"}, slices.Sorted(maps.Keys(projects[0].Containers)))
+
+	// the labels of the container take precedence
+	assert.Equal(t, model.BackupModeDefault, projects[0].Containers["db"].Mode)
+	assert.Equal(t, []string{"/data"}, destinations(projects[0].Containers["db"].BackupVolumes))
+}
+
+func TestFakeReadProjects_ScheduleConflict(t *testing.T) {
+	labels := func(service, when string) map[string]string {
+		return map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  "paperless",
+			model.LabelProjectWhen:  when,
+			model.LabelServiceName:  service,
+		}
+	}
+
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "a-db", Name: "paperless-db-1", Labels: labels("db", "0 3 * * *")})
+	server.AddContainer(dockertest.Container{ID: "b-server", Name: "paperless-server-1", Labels: labels("server", "@daily")})
+	server.AddContainer(dockertest.Container{ID: "c-broker", Name: "paperless-broker-1", Labels: labels("broker", "0 3 * * *")})
+
+	// containers with a schedule of their own don't need the project schedule
+	cacheLabels := labels("cache", "")
+	delete(cacheLabels, model.LabelProjectWhen)
+	cacheLabels[model.LabelServiceWhen] = "@hourly"
+	cacheLabels[model.LabelExec] = "redis-cli --rdb -"
+	cacheLabels[model.LabelExecStdout] = "true"
+	server.AddContainer(dockertest.Container{ID: "0-cache", Name: "paperless-cache-1", Labels: cacheLabels})
+
+	_, problems, err := client.ValidateProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, problems, 1)
+	assert.ErrorContains(t, problems[0], "container paperless-server-1 (b-server): project schedule @daily conflicts")
+
+	projects, err := client.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"broker", "cache", "db"}, slices.Sorted(maps.Keys(projects[0].Containers)))
+	assert.NotNil(t, projects[0].Schedule)
+	assert.NotNil(t, projects[0].Containers["cache"].Schedule)
+}
+
+func TestFakeWatch_ReplacedContainer(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "old", Name: "paperless-db-1", Labels: map[string]string{
+		model.LabelBorgdEnabled: "true",
+		model.LabelProjectName:  "paperless",
+		model.LabelProjectWhen:  "0 3 * * *",
+		model.LabelServiceName:  "db",
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := client.ReadProjects(ctx)
+	assert.NoError(t, err)
+
+	watch, err := client.Watch(ctx)
+	assert.NoError(t, err)
+
+	// the container is recreated with another schedule before the old one is
+	// removed
+	server.AddContainer(dockertest.Container{ID: "new", Name: "paperless-db-1", Labels: map[string]string{
+		model.LabelBorgdEnabled: "true",
+		model.LabelProjectName:  "paperless",
+		model.LabelProjectWhen:  "0 4 * * *",
+		model.LabelServiceName:  "db",
+	}})
+
+	select {
+	case project := <-watch.Updates():
+		assert.Equal(t, "new", project.Containers["db"].ID)
+		assert.Equal(t, time.Date(2025, 1, 1, 4, 0, 0, 0, time.Local), project.Schedule.Next(time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)))
+	case err := <-watch.Errors():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for project update")
+	}
+}
+
+func TestFakeResync(t *testing.T) {
+	client, server := newFakeClient(t)
+
+	labels := func(project, service string) map[string]string {
+		return map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  project,
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelServiceName:  service,
+		}
+	}
+
+	server.AddContainer(dockertest.Container{ID: "server", Name: "paperless-server-1", Labels: labels("paperless", "server")})
+	server.AddContainer(dockertest.Container{ID: "cache", Name: "immich-cache-1", Labels: labels("immich", "cache")})
+	server.AddContainer(dockertest.Container{ID: "web", Name: "gitea-web-1", Labels: labels("gitea", "web")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := client.ReadProjects(ctx)
+	assert.NoError(t, err)
+
+	watch, err := client.Watch(ctx)
+	assert.NoError(t, err)
+
+	server.StopEngine()
+
+	// the watch publishes why the event stream ended, and is closed
+	closed := false
+	for !closed {
+		select {
+		case _, ok := <-watch.Errors():
+			closed = !ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the watch to be closed")
+		}
+	}
+
+	_, err = client.Resync(ctx)
+	assert.Error(t, err)
+
+	// changes while the engine is stopped are only caught by a resync
+	server.AddContainer(dockertest.Container{ID: "db", Name: "paperless-db-1", Labels: labels("paperless", "db")})
+	server.RemoveContainer("cache")
+	server.StartEngine()
+
+	projects, err := client.Resync(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, projects, 2) {
+		assert.Equal(t, "paperless", projects[0].ProjectName)
+		assert.Len(t, projects[0].Containers, 2)
+		assert.Equal(t, "immich", projects[1].ProjectName)
+		assert.Empty(t, projects[1].Containers)
+	}
+
+	projects, err = client.Resync(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, projects)
+}
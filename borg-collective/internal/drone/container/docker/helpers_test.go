@@ -21,8 +21,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"testing"
 
 	"github.com/docker/docker/client"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
 	"github.com/vemilyus/borg-collective/internal/utils"
 )
 
@@ -40,6 +42,20 @@ func newClient() *Client {
 	return NewClient(dc)
 }
 
+// newFakeClient returns a client connected to a fake engine, which is closed
+// when the test finishes.
+func newFakeClient(t *testing.T) (*Client, *dockertest.Server) {
+	server := dockertest.NewServer()
+	t.Cleanup(server.Close)
+
+	dc, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClient(dc), server
+}
+
 func composeUp(project, file string) error {
 	path := absPath(filepath.Join("testdata", file))
 	if _, err := os.Stat(path); os.IsNotExist(err) {
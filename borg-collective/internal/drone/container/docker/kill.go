@@ -0,0 +1,173 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rs/zerolog/log"
+)
+
+// procRoot is where the proc filesystem is mounted, the PIDs reported by the
+// daemon are looked up in it.
+var procRoot = "/proc"
+
+// killExec terminates the process of a running exec instance, since the Docker
+// API provides no way of stopping an exec instance. The daemon reports the PID
+// of the process in its own PID namespace, so the process is only terminated
+// if that PID refers to a process of the container in the PID namespace of
+// borgd, which requires a local daemon and borgd not running in a separate PID
+// namespace.
+func (c *Client) killExec(execID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	execInspect, err := c.dc.ContainerExecInspect(ctx, execID)
+	if err != nil || !execInspect.Running || execInspect.Pid == 0 {
+		return
+	}
+
+	pid, found := containerPid(execInspect.Pid, execInspect.ContainerID)
+	if !found {
+		log.Warn().
+			Str("engine", (string)(c.engine)).
+			Str("container", execInspect.ContainerID).
+			Int("pid", execInspect.Pid).
+			Msg("cannot terminate canceled container exec, its PID is not a process of the container in the PID namespace of borgd")
+
+		return
+	}
+
+	log.Info().
+		Str("engine", (string)(c.engine)).
+		Str("container", execInspect.ContainerID).
+		Int("pid", execInspect.Pid).
+		Int("containerPid", pid).
+		Msg("terminating canceled container exec")
+
+	// signalling from within the container also works for processes of
+	// rootless engines owned by another user
+	err = c.terminateInContainer(ctx, execInspect.ContainerID, pid)
+	if err == nil {
+		return
+	}
+
+	log.Debug().
+		Err(err).
+		Str("engine", (string)(c.engine)).
+		Str("container", execInspect.ContainerID).
+		Msg("failed to terminate container exec from within the container")
+
+	err = syscall.Kill(execInspect.Pid, syscall.SIGTERM)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("engine", (string)(c.engine)).
+			Str("container", execInspect.ContainerID).
+			Int("pid", execInspect.Pid).
+			Msg("failed to terminate container exec")
+	}
+}
+
+// terminateInContainer runs kill in the container to terminate the process
+// with the given PID of the PID namespace of the container.
+func (c *Client) terminateInContainer(ctx context.Context, containerID string, pid int) error {
+	exec, err := c.dc.ContainerExecCreate(
+		ctx,
+		containerID,
+		container.ExecOptions{Cmd: []string{"kill", "-TERM", strconv.Itoa(pid)}},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = c.dc.ContainerExecStart(ctx, exec.ID, container.ExecStartOptions{})
+	if err != nil {
+		return err
+	}
+
+	// not waitForExec, which would try to kill this exec on timeout
+	for {
+		execInspect, err := c.dc.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return err
+		}
+
+		if !execInspect.Running {
+			if execInspect.ExitCode != 0 {
+				return fmt.Errorf("kill exited with %d", execInspect.ExitCode)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(loopBackoff):
+		}
+	}
+}
+
+// containerPid returns the PID in the PID namespace of the container of the
+// process with the given PID in the PID namespace of borgd. Returns false if
+// there is no such process, or it doesn't belong to the container.
+func containerPid(pid int, containerID string) (int, bool) {
+	if containerID == "" {
+		return 0, false
+	}
+
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	// the cgroup of container processes is named after the container, for
+	// both Docker and Podman
+	cgroup, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil || !strings.Contains(string(cgroup), containerID) {
+		return 0, false
+	}
+
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return 0, false
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		value, found := strings.CutPrefix(line, "NSpid:")
+		if !found {
+			continue
+		}
+
+		// the PIDs from the outermost to the innermost PID namespace
+		pids := strings.Fields(value)
+		if len(pids) == 0 {
+			return 0, false
+		}
+
+		nsPid, err := strconv.Atoi(pids[len(pids)-1])
+		return nsPid, err == nil
+	}
+
+	return 0, false
+}
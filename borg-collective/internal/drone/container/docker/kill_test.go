@@ -0,0 +1,84 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
+)
+
+// fakeProc replaces the proc filesystem with one that has a process with the
+// given cgroup and PIDs, the last one being in the PID namespace of the
+// container.
+func fakeProc(t *testing.T, cgroup string, pids ...int) {
+	root := t.TempDir()
+	dir := filepath.Join(root, strconv.Itoa(pids[0]))
+	assert.NoError(t, os.Mkdir(dir, 0o755))
+
+	nsPids := ""
+	for _, pid := range pids {
+		nsPids += "\t" + strconv.Itoa(pid)
+	}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::"+cgroup+"\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "status"), []byte("Name:\tpg_dump\nNSpid:"+nsPids+"\nPPid:\t0\n"), 0o644))
+
+	previous := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = previous })
+}
+
+func TestContainerPid(t *testing.T) {
+	fakeProc(t, "/system.slice/docker-abc123.scope", 4242, 57)
+
+	pid, found := containerPid(4242, "abc123")
+	assert.True(t, found)
+	assert.Equal(t, 57, pid)
+
+	// processes of other containers and unknown processes aren't signalled
+	_, found = containerPid(4242, "def456")
+	assert.False(t, found)
+
+	_, found = containerPid(4343, "abc123")
+	assert.False(t, found)
+
+	_, found = containerPid(4242, "")
+	assert.False(t, found)
+}
+
+func TestFakeKillExec(t *testing.T) {
+	client, server := newFakeClient(t)
+	server.AddContainer(dockertest.Container{ID: "abc123", Name: "db", Running: true})
+
+	exec, err := client.dc.ContainerExecCreate(context.Background(), "db", container.ExecOptions{Cmd: []string{"pg_dumpall"}})
+	assert.NoError(t, err)
+
+	// the PID of a remote daemon or another PID namespace isn't signalled
+	fakeProc(t, "/system.slice/docker-other.scope", dockertest.ExecPid, 57)
+	client.killExec(exec.ID)
+	assert.Empty(t, server.Ops())
+
+	fakeProc(t, "/system.slice/docker-abc123.scope", dockertest.ExecPid, 57)
+	client.killExec(exec.ID)
+	assert.Equal(t, []string{"exec db kill -TERM 57"}, server.Ops())
+}
@@ -17,67 +17,185 @@ package docker
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
-	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
 	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/drone/container/preset"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
 	"github.com/vemilyus/borg-collective/internal/utils"
 )
 
-func mapInspectToProject(inspect container.InspectResponse) (*model.ContainerBackupProject, error) {
+// mapInspectToProject maps the project labels of the container, all problems
+// with the labels are reported at once. The project name defaults to podName.
+func mapInspectToProject(
+	inspect container.InspectResponse,
+	engine model.ContainerEngine,
+	podName string,
+) (*model.ContainerBackupProject, error) {
+	var errs []error
+
 	projectName, found := inspect.Config.Labels[model.LabelProjectName]
-	if !found || projectName == "" {
-		return nil, fmt.Errorf("project name not found in container %s", inspect.ID)
+	if !found {
+		projectName = podName
 	}
 
+	if projectName == "" {
+		errs = append(errs, fmt.Errorf("project name not found in container %s", inspect.ID))
+	}
+
+	after := splitList(inspect.Config.Labels[model.LabelProjectAfter])
+	requires := splitList(inspect.Config.Labels[model.LabelProjectRequires])
+
+	var schedule cron.Schedule
 	scheduleRaw, found := inspect.Config.Labels[model.LabelProjectWhen]
 	if !found {
-		return nil, fmt.Errorf("project schedule not found in container %s", inspect.ID)
+		// containers backed up on their own schedule don't need the project one
+		_, serviceScheduled := inspect.Config.Labels[model.LabelServiceWhen]
+		if len(after) == 0 && len(requires) == 0 && !serviceScheduled {
+			errs = append(errs, fmt.Errorf("project schedule not found in container %s", inspect.ID))
+		}
+	} else {
+		var err error
+		schedule, err = cron.ParseStandard(scheduleRaw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse project schedule in container %s: %w", inspect.ID, err))
+		}
 	}
 
-	schedule, err := cron.ParseStandard(scheduleRaw)
+	priority := 0
+	if priorityRaw, found := inspect.Config.Labels[model.LabelProjectPriority]; found {
+		var err error
+		priority, err = strconv.Atoi(strings.TrimSpace(priorityRaw))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse project priority in container %s: %w", inspect.ID, err))
+		}
+	}
+
+	catchUp := false
+	if catchUpRaw, found := inspect.Config.Labels[model.LabelProjectCatchUp]; found {
+		var err error
+		catchUp, err = strconv.ParseBool(strings.TrimSpace(catchUpRaw))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse project catch-up policy in container %s: %w", inspect.ID, err))
+		}
+	}
+
+	var jitter *time.Duration
+	if jitterRaw, found := inspect.Config.Labels[model.LabelProjectJitter]; found {
+		parsed, err := parseDuration(jitterRaw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse project jitter in container %s: %w", inspect.ID, err))
+		} else {
+			jitter = &parsed
+		}
+	}
+
+	var timeout *time.Duration
+	if timeoutRaw, found := inspect.Config.Labels[model.LabelProjectTimeout]; found {
+		parsed, err := parseDuration(timeoutRaw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse project timeout in container %s: %w", inspect.ID, err))
+		} else {
+			timeout = &parsed
+		}
+	}
+
+	windows, err := window.ParseLabel(
+		inspect.Config.Labels[model.LabelProjectWindow],
+		inspect.Config.Labels[model.LabelProjectWindowPolicy],
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse project schedule in container %s", inspect.ID))
+		errs = append(errs, fmt.Errorf("failed to parse project window in container %s: %w", inspect.ID, err))
+	}
+
+	var retention *model.Retention
+	if retentionRaw, found := inspect.Config.Labels[model.LabelProjectRetention]; found {
+		var err error
+		retention, err = model.ParseRetention(retentionRaw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse project retention in container %s: %w", inspect.ID, err))
+		}
+	}
+
+	hooks := model.Hooks{
+		Pre:       utils.SplitCommandLine(strings.TrimSpace(inspect.Config.Labels[model.LabelProjectPreExec])),
+		Post:      utils.SplitCommandLine(strings.TrimSpace(inspect.Config.Labels[model.LabelProjectPostExec])),
+		Finally:   utils.SplitCommandLine(strings.TrimSpace(inspect.Config.Labels[model.LabelProjectFinallyExec])),
+		Container: strings.TrimSpace(inspect.Config.Labels[model.LabelProjectHooksContainer]),
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
 	return &model.ContainerBackupProject{
-		Engine:      model.ContainerEngineDocker,
+		Engine:      engine,
 		ProjectName: projectName,
 		Schedule:    schedule,
+		Priority:    priority,
+		CatchUp:     catchUp,
+		Jitter:      jitter,
+		Timeout:     timeout,
+		Windows:     windows,
+		After:       after,
+		Requires:    requires,
+		Hooks:       hooks,
+		Retention:   retention,
 		Containers:  make(map[string]model.ContainerBackup),
 	}, nil
 }
 
-func mapInspectToContainerBackup(inspect container.InspectResponse) (*model.ContainerBackup, error) {
+// mapInspectToContainerBackup maps the backup labels of the container, all
+// problems with the labels are reported at once.
+func mapInspectToContainerBackup(inspect container.InspectResponse, engine model.ContainerEngine) (*model.ContainerBackup, error) {
+	var errs []error
+
+	// paths that aren't on a mount are read from the upper directory of overlay
+	// filesystems on the host, or through the archive API with other storage
+	// drivers
 	upperDir := ""
-	if inspect.GraphDriver.Name == "overlay2" {
+	if inspect.GraphDriver.Name == "overlay2" || inspect.GraphDriver.Name == "overlay" {
 		upperDir = inspect.GraphDriver.Data["UpperDir"]
-	} else {
-		log.Warn().
-			Str("engine", (string)(model.ContainerEngineDocker)).
-			Str("container", inspect.ID).
-			Str("graphDriver", inspect.GraphDriver.Name).
-			Msg("graph driver not supported, backed up data may be incomplete")
 	}
 
 	result := &model.ContainerBackup{
-		ID:            inspect.ID,
-		Mode:          model.BackupModeDefault,
-		UpperDirPath:  upperDir,
-		BackupVolumes: make([]model.Volume, 0, 3),
-		AllVolumes:    mapVolumes(inspect.Mounts, inspect.ID),
-		Dependencies:  make([]string, 0, 3),
+		ID:             inspect.ID,
+		Mode:           model.BackupModeDefault,
+		UpperDirPath:   upperDir,
+		BackupVolumes:  make([]model.Volume, 0, 3),
+		AllVolumes:     mapVolumes(inspect.Mounts, inspect.ID),
+		Dependencies:   make([]string, 0, 3),
+		SwarmTask:      isSwarmTask(inspect.Config.Labels),
+		SwarmServiceID: inspect.Config.Labels[labelSwarmServiceID],
 	}
 
-	exec := model.ContainerExecBackup{
-		Paths: make([]string, 0, 1),
+	execs := make(map[string]*model.ContainerExecBackup)
+	execFor := func(name string) *model.ContainerExecBackup {
+		exec, found := execs[name]
+		if !found {
+			exec = &model.ContainerExecBackup{Name: name, Paths: make([]string, 0, 1)}
+			execs[name] = exec
+		}
+
+		return exec
 	}
 
+	hostHooks := false
+
+	var dbPreset preset.Preset
+	volumesMode := ""
+
 	for key, value := range inspect.Config.Labels {
 		value = strings.TrimSpace(value)
 		if value == "" {
@@ -87,55 +205,312 @@ func mapInspectToContainerBackup(inspect container.InspectResponse) (*model.Cont
 		if key == model.LabelBackupMode {
 			mode, err := model.BackupModeFromString(value)
 			if err != nil {
-				return nil, err
+				errs = append(errs, err)
+				continue
 			}
 
 			result.Mode = mode
+		} else if key == model.LabelServiceWhen {
+			schedule, err := cron.ParseStandard(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse service schedule in container %s: %w", result.ID, err))
+				continue
+			}
+
+			result.Schedule = schedule
+		} else if key == model.LabelMaxDowntime {
+			maxDowntime, err := parseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse max downtime in container %s: %w", result.ID, err))
+				continue
+			}
+
+			result.MaxDowntime = &maxDowntime
 		} else if strings.HasPrefix(key, model.LabelDependenciesPfx) {
 			result.Dependencies = append(result.Dependencies, value)
 		} else if key == model.LabelExec {
-			exec.Command = utils.SplitCommandLine(value)
+			execFor("").Command = utils.SplitCommandLine(value)
 		} else if key == model.LabelExecStdout {
-			exec.Stdout = true
+			execFor("").Stdout = true
 		} else if strings.HasPrefix(key, model.LabelExecPathsPfx) {
-			exec.Paths = append(exec.Paths, value)
+			execFor("").Paths = append(execFor("").Paths, value)
+		} else if key == model.LabelExecPathsSource {
+			source, err := model.PathSourceFromString(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse paths source in container %s: %w", result.ID, err))
+				continue
+			}
+
+			execFor("").PathSource = source
+		} else if strings.HasPrefix(key, model.LabelExecPfx) {
+			name, field, _ := strings.Cut(strings.TrimPrefix(key, model.LabelExecPfx), ".")
+			if !itemNameRegex.MatchString(name) {
+				errs = append(errs, fmt.Errorf("invalid exec name %q in container %s", name, result.ID))
+				continue
+			}
+
+			if field == "" {
+				execFor(name).Command = utils.SplitCommandLine(value)
+			} else if field == "stdout" {
+				execFor(name).Stdout = true
+			} else if strings.HasPrefix(field, "paths.") {
+				execFor(name).Paths = append(execFor(name).Paths, value)
+			} else if field == "paths_source" {
+				source, err := model.PathSourceFromString(value)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to parse paths source in container %s: %w", result.ID, err))
+					continue
+				}
+
+				execFor(name).PathSource = source
+			} else {
+				errs = append(errs, fmt.Errorf("unrecognized label %s in container %s", key, result.ID))
+			}
+		} else if key == model.LabelArchives {
+			switch value {
+			case "separate":
+				result.CombineArchives = false
+			case "combined":
+				result.CombineArchives = true
+			default:
+				errs = append(errs, fmt.Errorf("unrecognized archives option in container %s: %s", result.ID, value))
+			}
+		} else if key == model.LabelPreExec {
+			result.Hooks.Pre = utils.SplitCommandLine(value)
+		} else if key == model.LabelPostExec {
+			result.Hooks.Post = utils.SplitCommandLine(value)
+		} else if key == model.LabelFinallyExec {
+			result.Hooks.Finally = utils.SplitCommandLine(value)
+		} else if key == model.LabelHooksOnHost {
+			hooksOnHost, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse hooks on host in container %s: %w", result.ID, err))
+				continue
+			}
+
+			hostHooks = hooksOnHost
 		} else if key == model.LabelServiceName {
 			result.ServiceName = value
+		} else if key == model.LabelSnapshot {
+			kind, err := snapshot.ParseKind(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse snapshot provider in container %s: %w", result.ID, err))
+				continue
+			}
+
+			result.Snapshot = kind
+		} else if key == model.LabelPreset {
+			parsed, err := preset.Parse(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse preset in container %s: %w", result.ID, err))
+				continue
+			}
+
+			dbPreset = parsed
+		} else if key == model.LabelVolumes {
+			if value != "all" && value != "named" {
+				errs = append(errs, fmt.Errorf("unrecognized volumes option in container %s: %s", result.ID, value))
+				continue
+			}
+
+			volumesMode = value
 		} else if strings.HasPrefix(key, model.LabelVolumesPfx) {
 			m := findVolumeByDestination(value, inspect)
 			if m == nil {
-				return nil, fmt.Errorf("volume for destination %s not found in %s", value, result.ID)
+				errs = append(errs, fmt.Errorf("volume for destination %s not found in %s", value, result.ID))
+				continue
 			}
 
 			result.BackupVolumes = append(result.BackupVolumes, *m)
 		}
 	}
 
-	if len(exec.Command) > 0 {
+	excluded := splitList(inspect.Config.Labels[model.LabelVolumesExclude])
+	if volumesMode != "" {
+		result.BackupVolumes = resolveVolumes(result, volumesMode, excluded)
+	}
+
+	for _, exec := range execs {
+		if len(exec.Command) == 0 {
+			// the paths and stdout labels used to be ignored without an exec
+			if exec.Name != "" {
+				errs = append(errs, fmt.Errorf("exec %s must have a command: %s", exec.Name, result.ID))
+			}
+
+			continue
+		}
+
+		label := strings.TrimSpace("exec " + exec.Name)
 		if len(exec.Paths) == 0 && !exec.Stdout {
-			return nil, fmt.Errorf("exec must have either paths or stdout: %s", result.ID)
+			errs = append(errs, fmt.Errorf("%s must have either paths or stdout: %s", label, result.ID))
 		} else if len(exec.Paths) > 0 && exec.Stdout {
-			return nil, fmt.Errorf("exec must not have both paths and stdout: %s", result.ID)
+			errs = append(errs, fmt.Errorf("%s must not have both paths and stdout: %s", label, result.ID))
+		}
+
+		result.Execs = append(result.Execs, *exec)
+	}
+
+	if dbPreset != "" {
+		presetExec, err := dbPreset.ExecBackup(
+			utils.ToMap(inspect.Config.Env),
+			strings.TrimSpace(inspect.Config.Labels[model.LabelPresetDatabase]),
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply preset in container %s: %w", result.ID, err))
+		} else {
+			presetExec.Name = string(dbPreset)
+			result.Execs = append(result.Execs, presetExec)
+		}
+	}
+
+	slices.SortFunc(result.Execs, func(a, b model.ContainerExecBackup) int {
+		return strings.Compare(a.ItemName(), b.ItemName())
+	})
+
+	for i := 1; i < len(result.Execs); i++ {
+		if result.Execs[i].ItemName() == result.Execs[i-1].ItemName() {
+			errs = append(errs, fmt.Errorf("container has more than one exec named %s: %s", result.Execs[i].ItemName(), result.ID))
 		}
+	}
+
+	if len(result.BackupVolumes) > 0 && slices.ContainsFunc(result.Execs, func(exec model.ContainerExecBackup) bool {
+		return exec.ItemName() == model.VolumesItem
+	}) {
+		errs = append(errs, fmt.Errorf("exec name %s is reserved for the volumes: %s", model.VolumesItem, result.ID))
+	}
 
-		result.Exec = &exec
+	if result.Snapshot != "" && len(result.BackupVolumes) == 0 {
+		errs = append(errs, fmt.Errorf("container must have volumes to snapshot: %s", result.ID))
 	}
 
-	if result.Exec != nil && len(result.BackupVolumes) > 0 {
-		return nil, fmt.Errorf("container must not have both exec and volumes: %s", result.ID)
+	if result.Schedule != nil && !result.NeedsBackup() {
+		errs = append(errs, fmt.Errorf("container must have something to back up to have its own schedule: %s", result.ID))
 	}
 
 	if result.ServiceName == "" {
-		return nil, fmt.Errorf("container must have a service name: %s", result.ID)
+		errs = append(errs, fmt.Errorf("container must have a service name: %s", result.ID))
+	}
+
+	if result.Mode == model.BackupModeOffline && len(result.Execs) > 0 {
+		errs = append(errs, fmt.Errorf("container cannot have exec with offline backup mode: %s", result.ID))
+	}
+
+	if result.SwarmTask && result.Mode == model.BackupModeOffline {
+		errs = append(errs, fmt.Errorf("swarm task cannot have offline backup mode, swarm would replace the stopped task: %s", result.ID))
 	}
 
-	if result.Mode == model.BackupModeOffline && result.Exec != nil {
-		return nil, fmt.Errorf("container cannot have exec with offline backup mode: %s", result.ID)
+	if result.Mode == model.BackupModePaused && len(result.Execs) > 0 {
+		errs = append(errs, fmt.Errorf("container cannot have exec with paused backup mode: %s", result.ID))
+	}
+
+	if !hostHooks && !result.Hooks.IsEmpty() {
+		result.Hooks.Container = result.ServiceName
+
+		if result.Mode == model.BackupModeOffline && (len(result.Hooks.Post) > 0 || len(result.Hooks.Finally) > 0) {
+			errs = append(errs, fmt.Errorf("container cannot run post or finally hooks itself with offline backup mode: %s", result.ID))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if result.NeedsBackup() {
+		warnUncoveredVolumes(result, excluded)
 	}
 
 	return result, nil
 }
 
+// resolveVolumes adds the mounts of the container to its backup volumes, all
+// of them or only named volumes. Excluded mounts are given by destination or
+// volume name.
+func resolveVolumes(backup *model.ContainerBackup, mode string, excluded []string) []model.Volume {
+	result := slices.Clone(backup.BackupVolumes)
+	for _, vol := range backup.AllVolumes {
+		if mode == "named" && (vol.Type != string(mount.TypeVolume) || vol.Name == "") {
+			continue
+		}
+
+		if isExcluded(vol, excluded) {
+			continue
+		}
+
+		if slices.ContainsFunc(result, func(v model.Volume) bool { return v.Destination == vol.Destination }) {
+			continue
+		}
+
+		result = append(result, vol)
+	}
+
+	for _, entry := range excluded {
+		if !slices.ContainsFunc(backup.AllVolumes, func(vol model.Volume) bool { return isExcluded(vol, []string{entry}) }) {
+			log.Warn().
+				Str("container", backup.ID).
+				Str("volume", entry).
+				Msg("excluded volume not found")
+		}
+	}
+
+	return result
+}
+
+func isExcluded(vol model.Volume, excluded []string) bool {
+	return slices.Contains(excluded, vol.Destination) || (vol.Name != "" && slices.Contains(excluded, vol.Name))
+}
+
+// warnUncoveredVolumes logs the mounts of the container that are neither
+// backed up as volumes, nor contain the paths of an exec, nor are excluded.
+func warnUncoveredVolumes(backup *model.ContainerBackup, excluded []string) {
+	for _, vol := range backup.AllVolumes {
+		covered := isExcluded(vol, excluded) ||
+			slices.ContainsFunc(backup.BackupVolumes, func(v model.Volume) bool { return v.Destination == vol.Destination })
+
+		for _, exec := range backup.Execs {
+			for _, cPath := range exec.Paths {
+				covered = covered || cPath == vol.Destination || strings.HasPrefix(cPath, strings.TrimSuffix(vol.Destination, "/")+"/")
+			}
+		}
+
+		if !covered {
+			log.Warn().
+				Str("container", backup.ID).
+				Str("service", backup.ServiceName).
+				Str("destination", vol.Destination).
+				Str("source", vol.Source).
+				Msg("mount is not covered by any backup, exclude it to silence this warning")
+		}
+	}
+}
+
+// itemNameRegex matches the names of execs, which are part of archive names.
+var itemNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func parseDuration(value string) (time.Duration, error) {
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+
+	if parsed < 0 {
+		return 0, fmt.Errorf("duration must not be negative: %s", value)
+	}
+
+	return parsed, nil
+}
+
+// splitList splits a comma separated label value, ignoring empty entries.
+func splitList(value string) []string {
+	var result []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
 func findVolumeByDestination(target string, inspect container.InspectResponse) *model.Volume {
 	for _, m := range inspect.Mounts {
 		if m.Destination == target {
@@ -0,0 +1,415 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
+)
+
+func inspectWithLabels(labels map[string]string) container.InspectResponse {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:          "abc",
+			GraphDriver: storage.DriverData{Name: "overlay2"},
+		},
+		Config: &container.Config{Labels: labels},
+	}
+}
+
+func TestMapInspectToContainerBackup_ReportsAllProblems(t *testing.T) {
+	_, err := mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelBackupMode:          "sometimes",
+		model.LabelExec:                "pg_dump",
+		model.LabelVolumesPfx + "data": "/data",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "unrecognized backup mode: sometimes")
+	assert.ErrorContains(t, err, "exec must have either paths or stdout")
+	assert.ErrorContains(t, err, "volume for destination /data not found")
+	assert.ErrorContains(t, err, "container must have a service name")
+}
+
+func TestMapInspectToProject_ReportsAllProblems(t *testing.T) {
+	_, err := mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectWhen:     "whenever",
+		model.LabelProjectPriority: "high",
+	}), model.ContainerEngineDocker, "")
+
+	assert.ErrorContains(t, err, "project name not found")
+	assert.ErrorContains(t, err, "failed to parse project schedule")
+	assert.ErrorContains(t, err, "failed to parse project priority")
+}
+
+func TestMapInspectToContainerBackup_Hooks(t *testing.T) {
+	backup, err := mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelPreExec:     "psql -c 'CHECKPOINT'",
+		model.LabelPostExec:    "true",
+	}), model.ContainerEngineDocker)
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.Hooks{
+		Pre:       []string{"psql", "-c", "CHECKPOINT"},
+		Post:      []string{"true"},
+		Container: "db",
+	}, backup.Hooks)
+
+	backup, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelBackupMode:  "offline",
+		model.LabelFinallyExec: "notify",
+		model.LabelHooksOnHost: "true",
+	}), model.ContainerEngineDocker)
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.Hooks{Finally: []string{"notify"}}, backup.Hooks)
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelBackupMode:  "offline",
+		model.LabelFinallyExec: "notify",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "cannot run post or finally hooks itself with offline backup mode")
+}
+
+func TestMapInspectToContainerBackup_Paused(t *testing.T) {
+	backup, err := mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelBackupMode:  "paused",
+		model.LabelFinallyExec: "notify",
+	}), model.ContainerEngineDocker)
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.BackupModePaused, backup.Mode)
+	assert.Equal(t, "db", backup.Hooks.Container)
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelBackupMode:  "paused",
+		model.LabelExec:        "pg_dumpall",
+		model.LabelExecStdout:  "true",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "cannot have exec with paused backup mode")
+}
+
+func TestMapInspectToContainerBackup_Snapshot(t *testing.T) {
+	inspect := inspectWithLabels(map[string]string{
+		model.LabelServiceName:      "db",
+		model.LabelBackupMode:       "offline",
+		model.LabelSnapshot:         "zfs",
+		model.LabelVolumesPfx + "0": "/data",
+	})
+	inspect.Mounts = []container.MountPoint{{Type: "bind", Source: "/tank/db", Destination: "/data"}}
+
+	backup, err := mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot.KindZFS, backup.Snapshot)
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelSnapshot:    "ext4",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "failed to parse snapshot provider")
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelSnapshot:    "btrfs",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "container must have volumes to snapshot")
+}
+
+func TestMapInspectToContainerBackup_Preset(t *testing.T) {
+	inspect := inspectWithLabels(map[string]string{
+		model.LabelServiceName:    "db",
+		model.LabelPreset:         "postgres",
+		model.LabelPresetDatabase: "paperless",
+	})
+	inspect.Config.Env = []string{"POSTGRES_USER=paperless", "POSTGRES_PASSWORD=secret"}
+
+	backup, err := mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.NoError(t, err)
+	if assert.Len(t, backup.Execs, 1) {
+		assert.Equal(t, "postgres", backup.Execs[0].Name)
+		assert.Equal(t, "pg_dump", backup.Execs[0].Command[0])
+		assert.Contains(t, backup.Execs[0].Command, "&{POSTGRES_USER}")
+		assert.True(t, backup.Execs[0].Stdout)
+		assert.NotNil(t, backup.Execs[0].Check)
+	}
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName:                 "db",
+		model.LabelPreset:                      "postgres",
+		model.LabelExecPfx + "postgres":        "pg_dumpall",
+		model.LabelExecPfx + "postgres.stdout": "true",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "container has more than one exec named postgres")
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelPreset:      "mysql",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "failed to apply preset")
+}
+
+func TestMapInspectToContainerBackup_Items(t *testing.T) {
+	inspect := inspectWithLabels(map[string]string{
+		model.LabelServiceName:                     "app",
+		model.LabelExec:                            "dump-settings",
+		model.LabelExecStdout:                      "true",
+		model.LabelExecPfx + "db":                  "pg_dumpall",
+		model.LabelExecPfx + "db.stdout":           "true",
+		model.LabelExecPfx + "export":              "export --to /data/export",
+		model.LabelExecPfx + "export.paths.0":      "/data/export",
+		model.LabelExecPfx + "export.paths_source": "archive",
+		model.LabelVolumesPfx + "0":                "/uploads",
+		model.LabelArchives:                        "combined",
+	})
+	inspect.Mounts = []container.MountPoint{
+		{Type: "bind", Source: "/srv/app/data", Destination: "/data"},
+		{Type: "bind", Source: "/srv/app/uploads", Destination: "/uploads"},
+	}
+
+	backup, err := mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.NoError(t, err)
+	assert.True(t, backup.CombineArchives)
+	assert.Equal(t, 4, backup.ItemCount())
+
+	names := make([]string, 0, len(backup.Execs))
+	for _, exec := range backup.Execs {
+		names = append(names, exec.ItemName())
+	}
+
+	assert.Equal(t, []string{"db", "exec", "export"}, names)
+	assert.Equal(t, []string{"/data/export"}, backup.Execs[2].Paths)
+	assert.Equal(t, model.PathSourceArchive, backup.Execs[2].PathSource)
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName:            "app",
+		model.LabelExecPfx + "db.stdout":  "true",
+		model.LabelExecPfx + "db.verbose": "true",
+		model.LabelExecPfx + "my dump":    "dump",
+		model.LabelArchives:               "some",
+		model.LabelExecPathsSource:        "overlay",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "unrecognized paths source: overlay")
+
+	assert.ErrorContains(t, err, "exec db must have a command")
+	assert.ErrorContains(t, err, "unrecognized label "+model.LabelExecPfx+"db.verbose")
+	assert.ErrorContains(t, err, `invalid exec name "my dump"`)
+	assert.ErrorContains(t, err, "unrecognized archives option")
+}
+
+func TestMapInspectToContainerBackup_AllVolumes(t *testing.T) {
+	mounts := []container.MountPoint{
+		{Type: "volume", Name: "app_data", Source: "/var/lib/docker/volumes/app_data/_data", Destination: "/data"},
+		{Type: "volume", Name: "app_cache", Source: "/var/lib/docker/volumes/app_cache/_data", Destination: "/cache"},
+		{Type: "bind", Source: "/srv/app/config", Destination: "/config"},
+		{Type: "bind", Source: "/var/run/docker.sock", Destination: "/var/run/docker.sock"},
+	}
+
+	inspect := inspectWithLabels(map[string]string{
+		model.LabelServiceName:    "app",
+		model.LabelVolumes:        "all",
+		model.LabelVolumesExclude: "app_cache, /var/run/docker.sock",
+	})
+	inspect.Mounts = mounts
+
+	backup, err := mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/data", "/config"}, destinations(backup.BackupVolumes))
+
+	inspect = inspectWithLabels(map[string]string{
+		model.LabelServiceName:      "app",
+		model.LabelVolumes:          "named",
+		model.LabelVolumesPfx + "0": "/config",
+	})
+	inspect.Mounts = mounts
+
+	backup, err = mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/config", "/data", "/cache"}, destinations(backup.BackupVolumes))
+
+	_, err = mapInspectToContainerBackup(inspectWithLabels(map[string]string{
+		model.LabelServiceName: "app",
+		model.LabelVolumes:     "some",
+	}), model.ContainerEngineDocker)
+
+	assert.ErrorContains(t, err, "unrecognized volumes option")
+}
+
+func destinations(volumes []model.Volume) []string {
+	result := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		result = append(result, vol.Destination)
+	}
+
+	return result
+}
+
+func TestMapInspectToProject_Hooks(t *testing.T) {
+	project, err := mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectName:           "app",
+		model.LabelProjectWhen:           "@daily",
+		model.LabelProjectPreExec:        "maintenance on",
+		model.LabelProjectFinallyExec:    "maintenance off",
+		model.LabelProjectHooksContainer: "web",
+	}), model.ContainerEngineDocker, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.Hooks{
+		Pre:       []string{"maintenance", "on"},
+		Finally:   []string{"maintenance", "off"},
+		Container: "web",
+	}, project.Hooks)
+
+	assert.Equal(t, []string{"hooks container web not found"}, errorStrings(project.Validate()))
+}
+
+func errorStrings(errs []error) []string {
+	result := make([]string, 0, len(errs))
+	for _, err := range errs {
+		result = append(result, err.Error())
+	}
+
+	return result
+}
+
+func TestMapInspectToProject_Windows(t *testing.T) {
+	project, err := mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectName:         "app",
+		model.LabelProjectWhen:         "@daily",
+		model.LabelProjectWindow:       "Mon-Fri 22:00-06:00; !Fri 23:00-24:00",
+		model.LabelProjectWindowPolicy: "skip",
+	}), model.ContainerEngineDocker, "")
+
+	assert.NoError(t, err)
+	assert.Len(t, project.Windows.Allowed, 1)
+	assert.Equal(t, "Mon-Fri 22:00-06:00", project.Windows.Allowed[0].String())
+	assert.Len(t, project.Windows.Blackout, 1)
+	assert.Equal(t, "Fri 23:00-24:00", project.Windows.Blackout[0].String())
+	assert.Equal(t, window.PolicySkip, project.Windows.Policy)
+
+	_, err = mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectName:         "app",
+		model.LabelProjectWindow:       "Someday 22:00-06:00",
+		model.LabelProjectWindowPolicy: "sometimes",
+	}), model.ContainerEngineDocker, "")
+
+	assert.ErrorContains(t, err, "failed to parse project window")
+}
+
+func TestMapInspectToProject_Dependencies(t *testing.T) {
+	project, err := mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectName:     "app",
+		model.LabelProjectAfter:    "db-dump, files,",
+		model.LabelProjectRequires: "volumes",
+	}), model.ContainerEngineDocker, "")
+
+	assert.NoError(t, err)
+	assert.Nil(t, project.Schedule)
+	assert.Equal(t, []string{"db-dump", "files"}, project.After)
+	assert.Equal(t, []string{"volumes"}, project.Requires)
+}
+
+func TestMapInspectToProject_PodName(t *testing.T) {
+	project, err := mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectWhen: "@daily",
+	}), model.ContainerEnginePodman, "nextcloud")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "nextcloud", project.ProjectName)
+	assert.Equal(t, model.ContainerEnginePodman, project.Engine)
+
+	project, err = mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectName: "cloud",
+		model.LabelProjectWhen: "@daily",
+	}), model.ContainerEnginePodman, "nextcloud")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "cloud", project.ProjectName)
+}
+
+func TestMapInspectToContainerBackup_PodmanOverlay(t *testing.T) {
+	inspect := inspectWithLabels(map[string]string{model.LabelServiceName: "app"})
+	inspect.GraphDriver = storage.DriverData{
+		Name: "overlay",
+		Data: map[string]string{"UpperDir": "/home/user/.local/share/containers/storage/overlay/abc/diff"},
+	}
+
+	backup, err := mapInspectToContainerBackup(inspect, model.ContainerEnginePodman)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/user/.local/share/containers/storage/overlay/abc/diff", backup.UpperDirPath)
+}
+
+func TestMapInspectToProject_Retention(t *testing.T) {
+	project, err := mapInspectToProject(inspectWithLabels(map[string]string{
+		model.LabelProjectName:      "app",
+		model.LabelProjectWhen:      "@daily",
+		model.LabelProjectRetention: "within=2d, daily=7,monthly=6",
+	}), model.ContainerEngineDocker, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Retention{Within: "2d", Daily: 7, Monthly: 6}, project.Retention)
+	assert.Equal(t, "within=2d,daily=7,monthly=6", project.Retention.String())
+
+	for _, invalid := range []string{"", "daily", "daily=0", "within=2 days", "forever=1"} {
+		_, err = mapInspectToProject(inspectWithLabels(map[string]string{
+			model.LabelProjectName:      "app",
+			model.LabelProjectWhen:      "@daily",
+			model.LabelProjectRetention: invalid,
+		}), model.ContainerEngineDocker, "")
+
+		assert.ErrorContains(t, err, "failed to parse project retention", invalid)
+	}
+}
+
+func TestMapInspectToContainerBackup_ServiceSchedule(t *testing.T) {
+	inspect := inspectWithLabels(map[string]string{
+		model.LabelServiceName: "db",
+		model.LabelServiceWhen: "@hourly",
+		model.LabelExec:        "pg_dumpall",
+		model.LabelExecStdout:  "true",
+	})
+
+	backup, err := mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.NoError(t, err)
+	assert.NotNil(t, backup.Schedule)
+
+	delete(inspect.Config.Labels, model.LabelExec)
+	inspect.Config.Labels[model.LabelServiceWhen] = "hourly"
+
+	_, err = mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.ErrorContains(t, err, "failed to parse service schedule")
+
+	inspect.Config.Labels[model.LabelServiceWhen] = "@hourly"
+
+	_, err = mapInspectToContainerBackup(inspect, model.ContainerEngineDocker)
+	assert.ErrorContains(t, err, "container must have something to back up to have its own schedule")
+}
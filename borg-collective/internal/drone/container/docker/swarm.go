@@ -0,0 +1,151 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+// labels set by Swarm on the containers of service tasks
+const (
+	labelSwarmServiceID   = "com.docker.swarm.service.id"
+	labelSwarmServiceName = "com.docker.swarm.service.name"
+	labelSwarmTaskID      = "com.docker.swarm.task.id"
+	labelStackNamespace   = "com.docker.stack.namespace"
+)
+
+const borgdLabelPfx = "io.v47.borgd."
+
+func isSwarmTask(labels map[string]string) bool {
+	_, found := labels[labelSwarmTaskID]
+	return found
+}
+
+// refreshSwarmState determines whether the engine is a Swarm manager, only
+// managers can read the labels of services. Must be called with the cache
+// mutex held.
+func (c *Client) refreshSwarmState(ctx context.Context) {
+	info, err := c.dc.Info(ctx)
+	if err != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(err).
+			Str("engine", (string)(c.engine)).
+			Msg("failed to determine swarm state, ignoring swarm services")
+
+		c.swarmManager = false
+		return
+	}
+
+	manager := info.Swarm.LocalNodeState == swarm.LocalNodeStateActive && info.Swarm.ControlAvailable
+	if manager != c.swarmManager {
+		log.Info().
+			Ctx(ctx).
+			Str("engine", (string)(c.engine)).
+			Bool("manager", manager).
+			Msg("swarm state changed")
+	}
+
+	if !manager && info.Swarm.LocalNodeState == swarm.LocalNodeStateActive {
+		log.Warn().
+			Ctx(ctx).
+			Str("engine", (string)(c.engine)).
+			Msg("swarm worker node, borgd labels of services are ignored, only the labels of their containers are read")
+	}
+
+	c.swarmManager = manager
+}
+
+// serviceContainers lists the local containers of the tasks of the service.
+func (c *Client) serviceContainers(ctx context.Context, serviceID string) ([]container.Summary, error) {
+	tasks, err := c.dc.ContainerList(
+		ctx,
+		container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", labelSwarmServiceID+"="+serviceID)),
+		},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers of swarm service %s: %w", serviceID, err)
+	}
+
+	return tasks, nil
+}
+
+// withServiceLabels returns the inspection of a Swarm task with the borgd
+// labels of its service added, the labels of the container take precedence.
+// Service labels are only available on managers, worker nodes only see the
+// labels of the container, so services backed up on workers have to set the
+// borgd labels on their containers (container labels of the service spec
+// rather than deploy labels). On both, the project name defaults to the stack
+// namespace and the service name to the name of the service within the stack,
+// both read from the labels Swarm sets on the container. Must be called with
+// the cache mutex held.
+func (c *Client) withServiceLabels(ctx context.Context, inspect container.InspectResponse) (container.InspectResponse, error) {
+	if inspect.Config == nil || !isSwarmTask(inspect.Config.Labels) {
+		return inspect, nil
+	}
+
+	labels := maps.Clone(inspect.Config.Labels)
+	if c.swarmManager {
+		service, _, err := c.dc.ServiceInspectWithRaw(ctx, labels[labelSwarmServiceID], swarm.ServiceInspectOptions{})
+		if err != nil {
+			return inspect, fmt.Errorf("failed to inspect swarm service %s: %w", labels[labelSwarmServiceID], err)
+		}
+
+		for key, value := range service.Spec.Labels {
+			if _, found := labels[key]; !found && strings.HasPrefix(key, borgdLabelPfx) {
+				labels[key] = value
+			}
+		}
+	}
+
+	serviceName := labels[labelSwarmServiceName]
+	namespace := labels[labelStackNamespace]
+	if _, found := labels[model.LabelProjectName]; !found {
+		if namespace != "" {
+			labels[model.LabelProjectName] = namespace
+		} else {
+			labels[model.LabelProjectName] = serviceName
+		}
+	}
+
+	if _, found := labels[model.LabelServiceName]; !found && serviceName != "" {
+		labels[model.LabelServiceName] = strings.TrimPrefix(serviceName, namespace+"_")
+	}
+
+	config := *inspect.Config
+	config.Labels = labels
+	inspect.Config = &config
+
+	return inspect, nil
+}
+
+// isInactiveTask reports whether the container belongs to a Swarm task that
+// isn't running, Swarm keeps the containers of previous tasks around.
+func isInactiveTask(inspect container.InspectResponse) bool {
+	return isSwarmTask(inspect.Config.Labels) && (inspect.State == nil || !inspect.State.Running)
+}
@@ -19,7 +19,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/pkg/errors"
@@ -28,13 +30,18 @@ import (
 )
 
 type Watch struct {
-	updates chan model.ContainerBackupProject
-	err     chan error
+	updates   chan model.ContainerBackupProject
+	err       chan error
+	closeOnce sync.Once
 }
 
+// Close closes the update and error channels, it is safe to call Close more
+// than once.
 func (w *Watch) Close() error {
-	close(w.updates)
-	close(w.err)
+	w.closeOnce.Do(func() {
+		close(w.updates)
+		close(w.err)
+	})
 
 	return nil
 }
@@ -47,6 +54,10 @@ func (w *Watch) Errors() <-chan error {
 	return w.err
 }
 
+// Watch publishes the projects changed by container and service events. Errors
+// handling single events are published as well. The watch is closed when the
+// context is done or the event stream ends, e.g. because the daemon was
+// restarted, in which case the error that ended it is published first.
 func (c *Client) Watch(ctx context.Context) (*Watch, error) {
 	dockerEvents, errChan := c.dc.Events(
 		ctx,
@@ -54,7 +65,10 @@ func (c *Client) Watch(ctx context.Context) (*Watch, error) {
 			Filters: filters.NewArgs(
 				filters.Arg("event", (string)(events.ActionCreate)),
 				filters.Arg("event", (string)(events.ActionUpdate)),
+				filters.Arg("event", (string)(events.ActionStart)),
+				filters.Arg("event", (string)(events.ActionDie)),
 				filters.Arg("event", (string)(events.ActionDestroy)),
+				filters.Arg("event", (string)(events.ActionRemove)),
 			),
 		},
 	)
@@ -75,16 +89,23 @@ func (c *Client) Watch(ctx context.Context) (*Watch, error) {
 
 				project, err := c.handleEvent(ctx, event)
 				if err != nil {
-					watch.err <- err
+					watch.publishError(ctx, err)
 				} else if project != nil {
-					watch.updates <- *project
+					select {
+					case watch.updates <- *project:
+					case <-ctx.Done():
+					}
 				}
 			case eventsErr, ok := <-errChan:
 				if !ok {
+					// the event stream ended, the previous error says why
+					_ = watch.Close()
 					return
 				}
 
-				watch.err <- eventsErr
+				if ctx.Err() == nil {
+					watch.publishError(ctx, eventsErr)
+				}
 			case <-ctx.Done():
 				_ = watch.Close()
 				return
@@ -94,12 +115,19 @@ func (c *Client) Watch(ctx context.Context) (*Watch, error) {
 
 	log.Info().
 		Ctx(ctx).
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
 		Msg("watching for container changes")
 
 	return watch, nil
 }
 
+func (w *Watch) publishError(ctx context.Context, err error) {
+	select {
+	case w.err <- err:
+	case <-ctx.Done():
+	}
+}
+
 func (c *Client) handleEvent(ctx context.Context, event events.Message) (*model.ContainerBackupProject, error) {
 	c.cacheMutex.Lock()
 	defer c.cacheMutex.Unlock()
@@ -112,12 +140,27 @@ func (c *Client) handleEvent(ctx context.Context, event events.Message) (*model.
 		if event.Action == events.ActionCreate || event.Action == events.ActionUpdate {
 			eventHandled = true
 			project, err = c.handleContainerUpdated(ctx, event.Actor.ID)
-		} else if event.Action == events.ActionDestroy {
+		} else if event.Action == events.ActionDestroy || event.Action == events.ActionRemove {
 			eventHandled = true
 			project = c.handleContainerDestroyed(event.Actor.ID)
+		} else if isSwarmTask(event.Actor.Attributes) {
+			// tasks are rescheduled by starting new containers, the containers
+			// of previous tasks are kept around
+			if event.Action == events.ActionStart {
+				eventHandled = true
+				project, err = c.handleContainerUpdated(ctx, event.Actor.ID)
+			} else if event.Action == events.ActionDie {
+				eventHandled = true
+				project, err = c.handleTaskStopped(ctx, event.Actor.ID, event.Actor.Attributes[labelSwarmServiceID])
+			}
+		}
+	} else if event.Type == events.ServiceEventType {
+		eventHandled = true
+		if event.Action == events.ActionUpdate && c.swarmManager {
+			project, err = c.handleServiceUpdated(ctx, event.Actor.ID)
 		}
 	} else {
-		// we only care about events concerning containers
+		// we only care about events concerning containers and services
 		eventHandled = true
 	}
 
@@ -138,46 +181,68 @@ func (c *Client) handleContainerUpdated(ctx context.Context, containerID string)
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to inspect container %s", containerID))
 	}
 
+	inspect, err = c.resolveLabels(ctx, inspect)
+	if err != nil {
+		return nil, err
+	}
+
+	if isSwarmTask(inspect.Config.Labels) && (!isBorgdEnabled(inspect) || isInactiveTask(inspect)) {
+		// the labels of the service might have changed, or the task stopped
+		return c.handleContainerDestroyed(containerID), nil
+	}
+
 	if !isBorgdEnabled(inspect) {
 		log.Info().
 			Ctx(ctx).
-			Str("engine", (string)(model.ContainerEngineDocker)).
+			Str("engine", (string)(c.engine)).
 			Str("container", containerID).
 			Msg("Container not enabled for borgd")
 
 		return nil, nil
 	}
 
-	project, err := findOrCreateProject(c.cache, inspect)
+	project, err := c.findOrCreateProject(ctx, c.cache, inspect)
 	if err != nil {
 		log.Warn().
 			Ctx(ctx).
 			Err(err).
-			Str("engine", (string)(model.ContainerEngineDocker)).
+			Str("engine", (string)(c.engine)).
 			Str("container", inspect.ID).
 			Msg("failed to find or create project")
 
 		return nil, nil
 	}
 
-	backup, err := mapInspectToContainerBackup(inspect)
+	backup, err := c.mapInspectToContainerBackup(ctx, inspect)
 	if err != nil {
 		log.Warn().
 			Ctx(ctx).
 			Err(err).
-			Str("engine", (string)(model.ContainerEngineDocker)).
+			Str("engine", (string)(c.engine)).
 			Str("container", inspect.ID).
 			Msg("failed to map inspect to container backup")
 
 		return nil, nil
 	}
 
+	if existing, found := project.Containers[backup.ServiceName]; found && existing.SwarmTask && existing.ID != backup.ID {
+		log.Info().
+			Ctx(ctx).
+			Str("engine", (string)(c.engine)).
+			Str("container", inspect.ID).
+			Str("backedUp", existing.ID).
+			Str("service", backup.ServiceName).
+			Msg("more than one task of the service runs on this node, skipping container")
+
+		return nil, nil
+	}
+
 	if log.Debug().Enabled() {
 		if _, found := c.cache[project.ProjectName]; !found {
 			projectJson, _ := json.Marshal(project)
 			log.Debug().
 				Ctx(ctx).
-				Str("engine", (string)(model.ContainerEngineDocker)).
+				Str("engine", (string)(c.engine)).
 				RawJSON("project", projectJson).
 				Str("projectName", project.ProjectName).
 				Msg("detected new container backup project")
@@ -186,7 +251,7 @@ func (c *Client) handleContainerUpdated(ctx context.Context, containerID string)
 		backupJson, _ := json.Marshal(backup)
 		log.Debug().
 			Ctx(ctx).
-			Str("engine", (string)(model.ContainerEngineDocker)).
+			Str("engine", (string)(c.engine)).
 			RawJSON("backup", backupJson).
 			Str("container", inspect.ID).
 			Msg("detected new or updated container backup")
@@ -198,6 +263,44 @@ func (c *Client) handleContainerUpdated(ctx context.Context, containerID string)
 	return &project, nil
 }
 
+// handleServiceUpdated maps the first running local task of the Swarm service
+// again, the labels of the service might have changed.
+func (c *Client) handleServiceUpdated(ctx context.Context, serviceID string) (*model.ContainerBackupProject, error) {
+	tasks, err := c.serviceContainers(ctx, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range tasks {
+		if task.State != container.StateRunning {
+			continue
+		}
+
+		project, err := c.handleContainerUpdated(ctx, task.ID)
+		if err != nil || project != nil {
+			return project, err
+		}
+	}
+
+	return nil, nil
+}
+
+// handleTaskStopped discards the backup of the stopped Swarm task, another
+// task of the same service running on this node takes its place.
+func (c *Client) handleTaskStopped(ctx context.Context, containerID, serviceID string) (*model.ContainerBackupProject, error) {
+	project := c.handleContainerDestroyed(containerID)
+	if project == nil || serviceID == "" {
+		return project, nil
+	}
+
+	replacement, err := c.handleServiceUpdated(ctx, serviceID)
+	if err != nil || replacement == nil {
+		return project, err
+	}
+
+	return replacement, nil
+}
+
 func (c *Client) handleContainerDestroyed(containerID string) *model.ContainerBackupProject {
 	var project model.ContainerBackupProject
 	var backup model.ContainerBackup
@@ -221,7 +324,7 @@ func (c *Client) handleContainerDestroyed(containerID string) *model.ContainerBa
 
 	backupJson, _ := json.Marshal(backup)
 	log.Info().
-		Str("engine", (string)(model.ContainerEngineDocker)).
+		Str("engine", (string)(c.engine)).
 		RawJSON("backup", backupJson).
 		Str("container", backup.ID).
 		Msg("discarding container backup")
@@ -231,7 +334,7 @@ func (c *Client) handleContainerDestroyed(containerID string) *model.ContainerBa
 
 		projectJson, _ := json.Marshal(project)
 		log.Info().
-			Str("engine", (string)(model.ContainerEngineDocker)).
+			Str("engine", (string)(c.engine)).
 			RawJSON("project", projectJson).
 			Str("projectName", project.ProjectName).
 			Msg("discarding container backup project")
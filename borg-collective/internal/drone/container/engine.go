@@ -17,14 +17,28 @@ package container
 
 import (
 	"context"
+	"io"
 
 	"github.com/vemilyus/borg-collective/internal/utils"
 )
 
 type Engine interface {
+	IsContainerRunning(ctx context.Context, containerID string) (bool, error)
 	EnsureContainerRunning(ctx context.Context, containerID string) error
 	EnsureContainerStopped(ctx context.Context, containerID string) error
 
-	Exec(ctx context.Context, containerID string, cmd []string) error
+	// PauseContainer freezes the processes of the container, if it's running
+	// and not paused already. Returns whether the container was paused, only
+	// then it has to be unpaused again.
+	PauseContainer(ctx context.Context, containerID string) (bool, error)
+	UnpauseContainer(ctx context.Context, containerID string) error
+
+	DisplayCommand(ctx context.Context, containerID string, cmd []string) ([]string, error)
+	Exec(ctx context.Context, containerID string, cmd []string, env []string) error
 	ExecWithOutput(ctx context.Context, containerID string, cmd []string) (utils.ErrorReader, error)
+
+	// CopyFromContainer returns the file or directory at the path in the
+	// container as a tar stream, with names relative to the parent of the
+	// path. It works independent of the storage driver of the container.
+	CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, error)
 }
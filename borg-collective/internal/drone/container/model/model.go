@@ -16,25 +16,65 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
 )
 
 const (
 	LabelBorgdEnabled = "io.v47.borgd.enabled"
 
-	LabelProjectName = "io.v47.borgd.project_name"
-	LabelProjectWhen = "io.v47.borgd.when"
+	LabelProjectName      = "io.v47.borgd.project_name"
+	LabelProjectWhen      = "io.v47.borgd.when"
+	LabelProjectPriority  = "io.v47.borgd.priority"
+	LabelProjectCatchUp   = "io.v47.borgd.catch_up"
+	LabelProjectJitter    = "io.v47.borgd.jitter"
+	LabelProjectTimeout   = "io.v47.borgd.timeout"
+	LabelProjectRetention = "io.v47.borgd.retention"
+
+	LabelProjectWindow       = "io.v47.borgd.window"
+	LabelProjectWindowPolicy = "io.v47.borgd.window_policy"
+
+	LabelProjectAfter    = "io.v47.borgd.after"
+	LabelProjectRequires = "io.v47.borgd.requires"
+
+	LabelProjectPreExec        = "io.v47.borgd.pre_exec"
+	LabelProjectPostExec       = "io.v47.borgd.post_exec"
+	LabelProjectFinallyExec    = "io.v47.borgd.finally_exec"
+	LabelProjectHooksContainer = "io.v47.borgd.hooks_container"
 
 	LabelBackupMode      = "io.v47.borgd.service.mode"
+	LabelServiceWhen     = "io.v47.borgd.service.when"
+	LabelMaxDowntime     = "io.v47.borgd.service.max_downtime"
 	LabelDependenciesPfx = "io.v47.borgd.service.dependencies."
 	LabelExec            = "io.v47.borgd.service.exec"
+	LabelExecPfx         = "io.v47.borgd.service.exec."
 	LabelExecStdout      = "io.v47.borgd.service.stdout"
 	LabelExecPathsPfx    = "io.v47.borgd.service.paths."
+	LabelExecPathsSource = "io.v47.borgd.service.paths_source"
 	LabelServiceName     = "io.v47.borgd.service_name"
 	LabelVolumesPfx      = "io.v47.borgd.service.volumes."
+	LabelVolumes         = "io.v47.borgd.service.volumes"
+	LabelVolumesExclude  = "io.v47.borgd.service.volumes_exclude"
+	LabelSnapshot        = "io.v47.borgd.service.snapshot"
+	LabelPreset          = "io.v47.borgd.service.preset"
+	LabelPresetDatabase  = "io.v47.borgd.service.preset.database"
+	LabelArchives        = "io.v47.borgd.service.archives"
+
+	LabelPreExec     = "io.v47.borgd.service.pre_exec"
+	LabelPostExec    = "io.v47.borgd.service.post_exec"
+	LabelFinallyExec = "io.v47.borgd.service.finally_exec"
+	LabelHooksOnHost = "io.v47.borgd.service.hooks_on_host"
 )
 
 type BackupMode uint8
@@ -43,6 +83,8 @@ const (
 	BackupModeDefault BackupMode = 1 + iota
 	BackupModeOffline
 	BackupModeDependentOffline
+	BackupModePaused
+	BackupModeDependentPaused
 )
 
 //goland:noinspection GoMixedReceiverTypes
@@ -54,6 +96,10 @@ func (b BackupMode) String() string {
 		return "dependent-offline"
 	case BackupModeOffline:
 		return "offline"
+	case BackupModeDependentPaused:
+		return "dependent-paused"
+	case BackupModePaused:
+		return "paused"
 	}
 
 	panic("invalid backup mode: " + strconv.Itoa(int(b)))
@@ -67,6 +113,10 @@ func BackupModeFromString(s string) (BackupMode, error) {
 		return BackupModeDependentOffline, nil
 	case "offline":
 		return BackupModeOffline, nil
+	case "dependent-paused":
+		return BackupModeDependentPaused, nil
+	case "paused":
+		return BackupModePaused, nil
 	}
 
 	return 0, errors.New("unrecognized backup mode: " + s)
@@ -74,12 +124,17 @@ func BackupModeFromString(s string) (BackupMode, error) {
 
 //goland:noinspection GoMixedReceiverTypes
 func (b BackupMode) MarshalJSON() ([]byte, error) {
-	return []byte(b.String()), nil
+	return json.Marshal(b.String())
 }
 
 //goland:noinspection GoMixedReceiverTypes
 func (b *BackupMode) UnmarshalJSON(bytes []byte) error {
-	bm, err := BackupModeFromString(string(bytes))
+	var s string
+	if err := json.Unmarshal(bytes, &s); err != nil {
+		return err
+	}
+
+	bm, err := BackupModeFromString(s)
 	if err != nil {
 		return err
 	}
@@ -93,34 +148,266 @@ type ContainerEngine string
 
 const (
 	ContainerEngineDocker ContainerEngine = "docker"
+	ContainerEnginePodman ContainerEngine = "podman"
 )
 
 type ContainerBackupProject struct {
 	Engine      ContainerEngine
 	ProjectName string
 	Schedule    cron.Schedule
-	Containers  map[string]ContainerBackup `json:",omitempty"`
+	Priority    int
+	CatchUp     bool
+	Jitter      *time.Duration `json:",omitempty"`
+	Timeout     *time.Duration `json:",omitempty"`
+	Windows     window.Windows
+	After       []string `json:",omitempty"`
+	Requires    []string `json:",omitempty"`
+	Hooks       Hooks
+	// Retention prunes the archives of every container after its backup, the
+	// archives are kept forever without it.
+	Retention  *Retention                 `json:",omitempty"`
+	Containers map[string]ContainerBackup `json:",omitempty"`
+}
+
+// Validate checks the references between the containers of the project.
+func (p ContainerBackupProject) Validate() []error {
+	serviceNames := slices.Sorted(maps.Keys(p.Containers))
+
+	problems := make([]error, 0)
+	if p.Hooks.Container != "" {
+		if _, found := p.Containers[p.Hooks.Container]; !found {
+			problems = append(problems, fmt.Errorf("hooks container %s not found", p.Hooks.Container))
+		}
+	}
+
+	for _, serviceName := range serviceNames {
+		for _, dep := range p.Containers[serviceName].Dependencies {
+			if dep == serviceName {
+				problems = append(problems, fmt.Errorf("%s must not depend on itself", serviceName))
+			} else if _, found := p.Containers[dep]; !found {
+				problems = append(problems, fmt.Errorf("dependency %s of %s not found", dep, serviceName))
+			} else if p.Containers[dep].Mode == BackupModeDependentOffline && p.Containers[serviceName].SwarmTask {
+				problems = append(problems, fmt.Errorf("dependent %s of %s is a Swarm task and cannot be stopped", serviceName, dep))
+			}
+		}
+	}
+
+	return problems
+}
+
+// Retention is passed to borg prune, an archive kept by one rule doesn't count
+// towards the following ones.
+type Retention struct {
+	// Within keeps all archives within the interval, e.g. 2d.
+	Within  string `json:",omitempty"`
+	Hourly  int    `json:",omitempty"`
+	Daily   int    `json:",omitempty"`
+	Weekly  int    `json:",omitempty"`
+	Monthly int    `json:",omitempty"`
+	Yearly  int    `json:",omitempty"`
+}
+
+var retentionWithinRegex = regexp.MustCompile(`^[0-9]+[Hdwmy]$`)
+
+// ParseRetention parses a comma separated list of rules like
+// "within=2d,daily=7,weekly=4".
+func ParseRetention(value string) (*Retention, error) {
+	result := &Retention{}
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		key, raw, found := strings.Cut(rule, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid retention rule: %s", rule)
+		}
+
+		key = strings.TrimSpace(key)
+		raw = strings.TrimSpace(raw)
+
+		if key == "within" {
+			if !retentionWithinRegex.MatchString(raw) {
+				return nil, fmt.Errorf("invalid retention interval: %s", raw)
+			}
+
+			result.Within = raw
+			continue
+		}
+
+		var target *int
+		switch key {
+		case "hourly":
+			target = &result.Hourly
+		case "daily":
+			target = &result.Daily
+		case "weekly":
+			target = &result.Weekly
+		case "monthly":
+			target = &result.Monthly
+		case "yearly":
+			target = &result.Yearly
+		default:
+			return nil, fmt.Errorf("unrecognized retention rule: %s", key)
+		}
+
+		count, err := strconv.Atoi(raw)
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid retention count for %s: %s", key, raw)
+		}
+
+		*target = count
+	}
+
+	if *result == (Retention{}) {
+		return nil, errors.New("retention must have at least one rule")
+	}
+
+	return result, nil
+}
+
+// String returns the rules in the format accepted by ParseRetention.
+func (r Retention) String() string {
+	var rules []string
+	if r.Within != "" {
+		rules = append(rules, "within="+r.Within)
+	}
+
+	for _, rule := range []struct {
+		key   string
+		count int
+	}{{"hourly", r.Hourly}, {"daily", r.Daily}, {"weekly", r.Weekly}, {"monthly", r.Monthly}, {"yearly", r.Yearly}} {
+		if rule.count > 0 {
+			rules = append(rules, rule.key+"="+strconv.Itoa(rule.count))
+		}
+	}
+
+	return strings.Join(rules, ",")
 }
 
 type ContainerBackup struct {
 	ID            string
 	ServiceName   string
 	Mode          BackupMode
+	MaxDowntime   *time.Duration `json:",omitempty"`
 	UpperDirPath  string
-	Exec          *ContainerExecBackup
-	BackupVolumes []Volume `json:",omitempty"`
-	AllVolumes    []Volume `json:",omitempty"`
-	Dependencies  []string `json:",omitempty"`
+	Execs         []ContainerExecBackup `json:",omitempty"`
+	BackupVolumes []Volume              `json:",omitempty"`
+	AllVolumes    []Volume              `json:",omitempty"`
+	Dependencies  []string              `json:",omitempty"`
+	Hooks         Hooks
+	// Snapshot is the provider used to snapshot the backup volumes, which are
+	// then backed up from the snapshots.
+	Snapshot snapshot.Kind `json:",omitempty"`
+	// CombineArchives backs up the execs and volumes into a single archive,
+	// instead of one archive each.
+	CombineArchives bool `json:",omitempty"`
+	// Schedule backs up the container on its own schedule instead of the
+	// schedule of the project.
+	Schedule cron.Schedule `json:",omitempty"`
+	// SwarmTask is set for containers running a task of a Swarm service, they
+	// mustn't be stopped since Swarm would replace them.
+	SwarmTask bool `json:",omitempty"`
+	// SwarmServiceID is the ID of the Swarm service of the task, it stays the
+	// same when Swarm replaces the task.
+	SwarmServiceID string `json:",omitempty"`
 }
 
+// VolumesItem is the item name of the volumes of a container, next to the
+// names of its execs.
+const VolumesItem = "volumes"
+
 func (b *ContainerBackup) NeedsBackup() bool {
-	return b.Exec != nil || len(b.BackupVolumes) > 0
+	return len(b.Execs) > 0 || len(b.BackupVolumes) > 0
+}
+
+// ItemCount returns the number of items backed up from the container, every
+// exec and all volumes together count as one item each.
+func (b *ContainerBackup) ItemCount() int {
+	count := len(b.Execs)
+	if len(b.BackupVolumes) > 0 {
+		count++
+	}
+
+	return count
+}
+
+// Hooks are commands run before and after a backup, with the same semantics
+// as the commands of static backups: post only runs if the backup succeeded,
+// finally always runs.
+type Hooks struct {
+	Pre     []string `json:",omitempty"`
+	Post    []string `json:",omitempty"`
+	Finally []string `json:",omitempty"`
+
+	// Container is the service name of the container to run the hooks in,
+	// they are run on the host if it's empty.
+	Container string `json:",omitempty"`
+}
+
+func (h Hooks) IsEmpty() bool {
+	return len(h.Pre) == 0 && len(h.Post) == 0 && len(h.Finally) == 0
 }
 
 type ContainerExecBackup struct {
+	// Name is empty for the exec configured without a name.
+	Name    string `json:",omitempty"`
 	Command []string
 	Stdout  bool
 	Paths   []string `json:",omitempty"`
+	// PathSource is where the paths are read from after the command ran.
+	PathSource PathSource `json:",omitempty"`
+	// Restore is the command restoring the output in the container, it reads
+	// the output from stdin.
+	Restore []string `json:",omitempty"`
+	// Check is run against the output after the backup, it fails the backup
+	// if the output is incomplete.
+	Check *DumpCheck `json:",omitempty"`
+}
+
+// PathSource is where the paths of an exec are read from.
+type PathSource string
+
+const (
+	// PathSourceAuto reads the paths from the host if all of them are on
+	// mounts or in the overlay upper directory of the container, otherwise
+	// through the archive API.
+	PathSourceAuto PathSource = ""
+	// PathSourceHost reads the paths from the mounts or the overlay upper
+	// directory of the container on the host, all paths must be on one of them.
+	PathSourceHost PathSource = "host"
+	// PathSourceArchive reads the paths through the archive API of the
+	// engine, which works for every storage driver.
+	PathSourceArchive PathSource = "archive"
+)
+
+func PathSourceFromString(value string) (PathSource, error) {
+	switch source := PathSource(value); source {
+	case PathSourceHost, PathSourceArchive:
+		return source, nil
+	case "auto":
+		return PathSourceAuto, nil
+	default:
+		return "", fmt.Errorf("unrecognized paths source: %s", value)
+	}
+}
+
+// ItemName returns the name of the exec among the items of the container.
+func (e ContainerExecBackup) ItemName() string {
+	if e.Name == "" {
+		return "exec"
+	}
+
+	return e.Name
+}
+
+// DumpCheck is a sanity check of a database dump. The dump must start with
+// Prefix and contain Suffix close to its end, where dump tools write their
+// completion marker.
+type DumpCheck struct {
+	Prefix string `json:",omitempty"`
+	Suffix string `json:",omitempty"`
 }
 
 type Volume struct {
@@ -0,0 +1,145 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package podman connects to Podman through its Docker compatible API, and
+// uses the libpod API for what the compatible API doesn't provide.
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+const (
+	libpodAPIVersion = "v4.0.0"
+	rootSocketPath   = "/run/podman/podman.sock"
+)
+
+// SocketPath returns the path of the Podman API socket. It is taken from
+// CONTAINER_HOST if set, otherwise it is the socket of the rootful service
+// when running as root, or the one of the rootless service of the user.
+func SocketPath() (string, error) {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		socketPath, found := strings.CutPrefix(host, "unix://")
+		if !found {
+			return "", fmt.Errorf("unsupported CONTAINER_HOST, only unix sockets are supported: %s", host)
+		}
+
+		return socketPath, nil
+	}
+
+	if os.Geteuid() == 0 {
+		return rootSocketPath, nil
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Geteuid())
+	}
+
+	return filepath.Join(runtimeDir, "podman", "podman.sock"), nil
+}
+
+// NewClient returns a client for the Podman API at socketPath.
+func NewClient(socketPath string) (*docker.Client, error) {
+	dc, err := client.NewClientWithOpts(
+		client.WithHost("unix://"+socketPath),
+		client.WithAPIVersionNegotiation(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return docker.NewEngineClient(dc, docker.EngineOptions{
+		Engine:  model.ContainerEnginePodman,
+		PodName: newLibpodClient(socketPath).podName,
+	}), nil
+}
+
+type libpodClient struct {
+	httpClient *http.Client
+}
+
+func newLibpodClient(socketPath string) *libpodClient {
+	return &libpodClient{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+type libpodContainer struct {
+	ID      string `json:"Id"`
+	PodName string
+}
+
+// podName returns the name of the pod the container belongs to, the Docker
+// compatible API doesn't report pods.
+func (c *libpodClient) podName(ctx context.Context, containerID string) (string, error) {
+	containerFilters, _ := json.Marshal(map[string][]string{"id": {containerID}})
+
+	query := url.Values{}
+	query.Set("all", "true")
+	query.Set("filters", string(containerFilters))
+
+	var containers []libpodContainer
+	if err := c.get(ctx, "/containers/json?"+query.Encode(), &containers); err != nil {
+		return "", err
+	}
+
+	for _, ctnr := range containers {
+		if ctnr.ID == containerID {
+			return ctnr.PodName, nil
+		}
+	}
+
+	return "", fmt.Errorf("container not found: %s", containerID)
+}
+
+func (c *libpodClient) get(ctx context.Context, path string, result any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://d/"+libpodAPIVersion+"/libpod"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("libpod API request failed with status %s: %s", response.Status, path)
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
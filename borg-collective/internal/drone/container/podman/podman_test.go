@@ -0,0 +1,67 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketPath(t *testing.T) {
+	t.Setenv("CONTAINER_HOST", "unix:///run/user/1000/podman/podman.sock")
+
+	socketPath, err := SocketPath()
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/user/1000/podman/podman.sock", socketPath)
+
+	t.Setenv("CONTAINER_HOST", "ssh://core@example.com/run/podman/podman.sock")
+
+	_, err = SocketPath()
+	assert.Error(t, err)
+}
+
+func TestLibpodClient_PodName(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "podman.sock")
+	listener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+
+	var requestedFilters string
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v4.0.0/libpod/containers/json", r.URL.Path)
+		requestedFilters = r.URL.Query().Get("filters")
+
+		_ = json.NewEncoder(w).Encode([]map[string]string{{"Id": "abc", "PodName": "nextcloud"}})
+	})}
+
+	go func() { _ = server.Serve(listener) }()
+	defer func() { _ = server.Close() }()
+
+	lp := newLibpodClient(socketPath)
+
+	podName, err := lp.podName(context.Background(), "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "nextcloud", podName)
+	assert.JSONEq(t, `{"id":["abc"]}`, requestedFilters)
+
+	_, err = lp.podName(context.Background(), "unknown")
+	assert.ErrorContains(t, err, "container not found: unknown")
+}
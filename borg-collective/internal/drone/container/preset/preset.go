@@ -0,0 +1,207 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package preset generates the exec backups of common databases from the
+// standard environment variables of their official images.
+package preset
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+type Preset string
+
+const (
+	Postgres Preset = "postgres"
+	MySQL    Preset = "mysql"
+	MongoDB  Preset = "mongodb"
+	Redis    Preset = "redis"
+	SQLite   Preset = "sqlite"
+)
+
+func Parse(value string) (Preset, error) {
+	switch preset := Preset(strings.ToLower(strings.TrimSpace(value))); preset {
+	case Postgres, MySQL, MongoDB, Redis, SQLite:
+		return preset, nil
+	case "mariadb":
+		return MySQL, nil
+	default:
+		return "", fmt.Errorf("unrecognized preset: %s", value)
+	}
+}
+
+// mongoArchiveMagic starts every archive written by mongodump --archive.
+const mongoArchiveMagic = "\x6d\xe2\x99\x81"
+
+// ExecBackup returns the exec backup of the preset. Credentials are referenced
+// as "&{VAR}", so they're only expanded when the command is executed. database
+// restricts the dump to a single database, it's the path of the database file
+// for SQLite.
+func (p Preset) ExecBackup(env map[string]string, database string) (model.ContainerExecBackup, error) {
+	switch p {
+	case Postgres:
+		return postgres(env, database), nil
+	case MySQL:
+		return mysql(env, database)
+	case MongoDB:
+		return mongodb(env, database), nil
+	case Redis:
+		if database != "" {
+			return model.ContainerExecBackup{}, fmt.Errorf("preset %s cannot dump a single database", p)
+		}
+
+		return redis(env), nil
+	case SQLite:
+		if database == "" {
+			return model.ContainerExecBackup{}, fmt.Errorf("preset %s requires the path of the database", p)
+		}
+
+		return sqlite(database), nil
+	default:
+		return model.ContainerExecBackup{}, fmt.Errorf("unrecognized preset: %s", p)
+	}
+}
+
+func postgres(env map[string]string, database string) model.ContainerExecBackup {
+	user := envRef(env, "postgres", "POSTGRES_USER")
+
+	if database == "" {
+		return model.ContainerExecBackup{
+			Command: []string{"pg_dumpall", "--clean", "--if-exists", "--username", user},
+			Stdout:  true,
+			Restore: []string{"psql", "--username", user, "--dbname", "postgres"},
+			Check:   &model.DumpCheck{Suffix: "-- PostgreSQL database cluster dump complete"},
+		}
+	}
+
+	return model.ContainerExecBackup{
+		Command: []string{"pg_dump", "--clean", "--if-exists", "--create", "--username", user, "--dbname", database},
+		Stdout:  true,
+		Restore: []string{"psql", "--username", user, "--dbname", "postgres"},
+		Check:   &model.DumpCheck{Suffix: "-- PostgreSQL database dump complete"},
+	}
+}
+
+func mysql(env map[string]string, database string) (model.ContainerExecBackup, error) {
+	dump, client := "mysqldump", "mysql"
+	if hasAny(env, "MARIADB_ROOT_PASSWORD", "MARIADB_USER", "MARIADB_PASSWORD", "MARIADB_DATABASE") {
+		// current MariaDB images don't ship the mysql* binaries anymore
+		dump, client = "mariadb-dump", "mariadb"
+	}
+
+	var credentials []string
+	if hasAny(env, "MARIADB_ROOT_PASSWORD", "MYSQL_ROOT_PASSWORD") {
+		credentials = []string{"--user=root", "--password=" + envRef(env, "", "MARIADB_ROOT_PASSWORD", "MYSQL_ROOT_PASSWORD")}
+	} else if hasAny(env, "MARIADB_USER", "MYSQL_USER") && hasAny(env, "MARIADB_PASSWORD", "MYSQL_PASSWORD") {
+		credentials = []string{
+			"--user=" + envRef(env, "", "MARIADB_USER", "MYSQL_USER"),
+			"--password=" + envRef(env, "", "MARIADB_PASSWORD", "MYSQL_PASSWORD"),
+		}
+
+		// regular users can only dump their own database
+		if database == "" {
+			database = envRef(env, "", "MARIADB_DATABASE", "MYSQL_DATABASE")
+		}
+	} else {
+		return model.ContainerExecBackup{}, fmt.Errorf("preset %s requires MYSQL_ROOT_PASSWORD or MYSQL_USER and MYSQL_PASSWORD", MySQL)
+	}
+
+	command := append([]string{dump, "--single-transaction", "--routines", "--events"}, credentials...)
+	if database == "" {
+		command = append(command, "--all-databases")
+	} else {
+		command = append(command, "--databases", database)
+	}
+
+	return model.ContainerExecBackup{
+		Command: command,
+		Stdout:  true,
+		Restore: append([]string{client}, credentials...),
+		Check:   &model.DumpCheck{Suffix: "-- Dump completed"},
+	}, nil
+}
+
+func mongodb(env map[string]string, database string) model.ContainerExecBackup {
+	var credentials []string
+	if hasAny(env, "MONGO_INITDB_ROOT_USERNAME") && hasAny(env, "MONGO_INITDB_ROOT_PASSWORD") {
+		credentials = []string{
+			"--username", envRef(env, "", "MONGO_INITDB_ROOT_USERNAME"),
+			"--password", envRef(env, "", "MONGO_INITDB_ROOT_PASSWORD"),
+			"--authenticationDatabase", "admin",
+		}
+	}
+
+	command := append([]string{"mongodump", "--archive"}, credentials...)
+	if database != "" {
+		command = append(command, "--db", database)
+	}
+
+	return model.ContainerExecBackup{
+		Command: command,
+		Stdout:  true,
+		Restore: append([]string{"mongorestore", "--archive", "--drop"}, credentials...),
+		Check:   &model.DumpCheck{Prefix: mongoArchiveMagic},
+	}
+}
+
+func redis(env map[string]string) model.ContainerExecBackup {
+	command := []string{"redis-cli"}
+	if hasAny(env, "REDIS_PASSWORD") {
+		command = append(command, "-a", envRef(env, "", "REDIS_PASSWORD"), "--no-auth-warning")
+	}
+
+	return model.ContainerExecBackup{
+		Command: append(command, "--rdb", "-"),
+		Stdout:  true,
+		// the dump replaces the data file, Redis has to be restarted without
+		// saving for it to be loaded
+		Restore: []string{"sh", "-c", "cat > /data/dump.rdb"},
+		Check:   &model.DumpCheck{Prefix: "REDIS"},
+	}
+}
+
+func sqlite(path string) model.ContainerExecBackup {
+	return model.ContainerExecBackup{
+		Command: []string{"sqlite3", "-readonly", path, ".dump"},
+		Stdout:  true,
+		Restore: []string{"sqlite3", path},
+		Check:   &model.DumpCheck{Suffix: "COMMIT;"},
+	}
+}
+
+// envRef references the first of the variables set in env, or returns the
+// default value if none of them is set.
+func envRef(env map[string]string, defaultValue string, names ...string) string {
+	for _, name := range names {
+		if _, found := env[name]; found {
+			return "&{" + name + "}"
+		}
+	}
+
+	return defaultValue
+}
+
+func hasAny(env map[string]string, names ...string) bool {
+	for _, name := range names {
+		if _, found := env[name]; found {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package preset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+func TestParse(t *testing.T) {
+	parsed, err := Parse(" Postgres ")
+	assert.NoError(t, err)
+	assert.Equal(t, Postgres, parsed)
+
+	parsed, err = Parse("mariadb")
+	assert.NoError(t, err)
+	assert.Equal(t, MySQL, parsed)
+
+	_, err = Parse("oracle")
+	assert.ErrorContains(t, err, "unrecognized preset: oracle")
+}
+
+func TestPostgres(t *testing.T) {
+	exec, err := Postgres.ExecBackup(map[string]string{"POSTGRES_USER": "paperless"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pg_dumpall", "--clean", "--if-exists", "--username", "&{POSTGRES_USER}"}, exec.Command)
+	assert.True(t, exec.Stdout)
+	assert.Equal(t, []string{"psql", "--username", "&{POSTGRES_USER}", "--dbname", "postgres"}, exec.Restore)
+
+	exec, err = Postgres.ExecBackup(map[string]string{}, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pg_dump", "--clean", "--if-exists", "--create", "--username", "postgres", "--dbname", "app"}, exec.Command)
+	assert.Equal(t, &model.DumpCheck{Suffix: "-- PostgreSQL database dump complete"}, exec.Check)
+}
+
+func TestMySQL(t *testing.T) {
+	exec, err := MySQL.ExecBackup(map[string]string{"MYSQL_ROOT_PASSWORD": "secret"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"mysqldump", "--single-transaction", "--routines", "--events",
+		"--user=root", "--password=&{MYSQL_ROOT_PASSWORD}", "--all-databases",
+	}, exec.Command)
+	assert.Equal(t, []string{"mysql", "--user=root", "--password=&{MYSQL_ROOT_PASSWORD}"}, exec.Restore)
+
+	exec, err = MySQL.ExecBackup(map[string]string{
+		"MARIADB_USER":     "app",
+		"MARIADB_PASSWORD": "secret",
+		"MARIADB_DATABASE": "app",
+	}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"mariadb-dump", "--single-transaction", "--routines", "--events",
+		"--user=&{MARIADB_USER}", "--password=&{MARIADB_PASSWORD}", "--databases", "&{MARIADB_DATABASE}",
+	}, exec.Command)
+	assert.Equal(t, "mariadb", exec.Restore[0])
+
+	_, err = MySQL.ExecBackup(map[string]string{"MYSQL_USER": "app"}, "")
+	assert.ErrorContains(t, err, "requires MYSQL_ROOT_PASSWORD or MYSQL_USER and MYSQL_PASSWORD")
+}
+
+func TestMongoDB(t *testing.T) {
+	exec, err := MongoDB.ExecBackup(map[string]string{}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mongodump", "--archive"}, exec.Command)
+	assert.Equal(t, []string{"mongorestore", "--archive", "--drop"}, exec.Restore)
+
+	exec, err = MongoDB.ExecBackup(map[string]string{
+		"MONGO_INITDB_ROOT_USERNAME": "root",
+		"MONGO_INITDB_ROOT_PASSWORD": "secret",
+	}, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"mongodump", "--archive",
+		"--username", "&{MONGO_INITDB_ROOT_USERNAME}",
+		"--password", "&{MONGO_INITDB_ROOT_PASSWORD}",
+		"--authenticationDatabase", "admin",
+		"--db", "app",
+	}, exec.Command)
+}
+
+func TestRedisAndSQLite(t *testing.T) {
+	exec, err := Redis.ExecBackup(map[string]string{"REDIS_PASSWORD": "secret"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"redis-cli", "-a", "&{REDIS_PASSWORD}", "--no-auth-warning", "--rdb", "-"}, exec.Command)
+	assert.Equal(t, &model.DumpCheck{Prefix: "REDIS"}, exec.Check)
+
+	_, err = Redis.ExecBackup(map[string]string{}, "0")
+	assert.ErrorContains(t, err, "cannot dump a single database")
+
+	exec, err = SQLite.ExecBackup(map[string]string{}, "/data/app.db")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sqlite3", "-readonly", "/data/app.db", ".dump"}, exec.Command)
+
+	_, err = SQLite.ExecBackup(map[string]string{}, "")
+	assert.ErrorContains(t, err, "requires the path of the database")
+}
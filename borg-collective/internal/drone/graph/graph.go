@@ -0,0 +1,69 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package graph contains helpers for the dependency graph between jobs.
+package graph
+
+import (
+	"maps"
+	"slices"
+)
+
+// FindCycle returns the first cycle in the directed graph, as the path from
+// a node back to itself, or nil if the graph is acyclic. Nodes only appearing
+// as edge targets are allowed.
+func FindCycle(edges map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(edges))
+	path := make([]string, 0)
+
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		switch state[node] {
+		case visiting:
+			start := slices.Index(path, node)
+			return append(slices.Clone(path[start:]), node)
+		case visited:
+			return nil
+		}
+
+		state[node] = visiting
+		path = append(path, node)
+
+		for _, next := range edges[node] {
+			if cycle := visit(next); cycle != nil {
+				return cycle
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[node] = visited
+
+		return nil
+	}
+
+	for _, node := range slices.Sorted(maps.Keys(edges)) {
+		if cycle := visit(node); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindCycle(t *testing.T) {
+	assert.Nil(t, FindCycle(map[string][]string{
+		"app":        {"db-dump"},
+		"compaction": {"app", "db-dump", "unknown"},
+	}))
+
+	assert.Equal(t, []string{"a", "b", "c", "a"}, FindCycle(map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a"},
+		"d": {"a"},
+	}))
+
+	assert.Equal(t, []string{"self", "self"}, FindCycle(map[string][]string{"self": {"self"}}))
+}
@@ -0,0 +1,205 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// btrfsSubvolumeInode is the inode number of the root directory of every
+// btrfs subvolume.
+const btrfsSubvolumeInode = 256
+
+type btrfsProvider struct{}
+
+// Snapshot creates a read-only snapshot of the subvolume containing the path
+// within that subvolume, so it's always on the same filesystem.
+func (btrfsProvider) Snapshot(ctx context.Context, path string) (Snapshot, error) {
+	subvolume, err := findSubvolume(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	rel, err := filepath.Rel(subvolume, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	target := filepath.Join(subvolume, "."+snapshotName())
+	_, err = run(ctx, "btrfs", "subvolume", "snapshot", "-r", subvolume, target)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{
+		Source: path,
+		Path:   filepath.Join(target, rel),
+		destroy: func(ctx context.Context) error {
+			_, err := run(ctx, "btrfs", "subvolume", "delete", target)
+			return err
+		},
+	}, nil
+}
+
+// findSubvolume returns the root directory of the subvolume containing path.
+var findSubvolume = func(path string) (string, error) {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.IsDir() && stat.Ino == btrfsSubvolumeInode {
+			return dir, nil
+		}
+
+		if dir == filepath.Dir(dir) {
+			return "", errors.New("no btrfs subvolume found for " + path)
+		}
+	}
+}
+
+type lvmProvider struct{}
+
+// Snapshot creates a snapshot of the thin logical volume the path is on and
+// mounts it read-only in a temporary directory.
+func (lvmProvider) Snapshot(ctx context.Context, path string) (Snapshot, error) {
+	mount, err := findMount(ctx, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	output, err := run(ctx, "lvs", "--noheadings", "--options", "vg_name,lv_name", mount.source)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return Snapshot{}, fmt.Errorf("%s on %s is not a logical volume", mount.target, mount.source)
+	}
+
+	volumeGroup, logicalVolume := fields[0], fields[1]
+	snapshotVolume := volumeGroup + "/" + logicalVolume + "-" + snapshotName()
+
+	// thin snapshots are skipped during activation by default
+	_, err = run(ctx, "lvcreate", "--snapshot", "--setactivationskip", "n", "--name", filepath.Base(snapshotVolume), volumeGroup+"/"+logicalVolume)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	removeVolume := func(ctx context.Context) error {
+		_, err := run(ctx, "lvremove", "--force", snapshotVolume)
+		return err
+	}
+
+	mountPoint, err := os.MkdirTemp("", "borgd-lvm-*")
+	if err != nil {
+		return Snapshot{}, errors.Join(err, removeVolume(context.WithoutCancel(ctx)))
+	}
+
+	options := "ro"
+	if mount.fsType == "xfs" {
+		// the snapshot has the same UUID as the mounted original
+		options += ",nouuid"
+	}
+
+	_, err = run(ctx, "mount", "-o", options, "/dev/"+snapshotVolume, mountPoint)
+	if err != nil {
+		return Snapshot{}, errors.Join(err, os.Remove(mountPoint), removeVolume(context.WithoutCancel(ctx)))
+	}
+
+	rel, err := filepath.Rel(mount.target, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{
+		Source: path,
+		Path:   filepath.Join(mountPoint, rel),
+		destroy: func(ctx context.Context) error {
+			if _, err := run(ctx, "umount", mountPoint); err != nil {
+				return err
+			}
+
+			return errors.Join(os.Remove(mountPoint), removeVolume(ctx))
+		},
+	}, nil
+}
+
+type zfsProvider struct{}
+
+// Snapshot creates a snapshot of the dataset the path is on, which is read
+// from the .zfs directory of the dataset.
+func (zfsProvider) Snapshot(ctx context.Context, path string) (Snapshot, error) {
+	mount, err := findMount(ctx, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if mount.fsType != "zfs" {
+		return Snapshot{}, fmt.Errorf("%s is not a ZFS dataset", mount.target)
+	}
+
+	rel, err := filepath.Rel(mount.target, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	name := snapshotName()
+	dataset := mount.source + "@" + name
+
+	_, err = run(ctx, "zfs", "snapshot", dataset)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{
+		Source: path,
+		Path:   filepath.Join(mount.target, ".zfs", "snapshot", name, rel),
+		destroy: func(ctx context.Context) error {
+			_, err := run(ctx, "zfs", "destroy", dataset)
+			return err
+		},
+	}, nil
+}
+
+type reflinkProvider struct{}
+
+// Snapshot copies the path next to itself, sharing the data blocks with the
+// original. Fails if the filesystem doesn't support reflinks.
+func (reflinkProvider) Snapshot(ctx context.Context, path string) (Snapshot, error) {
+	target := filepath.Join(filepath.Dir(path), "."+snapshotName())
+
+	_, err := run(ctx, "cp", "--archive", "--reflink=always", path, target)
+	if err != nil {
+		return Snapshot{}, errors.Join(err, os.RemoveAll(target))
+	}
+
+	return Snapshot{
+		Source: path,
+		Path:   target,
+		destroy: func(_ context.Context) error {
+			return os.RemoveAll(target)
+		},
+	}, nil
+}
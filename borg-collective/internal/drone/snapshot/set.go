@@ -0,0 +1,159 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Set is a set of snapshots, which are bind mounted at their original paths
+// below a common root directory. Running borg in the root directory with the
+// relative paths archives the snapshots under the same paths as the originals.
+type Set struct {
+	root      string
+	paths     []string
+	snapshots []Snapshot
+	mounts    []string
+}
+
+// Create snapshots the paths with the provider, paths within other paths are
+// part of the snapshot of the outer path. The root directory is created in
+// tempDir. Everything created so far is destroyed if creating the set fails.
+func Create(ctx context.Context, provider Provider, tempDir string, paths []string) (*Set, error) {
+	root, err := os.MkdirTemp(tempDir, "borgd-snapshots-*")
+	if err != nil {
+		return nil, err
+	}
+
+	set := &Set{root: root}
+	for _, path := range outermostPaths(paths) {
+		if !filepath.IsAbs(path) {
+			return nil, errors.Join(fmt.Errorf("path %s is not an absolute path", path), set.Destroy(context.WithoutCancel(ctx)))
+		}
+
+		snapshot, err := provider.Snapshot(ctx, path)
+		if err != nil {
+			err = fmt.Errorf("failed to snapshot %s: %w", path, err)
+			return nil, errors.Join(err, set.Destroy(context.WithoutCancel(ctx)))
+		}
+
+		set.snapshots = append(set.snapshots, snapshot)
+
+		err = set.mount(ctx, snapshot)
+		if err != nil {
+			err = fmt.Errorf("failed to mount snapshot of %s: %w", path, err)
+			return nil, errors.Join(err, set.Destroy(context.WithoutCancel(ctx)))
+		}
+
+		set.paths = append(set.paths, strings.TrimPrefix(filepath.Clean(path), "/"))
+	}
+
+	return set, nil
+}
+
+// Root is the directory the paths of the set are relative to.
+func (s *Set) Root() string {
+	return s.root
+}
+
+// Paths returns the original paths relative to the root directory.
+func (s *Set) Paths() []string {
+	return s.paths
+}
+
+// Destroy unmounts and destroys all snapshots and removes the root directory,
+// all problems are reported at once.
+func (s *Set) Destroy(ctx context.Context) error {
+	var errs []error
+	for _, mountPoint := range slices.Backward(s.mounts) {
+		if _, err := run(ctx, "umount", mountPoint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.mounts = nil
+
+	for i := range s.snapshots {
+		if err := s.snapshots[i].Destroy(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to destroy snapshot of %s: %w", s.snapshots[i].Source, err))
+		}
+	}
+
+	// only remove the root if nothing is mounted below it anymore
+	if len(errs) == 0 {
+		if err := os.RemoveAll(s.root); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// mount bind mounts the snapshot at its original path below the root.
+func (s *Set) mount(ctx context.Context, snapshot Snapshot) error {
+	info, err := os.Stat(snapshot.Path)
+	if err != nil {
+		return err
+	}
+
+	mountPoint := filepath.Join(s.root, snapshot.Source)
+	if info.IsDir() {
+		err = os.MkdirAll(mountPoint, 0o700)
+	} else if err = os.MkdirAll(filepath.Dir(mountPoint), 0o700); err == nil {
+		err = os.WriteFile(mountPoint, nil, 0o600)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = run(ctx, "mount", "--bind", "-o", "ro", snapshot.Path, mountPoint)
+	if err != nil {
+		return err
+	}
+
+	s.mounts = append(s.mounts, mountPoint)
+
+	return nil
+}
+
+// outermostPaths returns the paths without the ones contained in others.
+func outermostPaths(paths []string) []string {
+	cleaned := make([]string, 0, len(paths))
+	for _, path := range paths {
+		cleaned = append(cleaned, filepath.Clean(path))
+	}
+
+	slices.Sort(cleaned)
+	cleaned = slices.Compact(cleaned)
+
+	result := make([]string, 0, len(cleaned))
+	for _, path := range cleaned {
+		if !slices.ContainsFunc(result, func(outer string) bool {
+			return outer == "/" || strings.HasPrefix(path, outer+"/")
+		}) {
+			result = append(result, path)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package snapshot creates short-lived filesystem snapshots, so that data can
+// be backed up consistently without keeping its writers stopped during the
+// whole backup.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Kind selects the provider used to create snapshots.
+type Kind string
+
+const (
+	// KindBtrfs snapshots the btrfs subvolume containing the path.
+	KindBtrfs Kind = "btrfs"
+	// KindLVM snapshots the thin logical volume the path is on and mounts the
+	// snapshot read-only.
+	KindLVM Kind = "lvm"
+	// KindZFS snapshots the ZFS dataset the path is on.
+	KindZFS Kind = "zfs"
+	// KindReflink copies the path with cp --reflink=always next to it, which
+	// shares the data blocks with the original on filesystems that support it.
+	KindReflink Kind = "reflink"
+)
+
+func ParseKind(s string) (Kind, error) {
+	switch kind := Kind(strings.TrimSpace(s)); kind {
+	case KindBtrfs, KindLVM, KindZFS, KindReflink:
+		return kind, nil
+	}
+
+	return "", fmt.Errorf("unrecognized snapshot provider: %s", s)
+}
+
+func (k *Kind) UnmarshalText(text []byte) error {
+	kind, err := ParseKind(string(text))
+	if err != nil {
+		return err
+	}
+
+	*k = kind
+
+	return nil
+}
+
+// Provider creates snapshots of files and directories.
+type Provider interface {
+	// Snapshot takes a read-only snapshot of the filesystem containing path.
+	Snapshot(ctx context.Context, path string) (Snapshot, error)
+}
+
+// Snapshot is a point in time copy of Source, which can be read at Path until
+// it is destroyed.
+type Snapshot struct {
+	Source  string
+	Path    string
+	destroy func(ctx context.Context) error
+}
+
+// Destroy removes the snapshot, it is safe to call Destroy more than once.
+func (s *Snapshot) Destroy(ctx context.Context) error {
+	if s.destroy == nil {
+		return nil
+	}
+
+	destroy := s.destroy
+	s.destroy = nil
+
+	return destroy(ctx)
+}
+
+func NewProvider(kind Kind) (Provider, error) {
+	switch kind {
+	case KindBtrfs:
+		return btrfsProvider{}, nil
+	case KindLVM:
+		return lvmProvider{}, nil
+	case KindZFS:
+		return zfsProvider{}, nil
+	case KindReflink:
+		return reflinkProvider{}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized snapshot provider: %s", kind)
+}
+
+// run runs the command and returns its output, it's replaced in tests.
+var run = func(ctx context.Context, command ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return "", fmt.Errorf("%s failed: %w", command[0], err)
+		}
+
+		return "", fmt.Errorf("%s failed: %w: %s", command[0], err, message)
+	}
+
+	return string(output), nil
+}
+
+// snapshotName returns a unique name for a snapshot.
+func snapshotName() string {
+	return "borgd-snapshot-" + strings.ToLower(rand.Text()[:10])
+}
+
+type mountInfo struct {
+	source string
+	target string
+	fsType string
+}
+
+// findMount returns the mount the path is on.
+func findMount(ctx context.Context, path string) (mountInfo, error) {
+	output, err := run(ctx, "findmnt", "--noheadings", "--raw", "--output", "SOURCE,TARGET,FSTYPE", "--target", path)
+	if err != nil {
+		return mountInfo{}, err
+	}
+
+	fields := strings.Fields(strings.SplitN(strings.TrimSpace(output), "\n", 2)[0])
+	if len(fields) != 3 {
+		return mountInfo{}, errors.New("failed to find mount of " + path)
+	}
+
+	return mountInfo{source: fields[0], target: unescapeMountPath(fields[1]), fsType: fields[2]}, nil
+}
+
+// unescapeMountPath reverses the octal escapes findmnt uses for whitespace
+// and other special characters in raw output.
+func unescapeMountPath(path string) string {
+	var result strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) && path[i+1] == 'x' {
+			var b byte
+			if _, err := fmt.Sscanf(path[i+2:i+4], "%02x", &b); err == nil {
+				result.WriteByte(b)
+				i += 3
+				continue
+			}
+		}
+
+		result.WriteByte(path[i])
+	}
+
+	return result.String()
+}
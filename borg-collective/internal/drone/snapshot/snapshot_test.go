@@ -0,0 +1,185 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubRun replaces running commands for the test, commands are recorded with
+// their arguments joined by spaces.
+func stubRun(t *testing.T, handler func(command []string) (string, error)) *[]string {
+	commands := make([]string, 0)
+	original := run
+	run = func(_ context.Context, command ...string) (string, error) {
+		commands = append(commands, strings.Join(command, " "))
+		return handler(command)
+	}
+
+	t.Cleanup(func() { run = original })
+
+	return &commands
+}
+
+func TestParseKind(t *testing.T) {
+	kind, err := ParseKind(" zfs ")
+	assert.NoError(t, err)
+	assert.Equal(t, KindZFS, kind)
+
+	_, err = ParseKind("ext4")
+	assert.ErrorContains(t, err, "unrecognized snapshot provider: ext4")
+}
+
+func TestOutermostPaths(t *testing.T) {
+	assert.Equal(
+		t,
+		[]string{"/srv/data", "/srv/database", "/var/lib/app"},
+		outermostPaths([]string{"/srv/data/uploads", "/var/lib/app/", "/srv/data", "/srv/database", "/srv/data"}),
+	)
+
+	assert.Equal(t, []string{"/"}, outermostPaths([]string{"/srv", "/"}))
+}
+
+func TestCreate_Reflink(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data")
+	assert.NoError(t, os.Mkdir(source, 0o700))
+
+	commands := stubRun(t, func(command []string) (string, error) {
+		if command[0] == "cp" {
+			return "", os.Mkdir(command[len(command)-1], 0o700)
+		}
+
+		return "", nil
+	})
+
+	set, err := Create(context.Background(), reflinkProvider{}, t.TempDir(), []string{source, filepath.Join(source, "nested")})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{strings.TrimPrefix(source, "/")}, set.Paths())
+	assert.DirExists(t, filepath.Join(set.Root(), source))
+
+	if assert.Len(t, *commands, 2) {
+		copied := strings.Fields((*commands)[0])[4]
+		assert.Equal(t, "cp --archive --reflink=always "+source+" "+copied, (*commands)[0])
+		assert.Equal(t, "mount --bind -o ro "+copied+" "+filepath.Join(set.Root(), source), (*commands)[1])
+		assert.DirExists(t, copied)
+
+		assert.NoError(t, set.Destroy(context.Background()))
+		assert.NoDirExists(t, copied)
+	}
+
+	assert.Equal(t, "umount "+filepath.Join(set.Root(), source), (*commands)[len(*commands)-1])
+	assert.NoDirExists(t, set.Root())
+}
+
+func TestCreate_DestroysOnFailure(t *testing.T) {
+	destroyed := make([]string, 0)
+	provider := providerFunc(func(_ context.Context, path string) (Snapshot, error) {
+		if path == "/srv/fail" {
+			return Snapshot{}, errors.New("no space left")
+		}
+
+		return Snapshot{
+			Source: path,
+			Path:   os.TempDir(),
+			destroy: func(_ context.Context) error {
+				destroyed = append(destroyed, path)
+				return nil
+			},
+		}, nil
+	})
+
+	stubRun(t, func(_ []string) (string, error) { return "", nil })
+
+	tempDir := t.TempDir()
+	_, err := Create(context.Background(), provider, tempDir, []string{"/srv/data", "/srv/fail"})
+	assert.ErrorContains(t, err, "failed to snapshot /srv/fail: no space left")
+	assert.Equal(t, []string{"/srv/data"}, destroyed)
+
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestZFSProvider(t *testing.T) {
+	commands := stubRun(t, func(command []string) (string, error) {
+		if command[0] == "findmnt" {
+			return "tank/srv /srv zfs\n", nil
+		}
+
+		return "", nil
+	})
+
+	snapshot, err := zfsProvider{}.Snapshot(context.Background(), "/srv/app/data")
+	assert.NoError(t, err)
+
+	name := strings.Split(filepath.ToSlash(snapshot.Path), "/")[4]
+	assert.Equal(t, "/srv/.zfs/snapshot/"+name+"/app/data", snapshot.Path)
+
+	assert.NoError(t, snapshot.Destroy(context.Background()))
+	assert.NoError(t, snapshot.Destroy(context.Background()))
+
+	assert.Equal(t, []string{
+		"findmnt --noheadings --raw --output SOURCE,TARGET,FSTYPE --target /srv/app/data",
+		"zfs snapshot tank/srv@" + name,
+		"zfs destroy tank/srv@" + name,
+	}, *commands)
+}
+
+func TestLVMProvider(t *testing.T) {
+	commands := stubRun(t, func(command []string) (string, error) {
+		switch command[0] {
+		case "findmnt":
+			return "/dev/mapper/vg0-srv /srv\\x20data xfs\n", nil
+		case "lvs":
+			return "  vg0 srv\n", nil
+		}
+
+		return "", nil
+	})
+
+	snapshot, err := lvmProvider{}.Snapshot(context.Background(), "/srv data/app")
+	assert.NoError(t, err)
+	assert.Equal(t, "app", filepath.Base(snapshot.Path))
+
+	mountPoint := filepath.Dir(snapshot.Path)
+	assert.DirExists(t, mountPoint)
+
+	assert.NoError(t, snapshot.Destroy(context.Background()))
+	assert.NoDirExists(t, mountPoint)
+
+	if assert.Len(t, *commands, 6) {
+		volume := strings.Fields((*commands)[2])[5]
+		assert.Equal(t, "lvs --noheadings --options vg_name,lv_name /dev/mapper/vg0-srv", (*commands)[1])
+		assert.Equal(t, "lvcreate --snapshot --setactivationskip n --name "+volume+" vg0/srv", (*commands)[2])
+		assert.Equal(t, "mount -o ro,nouuid /dev/vg0/"+volume+" "+mountPoint, (*commands)[3])
+		assert.Equal(t, "umount "+mountPoint, (*commands)[4])
+		assert.Equal(t, "lvremove --force vg0/"+volume, (*commands)[5])
+	}
+}
+
+type providerFunc func(ctx context.Context, path string) (Snapshot, error)
+
+func (f providerFunc) Snapshot(ctx context.Context, path string) (Snapshot, error) {
+	return f(ctx, path)
+}
@@ -0,0 +1,281 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package window restricts when jobs may run to allowed windows and excludes
+// blackout windows, e.g. "Mon-Fri 08:00-18:00" or "22:00-06:00".
+package window
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchHorizon limits how far ahead Next looks for an allowed time.
+const searchHorizon = 8 * 24 * time.Hour
+
+type Policy string
+
+const (
+	// PolicyDefer runs the job at the start of the next allowed window.
+	PolicyDefer Policy = "defer"
+	// PolicySkip doesn't run the job until its next scheduled run.
+	PolicySkip Policy = "skip"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(strings.ToLower(strings.TrimSpace(s))) {
+	case "", PolicyDefer:
+		return PolicyDefer, nil
+	case PolicySkip:
+		return PolicySkip, nil
+	}
+
+	return "", fmt.Errorf("unrecognized window policy: %s", s)
+}
+
+// Window is a daily time range, optionally limited to certain days of the
+// week. If the end is not after the start, the window extends past midnight.
+type Window struct {
+	spec  string
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+func (w Window) String() string {
+	return w.spec
+}
+
+func (w Window) MarshalText() ([]byte, error) {
+	return []byte(w.spec), nil
+}
+
+// Parse parses a window spec like "Mon-Fri 08:00-18:00", "Sat,Sun 00:00-24:00"
+// or "22:00-06:00".
+func Parse(spec string) (Window, error) {
+	spec = strings.TrimSpace(spec)
+	fields := strings.Fields(spec)
+
+	w := Window{spec: spec}
+
+	var timeRange string
+	switch len(fields) {
+	case 1:
+		timeRange = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+
+		w.days = days
+		timeRange = fields[1]
+	default:
+		return Window{}, fmt.Errorf("invalid window %q: expected [DAYS] HH:MM-HH:MM", spec)
+	}
+
+	startRaw, endRaw, found := strings.Cut(timeRange, "-")
+	if !found {
+		return Window{}, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", spec)
+	}
+
+	var err error
+	if w.start, err = parseTimeOfDay(startRaw); err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", spec, err)
+	}
+
+	if w.end, err = parseTimeOfDay(endRaw); err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", spec, err)
+	}
+
+	return w, nil
+}
+
+// Contains reports whether t is within the window, in the location of t.
+func (w Window) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if w.start < w.end {
+		return w.days[t.Weekday()] && offset >= w.start && offset < w.end
+	}
+
+	// the window extends past midnight, so it belongs to the day it started on
+	yesterday := (t.Weekday() + 6) % 7
+
+	return (w.days[t.Weekday()] && offset >= w.start) || (w.days[yesterday] && offset < w.end)
+}
+
+// Windows combines allowed and blackout windows. Without allowed windows any
+// time outside a blackout is allowed.
+type Windows struct {
+	Allowed  []Window
+	Blackout []Window
+	Policy   Policy
+}
+
+func (ws Windows) IsEmpty() bool {
+	return len(ws.Allowed) == 0 && len(ws.Blackout) == 0
+}
+
+func (ws Windows) Allows(t time.Time) bool {
+	for _, w := range ws.Blackout {
+		if w.Contains(t) {
+			return false
+		}
+	}
+
+	if len(ws.Allowed) == 0 {
+		return true
+	}
+
+	for _, w := range ws.Allowed {
+		if w.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Next returns the next allowed time after t at minute resolution, or the zero
+// time if there is none within the next week.
+func (ws Windows) Next(t time.Time) time.Time {
+	candidate := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(searchHorizon)
+
+	for candidate.Before(limit) {
+		if ws.Allows(candidate) {
+			return candidate
+		}
+
+		candidate = candidate.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
+
+// ParseWindows parses allowed and blackout window specs.
+func ParseWindows(allowed, blackout []string, policy string) (Windows, error) {
+	var err error
+	ws := Windows{}
+
+	if ws.Policy, err = ParsePolicy(policy); err != nil {
+		return Windows{}, err
+	}
+
+	for _, spec := range allowed {
+		w, err := Parse(spec)
+		if err != nil {
+			return Windows{}, err
+		}
+
+		ws.Allowed = append(ws.Allowed, w)
+	}
+
+	for _, spec := range blackout {
+		w, err := Parse(spec)
+		if err != nil {
+			return Windows{}, err
+		}
+
+		ws.Blackout = append(ws.Blackout, w)
+	}
+
+	return ws, nil
+}
+
+// ParseLabel parses window specs separated by semicolons, specs prefixed with
+// ! are blackout windows, e.g. "!Mon-Fri 08:00-18:00; 22:00-06:00".
+func ParseLabel(value, policy string) (Windows, error) {
+	var allowed, blackout []string
+	for _, spec := range strings.Split(value, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		if blackoutSpec, found := strings.CutPrefix(spec, "!"); found {
+			blackout = append(blackout, blackoutSpec)
+		} else {
+			allowed = append(allowed, spec)
+		}
+	}
+
+	return ParseWindows(allowed, blackout, policy)
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(s, ",") {
+		fromRaw, toRaw, isRange := strings.Cut(part, "-")
+
+		from, found := dayNames[strings.ToLower(fromRaw)]
+		if !found {
+			return days, fmt.Errorf("unknown day: %s", fromRaw)
+		}
+
+		to := from
+		if isRange {
+			to, found = dayNames[strings.ToLower(toRaw)]
+			if !found {
+				return days, fmt.Errorf("unknown day: %s", toRaw)
+			}
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hoursRaw, minutesRaw, found := strings.Cut(s, ":")
+	if !found {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+
+	hours, err := strconv.Atoi(hoursRaw)
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+
+	minutes, err := strconv.Atoi(minutesRaw)
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func at(day, hour, minute int) time.Time {
+	// June 2nd 2025 is a Monday
+	return time.Date(2025, 6, 1+day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestWindowContains(t *testing.T) {
+	business, err := Parse("Mon-Fri 08:00-18:00")
+	assert.NoError(t, err)
+
+	assert.True(t, business.Contains(at(1, 8, 0)))
+	assert.False(t, business.Contains(at(1, 18, 0)))
+	assert.False(t, business.Contains(at(6, 12, 0)))
+
+	night, err := Parse("Fri 22:00-06:00")
+	assert.NoError(t, err)
+
+	assert.True(t, night.Contains(at(5, 23, 0)))
+	assert.True(t, night.Contains(at(6, 5, 59)))
+	assert.False(t, night.Contains(at(5, 5, 0)))
+
+	weekend, err := Parse("Sat,Sun 00:00-24:00")
+	assert.NoError(t, err)
+
+	assert.True(t, weekend.Contains(at(7, 23, 59)))
+	assert.False(t, weekend.Contains(at(1, 0, 0)))
+
+	_, err = Parse("Mon-Fri 8-18")
+	assert.Error(t, err)
+
+	_, err = Parse("Someday 08:00-18:00")
+	assert.Error(t, err)
+}
+
+func TestWindowsNext(t *testing.T) {
+	ws, err := ParseLabel("!Mon-Fri 08:00-18:00", "")
+	assert.NoError(t, err)
+	assert.Equal(t, PolicyDefer, ws.Policy)
+
+	assert.False(t, ws.Allows(at(1, 9, 0)))
+	assert.Equal(t, at(1, 18, 0), ws.Next(at(1, 9, 0)))
+
+	ws, err = ParseLabel("22:00-06:00; !Sat,Sun 00:00-24:00", "skip")
+	assert.NoError(t, err)
+	assert.Equal(t, PolicySkip, ws.Policy)
+
+	assert.True(t, ws.Allows(at(1, 23, 0)))
+	assert.False(t, ws.Allows(at(1, 12, 0)))
+	assert.Equal(t, at(1, 22, 0), ws.Next(at(1, 12, 0)))
+	assert.Equal(t, at(8, 0, 0), ws.Next(at(5, 23, 59).Add(2*time.Hour)))
+}
@@ -16,7 +16,8 @@
 package worker
 
 import (
-	"github.com/robfig/cron/v3"
+	"context"
+
 	"github.com/vemilyus/borg-collective/internal/drone/borg"
 )
 
@@ -24,10 +25,10 @@ type compactionJob struct {
 	borgClient *borg.Client
 }
 
-func newRepoCompactionJob(borgClient *borg.Client) cron.Job {
+func newRepoCompactionJob(borgClient *borg.Client) workerJob {
 	return &compactionJob{borgClient}
 }
 
-func (c *compactionJob) Run() {
-	_ = c.borgClient.Compact()
+func (c *compactionJob) Run(ctx context.Context) error {
+	return c.borgClient.Compact(ctx)
 }
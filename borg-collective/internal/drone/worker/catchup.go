@@ -0,0 +1,165 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const resumeCheckInterval = time.Minute
+
+type overdueJob struct {
+	name   string
+	missed time.Time
+}
+
+// catchUp runs every job with a catch-up policy whose last successful run is
+// older than its most recent scheduled occurrence. Every overdue job is run
+// exactly once, no matter how many occurrences were missed, and the runs are
+// spread evenly over the configured catch-up delay.
+func (w *Worker) catchUp() {
+	overdue := w.findOverdueJobs(time.Now())
+	if len(overdue) == 0 {
+		return
+	}
+
+	w.delayMutex.Lock()
+	step := w.catchUpDelay / time.Duration(len(overdue))
+	w.delayMutex.Unlock()
+
+	for i, oj := range overdue {
+		delay := step * time.Duration(i)
+
+		log.Info().
+			Ctx(w.ctx).
+			Str("job", oj.name).
+			Time("missed", oj.missed).
+			Dur("delay", delay).
+			Msg("catching up on missed job run")
+
+		w.enqueueAfter(oj.name, delay)
+	}
+}
+
+// enqueueAfter enqueues the job with the given name after the delay, unless
+// it was unscheduled in the meantime. Replaces a delayed run of the same job
+// that is still pending.
+func (w *Worker) enqueueAfter(name string, delay time.Duration) {
+	w.delayMutex.Lock()
+	defer w.delayMutex.Unlock()
+
+	if timer, found := w.delayTimers[name]; found {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		w.delayMutex.Lock()
+		if w.delayTimers[name] == timer {
+			delete(w.delayTimers, name)
+		}
+		w.delayMutex.Unlock()
+
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		w.schedulerMutex.Lock()
+		sj := w.jobNamed(name)
+		w.schedulerMutex.Unlock()
+
+		if sj != nil {
+			w.enqueue(sj)
+		}
+	})
+
+	w.delayTimers[name] = timer
+}
+
+func (w *Worker) stopDelayedRuns() {
+	w.delayMutex.Lock()
+	defer w.delayMutex.Unlock()
+
+	for name, timer := range w.delayTimers {
+		timer.Stop()
+		delete(w.delayTimers, name)
+	}
+}
+
+func (w *Worker) findOverdueJobs(now time.Time) []overdueJob {
+	w.schedulerMutex.Lock()
+	defer w.schedulerMutex.Unlock()
+
+	overdue := make([]overdueJob, 0)
+	for _, sj := range w.jobs {
+		if !sj.catchUp || sj.schedule == nil {
+			continue
+		}
+
+		last, found := w.state.lastSuccess(sj.name)
+		if !found {
+			log.Debug().
+				Ctx(w.ctx).
+				Str("job", sj.name).
+				Msg("no previous successful run recorded, nothing to catch up")
+
+			continue
+		}
+
+		next := sj.schedule.Next(last)
+		if next.Before(now) {
+			overdue = append(overdue, overdueJob{name: sj.name, missed: next})
+		}
+	}
+
+	slices.SortFunc(overdue, func(a, b overdueJob) int {
+		return cmp.Compare(a.name, b.name)
+	})
+
+	return overdue
+}
+
+// watchResume detects that the host was suspended by comparing the wall clock
+// to the expected time between ticks, and catches up on missed runs after
+// resuming.
+func (w *Worker) watchResume() {
+	ticker := time.NewTicker(resumeCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now().Round(0)
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now().Round(0)
+			if now.Sub(last) > 2*resumeCheckInterval {
+				log.Info().
+					Ctx(w.ctx).
+					Dur("gap", now.Sub(last)).
+					Msg("detected resume from suspend")
+
+				w.catchUp()
+			}
+
+			last = now
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
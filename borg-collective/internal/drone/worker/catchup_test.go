@@ -0,0 +1,83 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerFindOverdueJobs(t *testing.T) {
+	ctx := context.Background()
+	schedule, err := cron.ParseStandard("0 3 * * *")
+	assert.NoError(t, err)
+
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.Local)
+
+	worker := Worker{
+		ctx:   ctx,
+		state: &jobState{},
+		jobs: jobsByKey(
+			&scheduledJob{name: "missed-many", schedule: schedule, catchUp: true},
+			&scheduledJob{name: "missed-one", schedule: schedule, catchUp: true},
+			&scheduledJob{name: "up-to-date", schedule: schedule, catchUp: true},
+			&scheduledJob{name: "never-run", schedule: schedule, catchUp: true},
+			&scheduledJob{name: "no-catch-up", schedule: schedule},
+			&scheduledJob{name: "missed-no-policy", schedule: schedule},
+		),
+	}
+
+	worker.state.recordSuccess("missed-many", now.Add(-5*24*time.Hour))
+	worker.state.recordSuccess("missed-one", time.Date(2025, 6, 9, 3, 0, 0, 0, time.Local))
+	worker.state.recordSuccess("up-to-date", time.Date(2025, 6, 10, 3, 5, 0, 0, time.Local))
+	worker.state.recordSuccess("missed-no-policy", now.Add(-5*24*time.Hour))
+
+	overdue := worker.findOverdueJobs(now)
+
+	assert.Equal(
+		t,
+		[]overdueJob{
+			{name: "missed-many", missed: time.Date(2025, 6, 6, 3, 0, 0, 0, time.Local)},
+			{name: "missed-one", missed: time.Date(2025, 6, 10, 3, 0, 0, 0, time.Local)},
+		},
+		overdue,
+	)
+}
+
+func TestJobStatePersistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	state := &jobState{}
+	state.load(ctx, dir)
+
+	_, found := state.lastSuccess("some-backup")
+	assert.False(t, found)
+
+	at := time.Date(2025, 6, 10, 3, 0, 0, 0, time.UTC)
+	state.recordSuccess("some-backup", at)
+
+	reloaded := &jobState{}
+	reloaded.load(ctx, dir)
+
+	last, found := reloaded.lastSuccess("some-backup")
+	assert.True(t, found)
+	assert.True(t, at.Equal(last))
+}
@@ -19,16 +19,44 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"slices"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/vemilyus/borg-collective/internal/drone/borg"
 	"github.com/vemilyus/borg-collective/internal/drone/borg/api"
 	"github.com/vemilyus/borg-collective/internal/drone/config"
 	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
 	"github.com/vemilyus/borg-collective/internal/utils"
 )
 
+var (
+	errMaxDowntimeExceeded = errors.New("maximum container downtime exceeded")
+	errOutsideWindow       = errors.New("outside of allowed window")
+)
+
+// workerJob is a unit of work executed by the job queue.
+type workerJob interface {
+	Run(ctx context.Context) error
+}
+
+func jobOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errMaxDowntimeExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, errOutsideWindow), errors.Is(err, errRequirementFailed):
+		return "skipped"
+	default:
+		return "failed"
+	}
+}
+
 type containerPlan []model.ContainerBackup
 
 func (p containerPlan) Len() int {
@@ -51,7 +79,7 @@ func backupPaths(ctx context.Context, borgClient *borg.Client, backupName string
 		return errors.New("no paths specified")
 	}
 
-	result, err := borgClient.CreateWithPaths(utils.ArchiveName(backupName), paths)
+	result, err := borgClient.CreateWithPaths(ctx, utils.ArchiveName(backupName), paths)
 	if err != nil {
 		return err
 	}
@@ -61,7 +89,90 @@ func backupPaths(ctx context.Context, borgClient *borg.Client, backupName string
 	return nil
 }
 
+// backupSnapshots snapshots the paths and backs up the snapshots, stored in
+// the archive under the original paths. release is called as soon as the
+// snapshots are created or failed to be, it may be nil.
+func backupSnapshots(
+	ctx context.Context,
+	borgClient *borg.Client,
+	tempDir string,
+	backupName string,
+	kind snapshot.Kind,
+	paths []string,
+	release func(),
+) error {
+	if len(paths) == 0 {
+		return errors.New("no paths specified")
+	}
+
+	set, destroy, err := createSnapshots(ctx, tempDir, backupName, kind, paths)
+	if release != nil {
+		release()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer destroy()
+
+	result, err := borgClient.CreateWithPathsIn(ctx, utils.ArchiveName(backupName), set.Root(), set.Paths())
+	if err != nil {
+		return err
+	}
+
+	logBackupComplete(ctx, backupName, result)
+
+	return nil
+}
+
+// createSnapshots snapshots the paths, destroy removes the snapshots again
+// independent of whether ctx is done.
+func createSnapshots(
+	ctx context.Context,
+	tempDir string,
+	backupName string,
+	kind snapshot.Kind,
+	paths []string,
+) (*snapshot.Set, func(), error) {
+	provider, err := snapshot.NewProvider(kind)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	started := time.Now()
+	set, err := snapshot.Create(ctx, provider, tempDir, paths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create snapshots: %w", err)
+	}
+
+	log.Info().
+		Ctx(ctx).
+		Str("backup", backupName).
+		Str("provider", string(kind)).
+		Dur("duration", time.Since(started)).
+		Msg("created snapshots")
+
+	destroy := func() {
+		destroyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+		defer cancel()
+
+		if err := set.Destroy(destroyCtx); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("backup", backupName).
+				Str("root", set.Root()).
+				Msg("failed to destroy snapshots")
+		}
+	}
+
+	return set, destroy, nil
+}
+
 func logBackupComplete(ctx context.Context, backupName string, result api.CreateOutput) {
+	recordArchive(ctx, result.Archive)
+
 	resultLog := log.Info().
 		Ctx(ctx).
 		Str("backup", backupName)
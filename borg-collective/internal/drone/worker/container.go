@@ -17,31 +17,44 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
 	"github.com/vemilyus/borg-collective/internal/drone/borg"
+	"github.com/vemilyus/borg-collective/internal/drone/borg/api"
 	"github.com/vemilyus/borg-collective/internal/drone/container"
 	"github.com/vemilyus/borg-collective/internal/drone/container/model"
 	"github.com/vemilyus/borg-collective/internal/utils"
 	"golang.org/x/sync/errgroup"
 )
 
+// restoreTimeout limits how long restoring containers after a backup may
+// take, independent of whether the backup itself timed out or was canceled.
+const restoreTimeout = 5 * time.Minute
+
 type containerProjectBackupJob struct {
-	ctx        context.Context
 	engine     container.Engine
+	tracker    *stateTrackingEngine
 	borgClient *borg.Client
+	tempDir    string
 	project    model.ContainerBackupProject
 	plan       containerPlan
+	// tasks is shared by the jobs of the project, it's nil for projects
+	// without Swarm tasks.
+	tasks *swarmTasks
 }
 
-func (w *Worker) newContainerProjectBackupJob(project model.ContainerBackupProject) (cron.Job, error) {
+func (w *Worker) newContainerProjectBackupJob(project model.ContainerBackupProject) (workerJob, error) {
 	if len(project.Containers) == 0 {
 		return nil, fmt.Errorf("nothing to do")
 	}
@@ -55,46 +68,137 @@ func (w *Worker) newContainerProjectBackupJob(project model.ContainerBackupProje
 	sort.Sort(plan)
 
 	for _, ctnr := range project.Containers {
-		if ctnr.Exec != nil && !ctnr.Exec.Stdout {
-			for _, cPath := range ctnr.Exec.Paths {
+		for _, exec := range ctnr.Execs {
+			if exec.Stdout || exec.PathSource != model.PathSourceHost {
+				continue
+			}
+
+			for _, cPath := range exec.Paths {
 				_, found := findSourceForInContainerPath(&ctnr, cPath)
 				if !found {
 					return nil, fmt.Errorf("no source for in-container path %s", cPath)
 				}
 			}
 		}
+	}
 
-		for _, dep := range ctnr.Dependencies {
-			_, found := project.Containers[dep]
-			if !found {
-				return nil, fmt.Errorf("dependency %s of %s not found", dep, ctnr.ServiceName)
-			}
-		}
+	if problems := project.Validate(); len(problems) > 0 {
+		return nil, errors.Join(problems...)
 	}
 
 	job := &containerProjectBackupJob{
-		ctx:        w.ctx,
 		borgClient: w.borgClient,
+		tempDir:    w.tempDir,
 		project:    project,
 		plan:       plan,
 	}
 
-	switch project.Engine {
-	case model.ContainerEngineDocker:
-		job.engine = w.dockerClient
-	default:
+	for _, ctnr := range project.Containers {
+		if ctnr.SwarmTask {
+			job.tasks = newSwarmTasks(project)
+			break
+		}
+	}
+
+	engine, found := w.containers[project.Engine]
+	if !found {
 		return nil, fmt.Errorf("unknown container engine %s", project.Engine)
 	}
 
+	job.engine = engine
+
 	return job, nil
 }
 
-func (d *containerProjectBackupJob) Run() {
+// withServices returns a copy of the job, which only backs up the given
+// services of the project.
+func (d *containerProjectBackupJob) withServices(services []string) (*containerProjectBackupJob, error) {
+	plan := make(containerPlan, 0, len(services))
+	for _, ctnr := range d.plan {
+		if slices.Contains(services, ctnr.ServiceName) {
+			plan = append(plan, ctnr)
+		}
+	}
+
+	for _, service := range services {
+		if _, found := d.project.Containers[service]; !found {
+			return nil, fmt.Errorf("unknown service %s", service)
+		}
+
+		if !slices.ContainsFunc(plan, func(ctnr model.ContainerBackup) bool { return ctnr.ServiceName == service }) {
+			return nil, fmt.Errorf("service %s has nothing to back up", service)
+		}
+	}
+
+	job := *d
+	job.plan = plan
+
+	return &job, nil
+}
+
+// withoutScheduledServices returns a copy of the job, which skips the services
+// backed up on their own schedule.
+func (d *containerProjectBackupJob) withoutScheduledServices() *containerProjectBackupJob {
+	job := *d
+	job.plan = slices.DeleteFunc(slices.Clone(d.plan), func(ctnr model.ContainerBackup) bool { return ctnr.Schedule != nil })
+
+	return &job
+}
+
+func (d *containerProjectBackupJob) Run(ctx context.Context) error {
+	// every run gets its own copy of the job, which records the containers it
+	// starts or stops, so they can be put back into their previous state
+	tracker := newStateTrackingEngine(d.engine)
+	run := *d
+	run.project, run.plan = d.tasks.current(d.project, d.plan)
+	run.engine = tracker
+	run.tracker = tracker
+
+	return run.run(ctx, tracker)
+}
+
+func (d *containerProjectBackupJob) run(ctx context.Context, tracker *stateTrackingEngine) error {
+	// containers are restored even if the backup timed out or was canceled
+	restoreCtx, cancelRestore := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+	defer cancelRestore()
+
+	ctx, result := newRunResult(ctx, d.project.ProjectName, d.borgClient.RepositoryLocation(), d.tempDir)
+
+	// post and finally hooks still run when the backup timed out
+	hookCtx := ctx
+	if d.project.Timeout != nil && *d.project.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *d.project.Timeout)
+		defer cancel()
+	}
+
+	err := d.runHook(ctx, result, d.project.Hooks, d.project.Hooks.Pre, hookStatusRunning, nil)
+	if err != nil {
+		err = fmt.Errorf("pre hook failed: %w", err)
+	} else {
+		err = d.backupServices(ctx, hookCtx, result)
+	}
+
+	d.restoreContainers(ctx, restoreCtx, tracker)
+
+	if err == nil {
+		_ = d.runHook(hookCtx, result, d.project.Hooks, d.project.Hooks.Post, jobOutcome(nil), nil)
+	}
+
+	_ = d.runHook(hookCtx, result, d.project.Hooks, d.project.Hooks.Finally, jobOutcome(err), err)
+
+	return err
+}
+
+// backupServices backs up every container of the plan, running the hooks of
+// each container around its backup.
+func (d *containerProjectBackupJob) backupServices(ctx, hookCtx context.Context, result *runResult) error {
+	var errs []error
 	for _, backupCtnr := range d.plan {
 		if !backupCtnr.NeedsBackup() {
 			if log.Debug().Enabled() {
 				log.Debug().
-					Ctx(d.ctx).
+					Ctx(ctx).
 					Fields(d.logFields(backupCtnr)).
 					Msg("skipping container, backup not needed")
 			}
@@ -103,35 +207,132 @@ func (d *containerProjectBackupJob) Run() {
 
 		backupName := fmt.Sprintf("%s-%s", d.project.ProjectName, backupCtnr.ServiceName)
 
-		switch backupCtnr.Mode {
-		case model.BackupModeDefault:
-			d.runOnlineBackup(backupCtnr, backupName)
-		case model.BackupModeOffline:
-			d.runOfflineBackup(backupCtnr, backupName)
-		case model.BackupModeDependentOffline:
-			d.runDependentOfflineBackup(backupCtnr, backupName)
-		default:
-			log.Error().
-				Ctx(d.ctx).
+		serviceCtx, serviceResult := result.child(ctx, backupName)
+
+		err := d.runHook(serviceCtx, serviceResult, backupCtnr.Hooks, backupCtnr.Hooks.Pre, hookStatusRunning, nil)
+		if err != nil {
+			err = fmt.Errorf("pre hook failed: %w", err)
+		} else {
+			err = d.runBackup(serviceCtx, backupCtnr, backupName)
+		}
+
+		// pruning is part of the outcome the post and finally hooks are told
+		if err == nil && d.project.Retention != nil {
+			err = d.prune(serviceCtx, backupCtnr, backupName)
+		}
+
+		if err == nil {
+			_ = d.runHook(hookCtx, serviceResult, backupCtnr.Hooks, backupCtnr.Hooks.Post, jobOutcome(nil), nil)
+		}
+
+		_ = d.runHook(hookCtx, serviceResult, backupCtnr.Hooks, backupCtnr.Hooks.Finally, jobOutcome(err), err)
+
+		if err != nil {
+			log.Warn().
+				Ctx(ctx).
+				Err(err).
 				Fields(d.logFields(backupCtnr)).
-				Str("mode", backupCtnr.Mode.String()).
-				Msg("unknown backup mode")
+				Msg("backup failed")
+
+			errs = append(errs, fmt.Errorf("%s: %w", backupCtnr.ServiceName, err))
 		}
 	}
 
-	wg := new(sync.WaitGroup)
-	wg.Add(len(d.project.Containers))
+	return errors.Join(errs...)
+}
+
+func (d *containerProjectBackupJob) runBackup(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
+	switch backupCtnr.Mode {
+	case model.BackupModeDefault:
+		return d.runOnlineBackup(ctx, backupCtnr, backupName)
+	case model.BackupModeOffline:
+		return d.runOfflineBackup(ctx, backupCtnr, backupName)
+	case model.BackupModeDependentOffline:
+		return d.runDependentOfflineBackup(ctx, backupCtnr, backupName)
+	case model.BackupModePaused:
+		return d.runPausedBackup(ctx, backupCtnr, backupName)
+	case model.BackupModeDependentPaused:
+		return d.runDependentPausedBackup(ctx, backupCtnr, backupName)
+	default:
+		return fmt.Errorf("unknown backup mode: %s", backupCtnr.Mode.String())
+	}
+}
+
+// runHook runs the hook command on the host, or in the container the hooks
+// are configured to run in. The result file is only available on the host.
+func (d *containerProjectBackupJob) runHook(
+	ctx context.Context,
+	result *runResult,
+	hooks model.Hooks,
+	command []string,
+	status string,
+	runErr error,
+) error {
+	if len(command) == 0 {
+		return nil
+	}
+
+	if hooks.Container == "" {
+		return result.runHostHook(ctx, command, status, runErr)
+	}
+
+	ctnr, found := d.project.Containers[hooks.Container]
+	if !found {
+		return fmt.Errorf("hooks container %s not found", hooks.Container)
+	}
+
+	err := d.engine.Exec(ctx, ctnr.ID, command, result.report(status, runErr).env())
+	if err != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(err).
+			Fields(d.logFields(ctnr)).
+			Strs("command", command).
+			Msg("hook failed")
+	}
+
+	return err
+}
+
+// restoreContainers puts every container the backup started or stopped back
+// into the state it was in before the backup.
+func (d *containerProjectBackupJob) restoreContainers(ctx, restoreCtx context.Context, tracker *stateTrackingEngine) {
+	containers := make(map[string]model.ContainerBackup, len(d.project.Containers))
 	for _, ctnr := range d.project.Containers {
+		containers[ctnr.ID] = ctnr
+	}
+
+	wg := new(sync.WaitGroup)
+	for id, wasRunning := range tracker.initialStates() {
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			err := d.engine.EnsureContainerRunning(d.ctx, ctnr.ID)
+			ctnr, found := containers[id]
+			if !found {
+				ctnr.ID = id
+			}
+
+			var err error
+			if wasRunning {
+				err = tracker.Engine.EnsureContainerRunning(restoreCtx, id)
+			} else {
+				err = tracker.Engine.EnsureContainerStopped(restoreCtx, id)
+			}
+
 			if err != nil {
 				log.Warn().
-					Ctx(d.ctx).
+					Ctx(ctx).
 					Err(err).
 					Fields(d.logFields(ctnr)).
-					Msg("failed to ensure container running after backup")
+					Bool("running", wasRunning).
+					Msg("failed to restore container state after backup")
+			} else {
+				log.Info().
+					Ctx(ctx).
+					Fields(d.logFields(ctnr)).
+					Bool("running", wasRunning).
+					Msg("restored container state after backup")
 			}
 		}()
 	}
@@ -139,257 +340,761 @@ func (d *containerProjectBackupJob) Run() {
 	wg.Wait()
 }
 
-func (d *containerProjectBackupJob) runOnlineBackup(backupCtnr model.ContainerBackup, backupName string) {
+func (d *containerProjectBackupJob) runOnlineBackup(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
 	log.Info().
-		Ctx(d.ctx).
+		Ctx(ctx).
 		Fields(d.logFields(backupCtnr)).
 		Msg("starting online backup")
 
-	err := d.engine.EnsureContainerRunning(d.ctx, backupCtnr.ID)
+	err := d.engine.EnsureContainerRunning(ctx, backupCtnr.ID)
 	if err != nil {
-		log.Warn().
-			Ctx(d.ctx).
-			Err(err).
-			Fields(d.logFields(backupCtnr)).
-			Msg("failed to ensure container running for online backup")
+		return fmt.Errorf("failed to ensure container running for online backup: %w", err)
+	}
 
-		return
+	err = d.ensureDependenciesRunning(ctx, backupCtnr)
+	if err != nil {
+		return err
 	}
 
-	dependencies := d.findDependencies(backupCtnr)
-	if len(dependencies) > 0 {
-		eg, egCtx := errgroup.WithContext(d.ctx)
-		eg.SetLimit(len(dependencies))
-		for _, dep := range dependencies {
+	return d.backupItems(ctx, backupCtnr, backupName, nil)
+}
+
+func (d *containerProjectBackupJob) runDependentOfflineBackup(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
+	log.Info().
+		Ctx(ctx).
+		Fields(d.logFields(backupCtnr)).
+		Msg("starting online backup (dependents offline)")
+
+	err := d.engine.EnsureContainerRunning(ctx, backupCtnr.ID)
+	if err != nil {
+		return fmt.Errorf("failed to ensure container running for online backup (dependents offline): %w", err)
+	}
+
+	err = d.ensureDependenciesRunning(ctx, backupCtnr)
+	if err != nil {
+		return err
+	}
+
+	dependents := d.findDependents(backupCtnr)
+	if len(dependents) > 0 {
+		eg, egCtx := errgroup.WithContext(ctx)
+		eg.SetLimit(len(dependents))
+		for _, dependent := range dependents {
 			eg.Go(func() error {
-				return d.engine.EnsureContainerRunning(egCtx, dep.ID)
+				return d.engine.EnsureContainerStopped(egCtx, dependent.ID)
 			})
 		}
 
 		err = eg.Wait()
 		if err != nil {
-			log.Warn().
-				Ctx(d.ctx).
-				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("failed to ensure dependencies are running")
+			return fmt.Errorf("failed to ensure dependent containers stopped: %w", err)
+		}
+	}
 
-			return
+	return d.withDowntimeBudget(ctx, dependents, func(ctx context.Context, release func()) error {
+		return d.backupItems(ctx, backupCtnr, backupName, release)
+	})
+}
+
+func (d *containerProjectBackupJob) runOfflineBackup(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
+	log.Info().
+		Ctx(ctx).
+		Fields(d.logFields(backupCtnr)).
+		Msg("starting offline backup")
+
+	err := d.engine.EnsureContainerStopped(ctx, backupCtnr.ID)
+	if err != nil {
+		return fmt.Errorf("failed to ensure container stopped for offline backup: %w", err)
+	}
+
+	return d.withDowntimeBudget(ctx, []model.ContainerBackup{backupCtnr}, func(ctx context.Context, release func()) error {
+		return d.backupItems(ctx, backupCtnr, backupName, release)
+	})
+}
+
+// withDowntimeBudget runs the backup with the shortest maximum downtime of the
+// stopped containers as its deadline. If the deadline is exceeded, the stopped
+// containers are started again right away. The backup calls release once the
+// containers don't need to be stopped anymore, which ends the deadline and
+// starts the containers that were running before the backup. Otherwise they
+// are started once the backup finished, not only after the other containers
+// of the project are backed up.
+func (d *containerProjectBackupJob) withDowntimeBudget(
+	ctx context.Context,
+	stopped []model.ContainerBackup,
+	backup func(ctx context.Context, release func()) error,
+) error {
+	budgetCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var timer *time.Timer
+	budget := downtimeBudget(stopped)
+	if budget > 0 {
+		timer = time.AfterFunc(budget, func() { cancel(errMaxDowntimeExceeded) })
+		defer timer.Stop()
+	}
+
+	release := sync.OnceFunc(func() {
+		if timer != nil {
+			timer.Stop()
 		}
+
+		d.restart(ctx, stopped)
+	})
+
+	err := backup(budgetCtx, release)
+	if err == nil || !errors.Is(context.Cause(budgetCtx), errMaxDowntimeExceeded) {
+		release()
+		return err
 	}
 
-	if backupCtnr.Exec != nil {
-		d.runExecBackup(backupCtnr, backupName)
-	} else {
-		d.runVolumeBackup(backupCtnr, backupName)
+	restoreCtx, cancelRestore := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+	defer cancelRestore()
+
+	for _, ctnr := range stopped {
+		log.Warn().
+			Ctx(ctx).
+			Fields(d.logFields(ctnr)).
+			Dur("maxDowntime", budget).
+			Msg("maximum downtime exceeded, restarting container")
+
+		restartErr := d.engine.EnsureContainerRunning(restoreCtx, ctnr.ID)
+		if restartErr != nil {
+			log.Warn().
+				Ctx(ctx).
+				Err(restartErr).
+				Fields(d.logFields(ctnr)).
+				Msg("failed to restart container after exceeding maximum downtime")
+		}
 	}
+
+	return errMaxDowntimeExceeded
 }
 
-func (d *containerProjectBackupJob) runDependentOfflineBackup(backupCtnr model.ContainerBackup, backupName string) {
+func (d *containerProjectBackupJob) runPausedBackup(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
 	log.Info().
-		Ctx(d.ctx).
+		Ctx(ctx).
 		Fields(d.logFields(backupCtnr)).
-		Msg("starting online backup (dependents offline)")
+		Msg("starting paused backup")
 
-	err := d.engine.EnsureContainerRunning(d.ctx, backupCtnr.ID)
+	return d.withPaused(ctx, []model.ContainerBackup{backupCtnr}, func(ctx context.Context, release func()) error {
+		return d.backupItems(ctx, backupCtnr, backupName, release)
+	})
+}
+
+func (d *containerProjectBackupJob) runDependentPausedBackup(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
+	log.Info().
+		Ctx(ctx).
+		Fields(d.logFields(backupCtnr)).
+		Msg("starting online backup (dependents paused)")
+
+	err := d.engine.EnsureContainerRunning(ctx, backupCtnr.ID)
 	if err != nil {
-		log.Warn().
-			Ctx(d.ctx).
-			Err(err).
-			Fields(d.logFields(backupCtnr)).
-			Msg("failed to ensure container running for online backup (dependents offline)")
+		return fmt.Errorf("failed to ensure container running for online backup (dependents paused): %w", err)
+	}
+
+	err = d.ensureDependenciesRunning(ctx, backupCtnr)
+	if err != nil {
+		return err
+	}
+
+	return d.withPaused(ctx, d.findDependents(backupCtnr), func(ctx context.Context, release func()) error {
+		return d.backupItems(ctx, backupCtnr, backupName, release)
+	})
+}
+
+// withPaused freezes the running containers while the backup runs. They are
+// unpaused afterward on every path, even if pausing or the backup failed or
+// was canceled, or earlier if the backup calls release. The shortest maximum
+// downtime of the paused containers limits how long they stay frozen.
+func (d *containerProjectBackupJob) withPaused(
+	ctx context.Context,
+	containers []model.ContainerBackup,
+	backup func(ctx context.Context, release func()) error,
+) error {
+	paused := make([]model.ContainerBackup, 0, len(containers))
+	pausedAt := time.Now()
+
+	var timer *time.Timer
+	release := sync.OnceFunc(func() {
+		if timer != nil {
+			timer.Stop()
+		}
+
+		d.unpause(ctx, paused, time.Since(pausedAt))
+	})
+
+	defer release()
+
+	for _, ctnr := range containers {
+		wasPaused, err := d.engine.PauseContainer(ctx, ctnr.ID)
+		if err != nil {
+			return fmt.Errorf("failed to pause container %s: %w", ctnr.ServiceName, err)
+		}
+
+		if wasPaused {
+			paused = append(paused, ctnr)
+		}
+	}
+
+	budgetCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
+	budget := downtimeBudget(paused)
+	if budget > 0 {
+		timer = time.AfterFunc(budget, func() { cancel(errMaxDowntimeExceeded) })
+		defer timer.Stop()
+	}
+
+	err := backup(budgetCtx, release)
+	if err == nil || !errors.Is(context.Cause(budgetCtx), errMaxDowntimeExceeded) {
+		return err
+	}
+
+	log.Warn().
+		Ctx(ctx).
+		Dur("maxDowntime", budget).
+		Msg("maximum downtime exceeded, unpausing containers")
+
+	return errMaxDowntimeExceeded
+}
+
+// unpause unpauses the containers independent of whether ctx is done, and
+// reports how long they were frozen.
+func (d *containerProjectBackupJob) unpause(ctx context.Context, paused []model.ContainerBackup, frozen time.Duration) {
+	if len(paused) == 0 {
 		return
 	}
 
-	dependencies := d.findDependencies(backupCtnr)
-	if len(dependencies) > 0 {
-		eg, egCtx := errgroup.WithContext(d.ctx)
-		eg.SetLimit(len(dependencies))
-		for _, dep := range dependencies {
-			eg.Go(func() error {
-				return d.engine.EnsureContainerRunning(egCtx, dep.ID)
-			})
+	unpauseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+	defer cancel()
+
+	for _, ctnr := range paused {
+		err := d.engine.UnpauseContainer(unpauseCtx, ctnr.ID)
+		if err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Fields(d.logFields(ctnr)).
+				Dur("frozen", frozen).
+				Msg("failed to unpause container after backup")
+		} else {
+			log.Info().
+				Ctx(ctx).
+				Fields(d.logFields(ctnr)).
+				Dur("frozen", frozen).
+				Msg("unpaused container after backup")
 		}
+	}
+}
 
-		err = eg.Wait()
+// restart starts the containers again that were running before the backup.
+func (d *containerProjectBackupJob) restart(ctx context.Context, stopped []model.ContainerBackup) {
+	if d.tracker == nil {
+		return
+	}
+
+	restartCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+	defer cancel()
+
+	for _, ctnr := range stopped {
+		if !d.tracker.wasRunning(ctnr.ID) {
+			continue
+		}
+
+		err := d.engine.EnsureContainerRunning(restartCtx, ctnr.ID)
 		if err != nil {
 			log.Warn().
-				Ctx(d.ctx).
+				Ctx(ctx).
 				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("failed to ensure dependencies are running")
+				Fields(d.logFields(ctnr)).
+				Msg("failed to restart container after backup")
+		}
+	}
+}
 
-			return
+// downtimeBudget returns the shortest maximum downtime of the containers, or
+// zero if none of them has one.
+func downtimeBudget(containers []model.ContainerBackup) time.Duration {
+	var budget time.Duration
+	for _, ctnr := range containers {
+		if ctnr.MaxDowntime != nil && *ctnr.MaxDowntime > 0 && (budget == 0 || *ctnr.MaxDowntime < budget) {
+			budget = *ctnr.MaxDowntime
 		}
 	}
 
-	dependents := d.findDependents(backupCtnr)
-	if len(dependents) > 0 {
-		eg, egCtx := errgroup.WithContext(d.ctx)
-		eg.SetLimit(len(dependents))
-		for _, dependent := range dependents {
-			eg.Go(func() error {
-				return d.engine.EnsureContainerStopped(egCtx, dependent.ID)
-			})
+	return budget
+}
+
+func (d *containerProjectBackupJob) ensureDependenciesRunning(ctx context.Context, backupCtnr model.ContainerBackup) error {
+	dependencies := d.findDependencies(backupCtnr)
+	if len(dependencies) == 0 {
+		return nil
+	}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(len(dependencies))
+	for _, dep := range dependencies {
+		eg.Go(func() error {
+			return d.engine.EnsureContainerRunning(egCtx, dep.ID)
+		})
+	}
+
+	err := eg.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to ensure dependencies are running: %w", err)
+	}
+
+	return nil
+}
+
+// backupItems backs up the execs and volumes of the container, each into its
+// own archive unless the archives are combined. A failed item doesn't stop the
+// others, the outcome of every item is recorded in the run result. release is
+// called once the volumes don't need the containers to be stopped or paused
+// anymore, it may be nil.
+func (d *containerProjectBackupJob) backupItems(
+	ctx context.Context,
+	backupCtnr model.ContainerBackup,
+	backupName string,
+	release func(),
+) error {
+	if backupCtnr.CombineArchives && backupCtnr.ItemCount() > 1 {
+		return d.runCombinedBackup(ctx, backupCtnr, backupName, release)
+	}
+
+	var errs []error
+	for _, exec := range backupCtnr.Execs {
+		err := d.runExecBackup(ctx, backupCtnr, exec, itemBackupName(backupCtnr, backupName, exec.ItemName()))
+		recordItem(ctx, backupCtnr.ServiceName+"/"+exec.ItemName(), err)
+
+		if err != nil {
+			errs = append(errs, itemError(backupCtnr, exec.ItemName(), err))
 		}
+	}
+
+	if len(backupCtnr.BackupVolumes) > 0 {
+		err := d.runVolumeBackup(ctx, backupCtnr, itemBackupName(backupCtnr, backupName, model.VolumesItem), release)
+		recordItem(ctx, backupCtnr.ServiceName+"/"+model.VolumesItem, err)
 
-		err = eg.Wait()
 		if err != nil {
-			log.Warn().
-				Ctx(d.ctx).
-				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("failed to ensure dependent containers stopped")
+			errs = append(errs, itemError(backupCtnr, model.VolumesItem, err))
+		}
+	}
 
-			return
+	return errors.Join(errs...)
+}
+
+// prune applies the retention of the project to the archives of the container.
+func (d *containerProjectBackupJob) prune(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) error {
+	var errs []error
+	for _, baseName := range archiveBaseNames(backupCtnr, backupName) {
+		err := d.borgClient.Prune(ctx, utils.ArchiveGlob(baseName), borg.KeepRules(*d.project.Retention))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to prune archives of %s: %w", baseName, err))
 		}
 	}
 
-	if backupCtnr.Exec != nil {
-		d.runExecBackup(backupCtnr, backupName)
-	} else {
-		d.runVolumeBackup(backupCtnr, backupName)
+	return errors.Join(errs...)
+}
+
+// archiveBaseNames returns the names the archives of the container are based
+// on, one per item unless the archives are combined.
+func archiveBaseNames(backupCtnr model.ContainerBackup, backupName string) []string {
+	if backupCtnr.CombineArchives && backupCtnr.ItemCount() > 1 {
+		return []string{backupName}
 	}
+
+	names := make([]string, 0, backupCtnr.ItemCount())
+	for _, exec := range backupCtnr.Execs {
+		names = append(names, itemBackupName(backupCtnr, backupName, exec.ItemName()))
+	}
+
+	if len(backupCtnr.BackupVolumes) > 0 {
+		names = append(names, itemBackupName(backupCtnr, backupName, model.VolumesItem))
+	}
+
+	return names
 }
 
-func (d *containerProjectBackupJob) runOfflineBackup(backupCtnr model.ContainerBackup, backupName string) {
-	log.Info().
-		Ctx(d.ctx).
-		Fields(d.logFields(backupCtnr)).
-		Msg("starting offline backup")
+func itemError(backupCtnr model.ContainerBackup, item string, err error) error {
+	if backupCtnr.ItemCount() == 1 {
+		return err
+	}
 
-	err := d.engine.EnsureContainerStopped(d.ctx, backupCtnr.ID)
-	if err != nil {
-		log.Warn().
-			Ctx(d.ctx).
-			Err(err).
-			Fields(d.logFields(backupCtnr)).
-			Msg("failed to ensure container stopped for offline backup")
+	return fmt.Errorf("%s: %w", item, err)
+}
 
-		return
+// itemBackupName returns the backup name of the item, containers with a
+// single item keep the backup name of the service.
+func itemBackupName(backupCtnr model.ContainerBackup, backupName, item string) string {
+	if backupCtnr.ItemCount() == 1 {
+		return backupName
 	}
 
-	d.runVolumeBackup(backupCtnr, backupName)
+	return backupName + "-" + item
 }
 
-func (d *containerProjectBackupJob) runExecBackup(backupCtnr model.ContainerBackup, backupName string) {
+func (d *containerProjectBackupJob) runExecBackup(
+	ctx context.Context,
+	backupCtnr model.ContainerBackup,
+	exec model.ContainerExecBackup,
+	backupName string,
+) error {
 	if log.Debug().Enabled() {
 		log.Debug().
-			Ctx(d.ctx).
+			Ctx(ctx).
 			Fields(d.logFields(backupCtnr)).
+			Str("exec", exec.ItemName()).
 			Msg("backing up exec result")
 	}
 
-	if backupCtnr.Exec.Stdout {
-		output, err := d.engine.ExecWithOutput(d.ctx, backupCtnr.ID, backupCtnr.Exec.Command)
+	if exec.Stdout {
+		output, err := d.engine.ExecWithOutput(ctx, backupCtnr.ID, exec.Command)
 		if err != nil {
-			log.Warn().
-				Ctx(d.ctx).
-				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("failed to execute exec command")
-
-			return
+			return fmt.Errorf("failed to execute exec command: %w", err)
 		}
 
-		result, err := d.borgClient.CreateWithInput(d.ctx, utils.ArchiveName(backupName), output)
+		var input io.Reader = output
 
-		if err != nil {
-			log.Warn().
-				Ctx(d.ctx).
-				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("backup failed")
+		var checker *dumpChecker
+		if exec.Check != nil {
+			checker = newDumpChecker(output, *exec.Check)
+			input = checker
+		}
 
-			return
+		result, err := d.borgClient.CreateWithInput(ctx, utils.ArchiveName(backupName), input)
+		if err != nil {
+			return err
 		}
 
 		if output.Error() != nil {
 			log.Warn().
-				Ctx(d.ctx).
+				Ctx(ctx).
 				Err(output.Error()).
 				Fields(d.logFields(backupCtnr)).
+				Str("exec", exec.ItemName()).
 				Msg("exec command failed, backup may be incomplete")
 		}
 
-		logBackupComplete(d.ctx, backupName, result)
+		if checker != nil {
+			if err = checker.verify(); err != nil {
+				return fmt.Errorf("backup is incomplete: %w", err)
+			}
+		}
+
+		logBackupComplete(ctx, backupName, result)
 	} else {
-		err := d.engine.Exec(d.ctx, backupCtnr.ID, backupCtnr.Exec.Command)
+		err := d.engine.Exec(ctx, backupCtnr.ID, exec.Command, nil)
 		if err != nil {
-			log.Warn().
-				Ctx(d.ctx).
-				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("failed to execute exec command")
+			return fmt.Errorf("failed to execute exec command: %w", err)
+		}
 
-			return
+		var result api.CreateOutput
+		if paths, onHost := execHostPaths(backupCtnr, exec); onHost {
+			result, err = d.borgClient.CreateWithPaths(ctx, utils.ArchiveName(backupName), paths)
+		} else {
+			content := containerTar(ctx, d.engine, backupCtnr.ID, exec.Paths)
+			result, err = d.borgClient.ImportTar(ctx, utils.ArchiveName(backupName), content)
+			_ = content.Close()
 		}
 
-		paths := make([]string, 0, len(backupCtnr.Exec.Paths))
-		for _, cPath := range backupCtnr.Exec.Paths {
-			sPath, found := findSourceForInContainerPath(&backupCtnr, cPath)
-			if !found {
-				log.Warn().
-					Ctx(d.ctx).
-					Fields(d.logFields(backupCtnr)).
-					Str("path", cPath).
-					Msg("no source for path")
+		if err != nil {
+			return err
+		}
+
+		logBackupComplete(ctx, backupName, result)
+	}
+
+	return nil
+}
+
+// execHostPaths returns the host paths of the exec, or false if they're read
+// through the archive API.
+func execHostPaths(backupCtnr model.ContainerBackup, exec model.ContainerExecBackup) ([]string, bool) {
+	if exec.PathSource == model.PathSourceArchive {
+		return nil, false
+	}
+
+	paths := make([]string, 0, len(exec.Paths))
+	for _, cPath := range exec.Paths {
+		sPath, found := findSourceForInContainerPath(&backupCtnr, cPath)
+		if !found {
+			// paths from the host were checked to exist when the job was created
+			return nil, false
+		}
+
+		paths = append(paths, sPath)
+	}
+
+	return paths, true
+}
+
+// writeContainerTar writes the paths of the exec, read through the archive
+// API, to the file.
+func (d *containerProjectBackupJob) writeContainerTar(
+	ctx context.Context,
+	backupCtnr model.ContainerBackup,
+	exec model.ContainerExecBackup,
+	filePath string,
+) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
 
+	err = writeContainerTar(ctx, d.engine, backupCtnr.ID, exec.Paths, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// dumpExec writes the output of an exec with stdout to the file.
+func (d *containerProjectBackupJob) dumpExec(
+	ctx context.Context,
+	backupCtnr model.ContainerBackup,
+	exec model.ContainerExecBackup,
+	path string,
+) error {
+	output, err := d.engine.ExecWithOutput(ctx, backupCtnr.ID, exec.Command)
+	if err != nil {
+		return fmt.Errorf("failed to execute exec command: %w", err)
+	}
+
+	var input io.Reader = output
+
+	var checker *dumpChecker
+	if exec.Check != nil {
+		checker = newDumpChecker(output, *exec.Check)
+		input = checker
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		_, _ = io.Copy(io.Discard, output)
+		return err
+	}
+
+	_, err = io.Copy(file, input)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write exec output: %w", err)
+	}
+
+	if output.Error() != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(output.Error()).
+			Fields(d.logFields(backupCtnr)).
+			Str("exec", exec.ItemName()).
+			Msg("exec command failed, backup may be incomplete")
+	}
+
+	if checker != nil {
+		if err = checker.verify(); err != nil {
+			return fmt.Errorf("backup is incomplete: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// runCombinedBackup backs up all items of the container into a single
+// archive. The output of execs with stdout is written to <item>.dump, which
+// is stored at the root of the archive, next to the volumes and the paths of
+// the other execs. An item that fails is left out of the archive.
+func (d *containerProjectBackupJob) runCombinedBackup(
+	ctx context.Context,
+	backupCtnr model.ContainerBackup,
+	backupName string,
+	release func(),
+) error {
+	if release == nil {
+		release = func() {}
+	}
+
+	defer release()
+
+	var dir string
+	var paths []string
+
+	if backupCtnr.Snapshot != "" {
+		// the dumps are written into the snapshot root, so the volumes are
+		// snapshotted before the execs run
+		set, destroy, err := createSnapshots(ctx, d.tempDir, backupName, backupCtnr.Snapshot, volumeSources(backupCtnr))
+		if err != nil {
+			return err
+		}
+
+		defer destroy()
+
+		dir = set.Root()
+		paths = set.Paths()
+	} else {
+		var err error
+		dir, err = os.MkdirTemp(d.tempDir, "borgd-dumps-*")
+		if err != nil {
+			return fmt.Errorf("failed to create dump directory: %w", err)
+		}
+
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		paths = volumeSources(backupCtnr)
+	}
+
+	itemErrs := make(map[string]error, len(backupCtnr.Execs))
+	for _, exec := range backupCtnr.Execs {
+		if exec.Stdout {
+			dumpName := exec.ItemName() + ".dump"
+			dumpPath := filepath.Join(dir, dumpName)
+
+			err := d.dumpExec(ctx, backupCtnr, exec, dumpPath)
+			defer func() { _ = os.Remove(dumpPath) }()
+
+			if err != nil {
+				itemErrs[exec.ItemName()] = err
 				continue
 			}
 
-			paths = append(paths, sPath)
+			paths = append(paths, dumpName)
+		} else {
+			err := d.engine.Exec(ctx, backupCtnr.ID, exec.Command, nil)
+			if err != nil {
+				itemErrs[exec.ItemName()] = fmt.Errorf("failed to execute exec command: %w", err)
+				continue
+			}
+
+			if execPaths, onHost := execHostPaths(backupCtnr, exec); onHost {
+				paths = append(paths, execPaths...)
+				continue
+			}
+
+			tarName := exec.ItemName() + ".tar"
+			tarPath := filepath.Join(dir, tarName)
+
+			err = d.writeContainerTar(ctx, backupCtnr, exec, tarPath)
+			defer func() { _ = os.Remove(tarPath) }()
+
+			if err != nil {
+				itemErrs[exec.ItemName()] = err
+				continue
+			}
+
+			paths = append(paths, tarName)
 		}
+	}
 
-		result, err := d.borgClient.CreateWithPaths(utils.ArchiveName(backupName), paths)
-		if err != nil {
-			log.Warn().
-				Ctx(d.ctx).
-				Err(err).
-				Fields(d.logFields(backupCtnr)).
-				Msg("backup failed")
+	if backupCtnr.Snapshot != "" {
+		release()
+	}
+
+	var err error
+	if len(paths) == 0 {
+		err = errors.New("nothing to back up")
+	} else {
+		var result api.CreateOutput
+		result, err = d.borgClient.CreateWithPathsIn(ctx, utils.ArchiveName(backupName), dir, paths)
+		if err == nil {
+			logBackupComplete(ctx, backupName, result)
 		}
+	}
+
+	var errs []error
+	items := make([]string, 0, backupCtnr.ItemCount())
+	for _, exec := range backupCtnr.Execs {
+		items = append(items, exec.ItemName())
+	}
 
-		logBackupComplete(d.ctx, backupName, result)
+	if len(backupCtnr.BackupVolumes) > 0 {
+		items = append(items, model.VolumesItem)
 	}
+
+	for _, item := range items {
+		itemErr, failed := itemErrs[item]
+		if !failed {
+			itemErr = err
+		}
+
+		recordItem(ctx, backupCtnr.ServiceName+"/"+item, itemErr)
+
+		if failed {
+			errs = append(errs, itemError(backupCtnr, item, itemErr))
+		}
+	}
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
 
-func (d *containerProjectBackupJob) runVolumeBackup(backupCtnr model.ContainerBackup, backupName string) {
+func volumeSources(backupCtnr model.ContainerBackup) []string {
 	paths := make([]string, 0, len(backupCtnr.BackupVolumes))
 	for _, vol := range backupCtnr.BackupVolumes {
 		paths = append(paths, vol.Source)
 	}
 
-	result, err := d.borgClient.CreateWithPaths(utils.ArchiveName(backupName), paths)
-	if err != nil {
-		log.Warn().
-			Ctx(d.ctx).
-			Err(err).
-			Fields(d.logFields(backupCtnr)).
-			Msg("backup failed")
+	return paths
+}
 
-		return
+// runVolumeBackup backs up the volumes of the container, from snapshots if
+// configured. release is called once the snapshots exist, it may be nil.
+func (d *containerProjectBackupJob) runVolumeBackup(
+	ctx context.Context,
+	backupCtnr model.ContainerBackup,
+	backupName string,
+	release func(),
+) error {
+	paths := volumeSources(backupCtnr)
+
+	if backupCtnr.Snapshot != "" {
+		return backupSnapshots(ctx, d.borgClient, d.tempDir, backupName, backupCtnr.Snapshot, paths, release)
+	}
+
+	result, err := d.borgClient.CreateWithPaths(ctx, utils.ArchiveName(backupName), paths)
+	if err != nil {
+		return err
 	}
 
-	logBackupComplete(d.ctx, backupName, result)
+	logBackupComplete(ctx, backupName, result)
+
+	return nil
 }
 
+// findSourceForInContainerPath returns the host path of the in-container
+// path, if it's on a mount of the container. The innermost mount wins, paths
+// that aren't on a mount are found in the upper directory of the overlay
+// filesystem of the container, if it has one.
 func findSourceForInContainerPath(ctnr *model.ContainerBackup, cPath string) (string, bool) {
-	lowerCPath := strings.ToLower(cPath)
-	for _, vol := range ctnr.AllVolumes {
-		if strings.HasPrefix(strings.ToLower(vol.Destination), lowerCPath) {
-			return path.Join(vol.Source, cPath[len(vol.Destination):]), true
+	cPath = path.Clean(cPath)
+
+	var found *model.Volume
+	for i, vol := range ctnr.AllVolumes {
+		destination := path.Clean(vol.Destination)
+		if cPath != destination && !strings.HasPrefix(cPath, strings.TrimSuffix(destination, "/")+"/") {
+			continue
+		}
+
+		if found == nil || len(destination) > len(path.Clean(found.Destination)) {
+			found = &ctnr.AllVolumes[i]
 		}
 	}
 
-	if ctnr.UpperDirPath != "" {
-		if strings.HasPrefix(strings.ToLower(ctnr.UpperDirPath), lowerCPath) {
+	if found == nil {
+		if ctnr.UpperDirPath != "" {
 			return path.Join(ctnr.UpperDirPath, cPath), true
 		}
+
+		return "", false
 	}
 
-	return "", false
+	return path.Join(found.Source, strings.TrimPrefix(cPath, path.Clean(found.Destination))), true
 }
 
 func (d *containerProjectBackupJob) findDependencies(backup model.ContainerBackup) []model.ContainerBackup {
@@ -17,13 +17,20 @@ package worker
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
 	"github.com/vemilyus/borg-collective/internal/drone/borg"
 	"github.com/vemilyus/borg-collective/internal/drone/config"
 	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
 )
 
 func TestContainerProjectBackupJob(t *testing.T) {
@@ -54,9 +61,9 @@ func TestContainerProjectBackupJob(t *testing.T) {
 	paperlessProject := projects[0]
 
 	worker := Worker{
-		ctx:          ctx,
-		borgClient:   borgClient,
-		dockerClient: engine,
+		ctx:        ctx,
+		borgClient: borgClient,
+		containers: map[model.ContainerEngine]*docker.Client{model.ContainerEngineDocker: engine},
 	}
 
 	job, err := worker.newContainerProjectBackupJob(paperlessProject)
@@ -71,5 +78,304 @@ func TestContainerProjectBackupJob(t *testing.T) {
 
 	assert.Equal(t, []string{"server", "redis", "db"}, backupOrder)
 
-	backupJob.Run()
+	_ = backupJob.Run(ctx)
+}
+
+func fakeProjectContainer(service string, running bool, labels map[string]string) dockertest.Container {
+	ctnr := dockertest.Container{
+		ID:      service,
+		Name:    "paperless-" + service + "-1",
+		Running: running,
+		Labels: map[string]string{
+			model.LabelBorgdEnabled:     "true",
+			model.LabelProjectName:      "paperless",
+			model.LabelProjectWhen:      "0 3 * * *",
+			model.LabelServiceName:      service,
+			model.LabelVolumesPfx + "0": "/data",
+		},
+		Mounts: []container.MountPoint{{
+			Type:        mount.TypeBind,
+			Source:      "/srv/paperless/" + service,
+			Destination: "/data",
+		}},
+	}
+
+	for key, value := range labels {
+		ctnr.Labels[key] = value
+	}
+
+	return ctnr
+}
+
+func newFakeProjectBackupJob(t *testing.T, engine *docker.Client) *containerProjectBackupJob {
+	projects, err := engine.ReadProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, projects, 1)
+
+	worker := Worker{
+		ctx:        context.Background(),
+		borgClient: newMissingRepoClient(t),
+		containers: map[model.ContainerEngine]*docker.Client{model.ContainerEngineDocker: engine},
+	}
+
+	job, err := worker.newContainerProjectBackupJob(projects[0])
+	assert.NoError(t, err)
+
+	return job.(*containerProjectBackupJob)
+}
+
+func TestContainerProjectBackupJob_RestoresContainerStates(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("db", true, map[string]string{
+		model.LabelBackupMode: "offline",
+	}))
+	server.AddContainer(fakeProjectContainer("redis", false, map[string]string{
+		model.LabelVolumesPfx + "0": "",
+	}))
+	server.AddContainer(fakeProjectContainer("server", true, map[string]string{
+		model.LabelDependenciesPfx + "0": "redis",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	err := job.Run(context.Background())
+	assert.Error(t, err)
+
+	ops := server.Ops()
+	if assert.Len(t, ops, 4) {
+		// redis has nothing to back up, it's only started as a dependency
+		assert.Equal(t, []string{"start paperless-redis-1", "stop paperless-db-1"}, ops[:2])
+		assert.ElementsMatch(t, []string{"start paperless-db-1", "stop paperless-redis-1"}, ops[2:])
+	}
+
+	assert.True(t, server.IsRunning("db"))
+	assert.False(t, server.IsRunning("redis"))
+	assert.True(t, server.IsRunning("server"))
+}
+
+func TestContainerProjectBackupJob_RestartsBeforeNextService(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("db", true, map[string]string{
+		model.LabelBackupMode:  "offline",
+		model.LabelMaxDowntime: "1h",
+	}))
+	server.AddContainer(fakeProjectContainer("server", true, map[string]string{
+		model.LabelBackupMode:  "offline",
+		model.LabelMaxDowntime: "1h",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	err := job.Run(context.Background())
+	assert.Error(t, err)
+
+	// the maximum downtime only covers the backup of each service, which is
+	// started again before the next service is stopped
+	ops := server.Ops()
+	if assert.Len(t, ops, 4) {
+		assert.ElementsMatch(t, []string{"stop paperless-db-1", "stop paperless-server-1"}, []string{ops[0], ops[2]})
+		assert.Equal(t, "start"+strings.TrimPrefix(ops[0], "stop"), ops[1])
+		assert.Equal(t, "start"+strings.TrimPrefix(ops[2], "stop"), ops[3])
+	}
+}
+
+func TestContainerProjectBackupJob_WaitsForHealthyDependencies(t *testing.T) {
+	engine, server := newFakeEngine(t)
+
+	redis := fakeProjectContainer("redis", false, map[string]string{model.LabelVolumesPfx + "0": ""})
+	redis.Health = container.Unhealthy
+
+	server.AddContainer(redis)
+	server.AddContainer(fakeProjectContainer("server", true, map[string]string{
+		model.LabelDependenciesPfx + "0": "redis",
+		model.LabelPreExec:               "prepare",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	err := job.Run(context.Background())
+	assert.ErrorContains(t, err, "failed to ensure dependencies are running")
+	assert.ErrorContains(t, err, "container is unhealthy: redis")
+
+	// the pre hook of the service runs before its dependencies are started
+	assert.Equal(t, []string{"exec paperless-server-1 prepare", "start paperless-redis-1", "stop paperless-redis-1"}, server.Ops())
+}
+
+func TestContainerProjectBackupJob_PreHookFailure(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("db", true, map[string]string{
+		model.LabelBackupMode:            "offline",
+		model.LabelProjectPreExec:        "prepare",
+		model.LabelProjectFinallyExec:    "cleanup",
+		model.LabelProjectHooksContainer: "db",
+	}))
+	server.SetExecFunc(func(_ string, cmd []string) ([]byte, int) {
+		if cmd[0] == "prepare" {
+			return nil, 1
+		}
+
+		return nil, 0
+	})
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	err := job.Run(context.Background())
+	assert.ErrorContains(t, err, "pre hook failed")
+
+	// the container isn't stopped, but the finally hook still runs
+	assert.Equal(t, []string{"exec paperless-db-1 prepare", "exec paperless-db-1 cleanup"}, server.Ops())
+	assert.True(t, server.IsRunning("db"))
+}
+
+func TestContainerProjectBackupJob_PruneFailure(t *testing.T) {
+	// borg creates archives, but fails to prune them
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "borg"), []byte("#!/bin/sh\n[ \"$2\" = prune ] && exit 2\necho '{}'\n"), 0o755)
+	assert.NoError(t, err)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	statusFile := filepath.Join(t.TempDir(), "status")
+
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("db", true, map[string]string{
+		model.LabelProjectRetention: "daily=7",
+		model.LabelHooksOnHost:      "true",
+		model.LabelPostExec:         "sh -c 'echo post $BORGD_STATUS >> " + statusFile + "'",
+		model.LabelFinallyExec:      "sh -c 'echo finally $BORGD_STATUS >> " + statusFile + "'",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	err = job.Run(context.Background())
+	assert.ErrorContains(t, err, "failed to prune archives of paperless-db")
+
+	// the hooks of the service are told about the failed prune
+	status, err := os.ReadFile(statusFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "finally failed\n", string(status))
+}
+
+func TestContainerProjectBackupJob_Paused(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("db", true, map[string]string{
+		model.LabelBackupMode:  "paused",
+		model.LabelFinallyExec: "notify",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	// the container is unpaused although the backup fails, before its finally
+	// hook runs
+	err := job.Run(context.Background())
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"pause paperless-db-1", "unpause paperless-db-1", "exec paperless-db-1 notify"}, server.Ops())
+	assert.False(t, server.IsPaused("db"))
+	assert.True(t, server.IsRunning("db"))
+}
+
+func TestContainerProjectBackupJob_DependentPaused(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("db", false, map[string]string{
+		model.LabelBackupMode: "dependent-paused",
+	}))
+	server.AddContainer(fakeProjectContainer("server", true, map[string]string{
+		model.LabelVolumesPfx + "0":      "",
+		model.LabelDependenciesPfx + "0": "db",
+	}))
+	server.AddContainer(fakeProjectContainer("worker", false, map[string]string{
+		model.LabelVolumesPfx + "0":      "",
+		model.LabelDependenciesPfx + "0": "db",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	err := job.Run(context.Background())
+	assert.Error(t, err)
+
+	// the stopped worker isn't paused, db is stopped again afterward
+	assert.Equal(t, []string{
+		"start paperless-db-1",
+		"pause paperless-server-1",
+		"unpause paperless-server-1",
+		"stop paperless-db-1",
+	}, server.Ops())
+	assert.False(t, server.IsPaused("server"))
+}
+
+func TestContainerProjectBackupJob_Items(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("server", true, map[string]string{
+		model.LabelExecPfx + "db":             "dump-db",
+		model.LabelExecPfx + "db.stdout":      "true",
+		model.LabelExecPfx + "export":         "export",
+		model.LabelExecPfx + "export.paths.0": "/data",
+	}))
+
+	job := newFakeProjectBackupJob(t, engine)
+
+	ctx, result := newRunResult(context.Background(), "paperless", "", t.TempDir())
+
+	// every item is attempted, although borg fails for each of them
+	err := job.backupServices(ctx, ctx, result)
+	assert.ErrorContains(t, err, "server: db: ")
+	assert.ErrorContains(t, err, "\nexport: ")
+	assert.ErrorContains(t, err, "\nvolumes: ")
+
+	assert.Equal(t, []string{"exec paperless-server-1 dump-db", "exec paperless-server-1 export"}, server.Ops())
+
+	items := result.report("failed", err).Items
+	if assert.Len(t, items, 3) {
+		assert.Equal(t, "server/db", items[0].Name)
+		assert.Equal(t, "failed", items[0].Status)
+		assert.Equal(t, "server/export", items[1].Name)
+		assert.Equal(t, "server/volumes", items[2].Name)
+	}
+}
+
+func TestContainerProjectBackupJob_CombinedItems(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(fakeProjectContainer("server", true, map[string]string{
+		model.LabelExecPfx + "db":        "dump-db",
+		model.LabelExecPfx + "db.stdout": "true",
+		model.LabelArchives:              "combined",
+	}))
+	server.SetExecFunc(func(_ string, cmd []string) ([]byte, int) {
+		return []byte("incomplete"), 0
+	})
+
+	job := newFakeProjectBackupJob(t, engine)
+	job.plan[0].Execs[0].Check = &model.DumpCheck{Suffix: "dump complete"}
+	job.tempDir = t.TempDir()
+
+	ctx, result := newRunResult(context.Background(), "paperless", "", t.TempDir())
+
+	// the volumes are still backed up when the dump fails its check
+	err := job.backupServices(ctx, ctx, result)
+	assert.ErrorContains(t, err, "db: backup is incomplete")
+	assert.ErrorContains(t, err, "failed to run borg create")
+
+	items := result.report("failed", err).Items
+	if assert.Len(t, items, 2) {
+		assert.Contains(t, items[0].Error, "expected completion marker")
+		assert.Contains(t, items[1].Error, "failed to run borg create")
+	}
+
+	entries, err := os.ReadDir(job.tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestArchiveBaseNames(t *testing.T) {
+	backup := model.ContainerBackup{
+		Execs:         []model.ContainerExecBackup{{Name: "export"}, {Name: "postgres"}},
+		BackupVolumes: []model.Volume{{Destination: "/data"}},
+	}
+
+	assert.Equal(t, []string{"app-db-export", "app-db-postgres", "app-db-volumes"}, archiveBaseNames(backup, "app-db"))
+
+	backup.CombineArchives = true
+	assert.Equal(t, []string{"app-db"}, archiveBaseNames(backup, "app-db"))
+
+	assert.Equal(t, []string{"app-db"}, archiveBaseNames(model.ContainerBackup{Execs: backup.Execs[:1]}, "app-db"))
 }
@@ -0,0 +1,87 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/vemilyus/borg-collective/internal/drone/container"
+)
+
+// containerTar streams the paths of the container as a single tar stream,
+// read through the archive API of the engine. Entries are named by their path
+// in the container, without the leading slash. The stream has to be closed,
+// even if it wasn't read completely.
+func containerTar(ctx context.Context, engine container.Engine, containerID string, paths []string) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(writeContainerTar(ctx, engine, containerID, paths, writer))
+	}()
+
+	return reader
+}
+
+func writeContainerTar(ctx context.Context, engine container.Engine, containerID string, paths []string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, cPath := range paths {
+		cPath = path.Clean(cPath)
+
+		content, err := engine.CopyFromContainer(ctx, containerID, cPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s from container: %w", cPath, err)
+		}
+
+		// the engine names the entries relative to the parent of the path
+		err = copyTarEntries(tw, tar.NewReader(content), strings.TrimPrefix(path.Dir(cPath), "/"))
+		_ = content.Close()
+
+		if err != nil {
+			return fmt.Errorf("failed to copy %s from container: %w", cPath, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func copyTarEntries(tw *tar.Writer, tr *tar.Reader, prefix string) error {
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		header.Name = path.Join(prefix, header.Name)
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = path.Join(prefix, header.Linkname)
+		}
+
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err = io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
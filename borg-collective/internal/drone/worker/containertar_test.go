@@ -0,0 +1,104 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+func TestContainerTar(t *testing.T) {
+	engine, server := newFakeEngine(t)
+	server.AddContainer(dockertest.Container{
+		ID:   "app",
+		Name: "app-1",
+		Files: map[string]string{
+			"/var/lib/app/export/a.json": "a",
+			"/var/lib/app/export/b.json": "bb",
+			"/etc/app.conf":              "conf",
+		},
+	})
+
+	content := containerTar(context.Background(), engine, "app", []string{"/var/lib/app/export/", "/etc/app.conf"})
+	defer func() { _ = content.Close() }()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(content)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		data, _ := io.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+
+	assert.Equal(t, map[string]string{
+		"var/lib/app/export":        "",
+		"var/lib/app/export/a.json": "a",
+		"var/lib/app/export/b.json": "bb",
+		"etc/app.conf":              "conf",
+	}, files)
+
+	content = containerTar(context.Background(), engine, "app", []string{"/missing"})
+	_, err := io.ReadAll(content)
+	assert.ErrorContains(t, err, "failed to copy /missing from container")
+}
+
+func TestFindSourceForInContainerPath(t *testing.T) {
+	ctnr := &model.ContainerBackup{AllVolumes: []model.Volume{
+		{Source: "/srv/app/data", Destination: "/data"},
+		{Source: "/srv/app/uploads", Destination: "/data/uploads/"},
+	}}
+
+	source, found := findSourceForInContainerPath(ctnr, "/data/export")
+	assert.True(t, found)
+	assert.Equal(t, "/srv/app/data/export", source)
+
+	source, found = findSourceForInContainerPath(ctnr, "/data/uploads/2025")
+	assert.True(t, found)
+	assert.Equal(t, "/srv/app/uploads/2025", source)
+
+	source, found = findSourceForInContainerPath(ctnr, "/data")
+	assert.True(t, found)
+	assert.Equal(t, "/srv/app/data", source)
+
+	_, found = findSourceForInContainerPath(ctnr, "/database")
+	assert.False(t, found)
+
+	// paths that aren't on a mount fall back to the overlay upper directory
+	ctnr.UpperDirPath = "/var/lib/docker/overlay2/abc/diff"
+
+	source, found = findSourceForInContainerPath(ctnr, "/database")
+	assert.True(t, found)
+	assert.Equal(t, "/var/lib/docker/overlay2/abc/diff/database", source)
+
+	source, found = findSourceForInContainerPath(ctnr, "/data/export")
+	assert.True(t, found)
+	assert.Equal(t, "/srv/app/data/export", source)
+}
@@ -0,0 +1,242 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/graph"
+)
+
+var errRequirementFailed = errors.New("required job did not succeed")
+
+func (sj *scheduledJob) predecessors() []string {
+	return slices.Concat(sj.after, sj.requires)
+}
+
+func (sj *scheduledJob) runsAfter(name string) bool {
+	return slices.Contains(sj.after, name) || slices.Contains(sj.requires, name)
+}
+
+// failedRequirements returns the required jobs that didn't succeed according
+// to finished. A required job that isn't known has never succeeded.
+func (sj *scheduledJob) failedRequirements(finished map[string]bool) []string {
+	failed := make([]string, 0)
+	for _, name := range sj.requires {
+		if !finished[name] {
+			failed = append(failed, name)
+		}
+	}
+
+	return failed
+}
+
+// runDependents records that the job with the given name has finished, and
+// runs every job after it, once all of their known predecessors have finished
+// since their last run. Jobs that require a predecessor that didn't succeed
+// are skipped.
+func (w *Worker) runDependents(name string, err error) {
+	w.schedulerMutex.Lock()
+
+	ready := make([]*scheduledJob, 0)
+	for _, sj := range w.jobs {
+		if !sj.runsAfter(name) {
+			continue
+		}
+
+		finished, found := w.finished[sj.name]
+		if !found {
+			finished = make(map[string]bool)
+			w.finished[sj.name] = finished
+		}
+
+		finished[name] = err == nil
+
+		if !w.predecessorsFinished(sj, finished) {
+			continue
+		}
+
+		delete(w.finished, sj.name)
+
+		if failed := sj.failedRequirements(finished); len(failed) > 0 {
+			log.Warn().
+				Ctx(w.ctx).
+				Str("job", sj.name).
+				Strs("requires", failed).
+				Msg("skipping job run, required jobs did not succeed")
+
+			continue
+		}
+
+		ready = append(ready, sj)
+	}
+
+	w.schedulerMutex.Unlock()
+
+	slices.SortFunc(ready, func(a, b *scheduledJob) int {
+		return cmp.Compare(a.name, b.name)
+	})
+
+	for _, sj := range ready {
+		log.Info().
+			Ctx(w.ctx).
+			Str("job", sj.name).
+			Str("after", name).
+			Msg("predecessors finished, running job")
+
+		w.enqueue(sj)
+	}
+}
+
+// predecessorsFinished must be called with the scheduler mutex held.
+func (w *Worker) predecessorsFinished(sj *scheduledJob, finished map[string]bool) bool {
+	for _, name := range sj.predecessors() {
+		if w.jobNamed(name) == nil {
+			continue
+		}
+
+		if _, done := finished[name]; !done {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dependencyCycle returns a cycle between the scheduled jobs, or nil if there
+// is none. Must be called with the scheduler mutex held.
+func (w *Worker) dependencyCycle() []string {
+	edges := make(map[string][]string, len(w.jobs))
+	for _, sj := range w.jobs {
+		edges[sj.name] = sj.predecessors()
+	}
+
+	return graph.FindCycle(edges)
+}
+
+// breakDependencyCycles unschedules container projects until no cycle is left.
+// Cycles between static backups and compaction are rejected when the config
+// is loaded, but they can still form with container projects when the config
+// changes. Must be called with the scheduler mutex held.
+func (w *Worker) breakDependencyCycles() {
+	for cycle := w.dependencyCycle(); cycle != nil; cycle = w.dependencyCycle() {
+		index := slices.IndexFunc(cycle, func(name string) bool { return w.jobNamed(name).kind == jobKindContainer })
+		if index < 0 {
+			// can't happen with a valid config, but never loop forever
+			log.Error().
+				Ctx(w.ctx).
+				Str("cycle", strings.Join(cycle, " -> ")).
+				Msg("dependency cycle between jobs")
+
+			return
+		}
+
+		sj := w.jobNamed(cycle[index])
+
+		log.Warn().
+			Ctx(w.ctx).
+			Str("engine", string(sj.engine)).
+			Str("projectName", sj.project).
+			Str("cycle", strings.Join(cycle, " -> ")).
+			Msg("unscheduling container backup project, dependency cycle")
+
+		w.unscheduleProject(sj.engine, sj.project)
+	}
+}
+
+// orderedOnceJobs runs the selected jobs in dependency order. Predecessors
+// that weren't selected are ignored.
+type orderedOnceJobs struct {
+	selected map[string]*scheduledJob
+	started  map[string]struct{}
+	results  map[string]error
+}
+
+func newOrderedOnceJobs(selected []*scheduledJob) *orderedOnceJobs {
+	o := &orderedOnceJobs{
+		selected: make(map[string]*scheduledJob, len(selected)),
+		started:  make(map[string]struct{}, len(selected)),
+		results:  make(map[string]error, len(selected)),
+	}
+
+	for _, sj := range selected {
+		o.selected[sj.name] = sj
+	}
+
+	return o
+}
+
+// ready returns the jobs that can be started, because all of their selected
+// predecessors have a result. Jobs that require a failed predecessor get a
+// result without being started.
+func (o *orderedOnceJobs) ready() []*scheduledJob {
+	result := make([]*scheduledJob, 0)
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, name := range slices.Sorted(maps.Keys(o.selected)) {
+			sj := o.selected[name]
+			if _, started := o.started[name]; started {
+				continue
+			}
+
+			if _, done := o.results[name]; done {
+				continue
+			}
+
+			waiting := false
+			var failed error
+			for _, predecessor := range sj.predecessors() {
+				if _, selected := o.selected[predecessor]; !selected {
+					continue
+				}
+
+				predecessorErr, done := o.results[predecessor]
+				if !done {
+					waiting = true
+					break
+				}
+
+				if predecessorErr != nil && slices.Contains(sj.requires, predecessor) {
+					failed = fmt.Errorf("%w: %s", errRequirementFailed, predecessor)
+				}
+			}
+
+			if waiting {
+				continue
+			}
+
+			if failed != nil {
+				o.results[name] = failed
+				changed = true
+
+				continue
+			}
+
+			o.started[name] = struct{}{}
+			result = append(result, sj)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/borg"
+)
+
+func TestWorkerRunDependents(t *testing.T) {
+	ctx := context.Background()
+
+	var compactions, uploads atomic.Int32
+	worker := &Worker{
+		ctx:        ctx,
+		borgClient: &borg.Client{},
+		queue:      newJobQueue(ctx, 1),
+		finished:   make(map[string]map[string]bool),
+		jobs: jobsByKey(
+			&scheduledJob{name: "db-dump"},
+			&scheduledJob{name: "app"},
+			&scheduledJob{
+				name:  "compaction",
+				after: []string{"db-dump", "app", "not-scheduled"},
+				job:   jobFunc(func() { compactions.Add(1) }),
+			},
+			&scheduledJob{
+				name:     "upload",
+				requires: []string{"db-dump"},
+				job:      jobFunc(func() { uploads.Add(1) }),
+			},
+		),
+	}
+
+	worker.runDependents("db-dump", nil)
+	worker.queue.wait()
+
+	assert.Equal(t, int32(0), compactions.Load())
+	assert.Equal(t, int32(1), uploads.Load())
+
+	worker.runDependents("app", errors.New("failed"))
+	worker.queue.wait()
+
+	assert.Equal(t, int32(1), compactions.Load())
+
+	worker.runDependents("db-dump", errors.New("failed"))
+	worker.queue.wait()
+
+	assert.Equal(t, int32(1), uploads.Load())
+	assert.Equal(t, map[string]map[string]bool{"compaction": {"db-dump": false}}, worker.finished)
+}
+
+func TestWorkerBreakDependencyCycles(t *testing.T) {
+	worker := &Worker{
+		ctx: context.Background(),
+		jobs: jobsByKey(
+			&scheduledJob{name: "static", kind: jobKindStatic, after: []string{"project"}},
+			&scheduledJob{name: "project", kind: jobKindContainer, project: "project", requires: []string{"static"}},
+			&scheduledJob{name: "other", kind: jobKindContainer, project: "other", after: []string{"static"}},
+		),
+	}
+
+	worker.breakDependencyCycles()
+
+	assert.Nil(t, worker.dependencyCycle())
+	assert.Equal(t, []string{"other", "static"}, jobNames(worker))
+}
+
+func TestOrderedOnceJobs(t *testing.T) {
+	ordered := newOrderedOnceJobs([]*scheduledJob{
+		{name: "app", after: []string{"db-dump", "unselected"}},
+		{name: "compaction", after: []string{"app", "upload"}},
+		{name: "db-dump"},
+		{name: "upload", requires: []string{"db-dump"}},
+	})
+
+	names := func(jobs []*scheduledJob) []string {
+		result := make([]string, 0, len(jobs))
+		for _, sj := range jobs {
+			result = append(result, sj.name)
+		}
+
+		return result
+	}
+
+	assert.Equal(t, []string{"db-dump"}, names(ordered.ready()))
+	assert.Empty(t, ordered.ready())
+
+	ordered.results["db-dump"] = errors.New("failed")
+	assert.Equal(t, []string{"app"}, names(ordered.ready()))
+	assert.ErrorIs(t, ordered.results["upload"], errRequirementFailed)
+
+	ordered.results["app"] = nil
+	assert.Equal(t, []string{"compaction"}, names(ordered.ready()))
+}
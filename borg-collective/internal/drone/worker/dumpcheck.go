@@ -0,0 +1,69 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+// dumpTailSize is how close to the end of a dump its completion marker must
+// be.
+const dumpTailSize = 4096
+
+// dumpChecker keeps the start and the end of a dump passing through it, to
+// check them once the dump is complete.
+type dumpChecker struct {
+	io.Reader
+	check model.DumpCheck
+	head  []byte
+	tail  []byte
+}
+
+func newDumpChecker(r io.Reader, check model.DumpCheck) *dumpChecker {
+	return &dumpChecker{Reader: r, check: check}
+}
+
+func (c *dumpChecker) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+
+	if missing := len(c.check.Prefix) - len(c.head); missing > 0 {
+		c.head = append(c.head, p[:min(n, missing)]...)
+	}
+
+	c.tail = append(c.tail, p[:n]...)
+	if len(c.tail) > 2*dumpTailSize {
+		c.tail = append(c.tail[:0], c.tail[len(c.tail)-dumpTailSize:]...)
+	}
+
+	return n, err
+}
+
+func (c *dumpChecker) verify() error {
+	if !bytes.HasPrefix(c.head, []byte(c.check.Prefix)) {
+		return errors.New("dump doesn't start with the expected header")
+	}
+
+	tail := c.tail[max(0, len(c.tail)-dumpTailSize):]
+	if !bytes.Contains(tail, []byte(c.check.Suffix)) {
+		return errors.New("dump doesn't end with the expected completion marker")
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+func TestDumpChecker(t *testing.T) {
+	dump := "--\n-- PostgreSQL database dump\n--\n" + strings.Repeat("INSERT INTO t VALUES (1);\n", 1000) +
+		"--\n-- PostgreSQL database dump complete\n--\n\n"
+
+	checker := newDumpChecker(strings.NewReader(dump), model.DumpCheck{Prefix: "--", Suffix: "dump complete"})
+	read, err := io.ReadAll(checker)
+	assert.NoError(t, err)
+	assert.Equal(t, dump, string(read))
+	assert.NoError(t, checker.verify())
+
+	checker = newDumpChecker(strings.NewReader(dump[:len(dump)/2]), model.DumpCheck{Suffix: "dump complete"})
+	_, _ = io.ReadAll(checker)
+	assert.ErrorContains(t, checker.verify(), "expected completion marker")
+
+	checker = newDumpChecker(strings.NewReader("ERROR"), model.DumpCheck{Prefix: "REDIS"})
+	_, _ = io.ReadAll(checker)
+	assert.ErrorContains(t, checker.verify(), "expected header")
+}
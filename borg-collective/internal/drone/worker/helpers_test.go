@@ -20,7 +20,13 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"testing"
 
+	"github.com/vemilyus/borg-collective/internal/drone/borg"
+	"github.com/vemilyus/borg-collective/internal/drone/config"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
 	"github.com/vemilyus/borg-collective/internal/utils"
 )
 
@@ -43,3 +49,48 @@ func composeDown(project string) {
 	ctx := context.WithValue(context.Background(), "test", true)
 	_ = utils.Exec(ctx, []string{"docker", "compose", "-p", project, "down", "-v"})
 }
+
+// newFakeEngine returns a client connected to a fake Docker engine, which is
+// closed when the test finishes.
+func newFakeEngine(t *testing.T) (*docker.Client, *dockertest.Server) {
+	server := dockertest.NewServer()
+	t.Cleanup(server.Close)
+
+	dc, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return docker.NewClient(dc), server
+}
+
+// newMissingRepoClient returns a Borg client for a repository that doesn't
+// exist, so every backup fails.
+func newMissingRepoClient(t *testing.T) *borg.Client {
+	borgClient := &borg.Client{}
+	borgClient.SetConfig(config.Config{Repo: config.RepositoryConfig{Location: filepath.Join(t.TempDir(), "missing")}})
+
+	return borgClient
+}
+
+// jobsByKey returns the jobs keyed like the worker keys scheduled jobs.
+func jobsByKey(jobs ...*scheduledJob) map[jobKey]*scheduledJob {
+	result := make(map[jobKey]*scheduledJob, len(jobs))
+	for _, sj := range jobs {
+		result[sj.key()] = sj
+	}
+
+	return result
+}
+
+// jobNames returns the sorted names of the scheduled jobs of the worker.
+func jobNames(w *Worker) []string {
+	names := make([]string, 0, len(w.jobs))
+	for _, sj := range w.jobs {
+		names = append(names, sj.name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
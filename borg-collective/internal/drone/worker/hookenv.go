@@ -0,0 +1,225 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/borg/api"
+	"github.com/vemilyus/borg-collective/internal/utils"
+)
+
+// hookStatusRunning is the status passed to pre commands, post and finally
+// commands get the outcome of the backup.
+const hookStatusRunning = "running"
+
+// runResult collects the archives created during a backup run, so they can be
+// passed to hook commands. Archives are recorded by logBackupComplete through
+// the context, and are also added to the parent result, if any.
+type runResult struct {
+	mutex      sync.Mutex
+	parent     *runResult
+	name       string
+	repository string
+	tempDir    string
+	started    time.Time
+	archives   []hookArchive
+	items      []hookItem
+}
+
+type runResultKey struct{}
+
+// hookResult is written to the file passed to hook commands in
+// BORGD_RESULT_FILE.
+type hookResult struct {
+	BackupName string
+	Repository string
+	Status     string
+	Error      string `json:",omitempty"`
+	Started    time.Time
+	Duration   float64
+	Archives   []hookArchive
+	Items      []hookItem `json:",omitempty"`
+}
+
+type hookArchive struct {
+	Name  string
+	Stats *api.ArchiveStats `json:",omitempty"`
+}
+
+// hookItem is the outcome of a single exec or the volumes of a container,
+// named service/item.
+type hookItem struct {
+	Name   string
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+func newRunResult(ctx context.Context, name, repository, tempDir string) (context.Context, *runResult) {
+	result := &runResult{
+		name:       name,
+		repository: repository,
+		tempDir:    tempDir,
+		started:    time.Now(),
+		archives:   make([]hookArchive, 0, 1),
+	}
+
+	return context.WithValue(ctx, runResultKey{}, result), result
+}
+
+func (r *runResult) child(ctx context.Context, name string) (context.Context, *runResult) {
+	ctx, child := newRunResult(ctx, name, r.repository, r.tempDir)
+	child.parent = r
+
+	return ctx, child
+}
+
+func recordArchive(ctx context.Context, archive api.ArchiveInfo) {
+	result, ok := ctx.Value(runResultKey{}).(*runResult)
+	for ok && result != nil {
+		result.mutex.Lock()
+		result.archives = append(result.archives, hookArchive{Name: archive.Name, Stats: archive.Stats})
+		result.mutex.Unlock()
+
+		result = result.parent
+	}
+}
+
+func recordItem(ctx context.Context, name string, err error) {
+	item := hookItem{Name: name, Status: jobOutcome(err)}
+	if err != nil {
+		item.Error = err.Error()
+	}
+
+	result, ok := ctx.Value(runResultKey{}).(*runResult)
+	for ok && result != nil {
+		result.mutex.Lock()
+		result.items = append(result.items, item)
+		result.mutex.Unlock()
+
+		result = result.parent
+	}
+}
+
+func (r *runResult) report(status string, err error) hookResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := hookResult{
+		BackupName: r.name,
+		Repository: r.repository,
+		Status:     status,
+		Started:    r.started,
+		Duration:   time.Since(r.started).Seconds(),
+		Archives:   append([]hookArchive{}, r.archives...),
+		Items:      append([]hookItem(nil), r.items...),
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	return report
+}
+
+// env returns the variables passed to hook commands:
+//
+//   - BORGD_BACKUP_NAME: name of the static backup, container project or
+//     project-service
+//   - BORGD_ARCHIVE: names of the archives created so far, separated by spaces
+//   - BORGD_REPOSITORY: location of the repository
+//   - BORGD_STATUS: running for pre commands, otherwise success, failed,
+//     timeout or canceled
+//   - BORGD_ERROR: the error the backup failed with, if any
+//   - BORGD_DURATION: seconds since the backup was started
+//   - BORGD_ORIGINAL_SIZE, BORGD_COMPRESSED_SIZE, BORGD_DEDUPLICATED_SIZE and
+//     BORGD_NFILES: archive stats summed over all archives
+//
+// Commands run on the host additionally get BORGD_RESULT_FILE, the path of a
+// JSON file with the same information, which is removed afterward. For
+// container backups, it also has the outcome of every exec and the volumes of
+// each container.
+func (h hookResult) env() []string {
+	var archives []string
+	var stats api.ArchiveStats
+	for _, archive := range h.Archives {
+		archives = append(archives, archive.Name)
+		if archive.Stats != nil {
+			stats.OriginalSize += archive.Stats.OriginalSize
+			stats.CompressedSize += archive.Stats.CompressedSize
+			stats.DeduplicatedSize += archive.Stats.DeduplicatedSize
+			stats.Nfiles += archive.Stats.Nfiles
+		}
+	}
+
+	return []string{
+		"BORGD_BACKUP_NAME=" + h.BackupName,
+		"BORGD_ARCHIVE=" + strings.Join(archives, " "),
+		"BORGD_REPOSITORY=" + h.Repository,
+		"BORGD_STATUS=" + h.Status,
+		"BORGD_ERROR=" + h.Error,
+		"BORGD_DURATION=" + strconv.FormatInt(int64(h.Duration), 10),
+		"BORGD_ORIGINAL_SIZE=" + strconv.FormatInt(stats.OriginalSize, 10),
+		"BORGD_COMPRESSED_SIZE=" + strconv.FormatInt(stats.CompressedSize, 10),
+		"BORGD_DEDUPLICATED_SIZE=" + strconv.FormatInt(stats.DeduplicatedSize, 10),
+		"BORGD_NFILES=" + strconv.FormatInt(stats.Nfiles, 10),
+	}
+}
+
+// runHostHook runs the hook command on the host, with the result of the run
+// in its environment and written to the file in BORGD_RESULT_FILE.
+func (r *runResult) runHostHook(ctx context.Context, command []string, status string, err error) error {
+	report := r.report(status, err)
+	env := report.env()
+
+	resultFile, writeErr := writeHookResult(r.tempDir, report)
+	if writeErr != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(writeErr).
+			Str("backup", r.name).
+			Msg("failed to write hook result file")
+	} else {
+		defer func() { _ = os.Remove(resultFile) }()
+		env = append(env, "BORGD_RESULT_FILE="+resultFile)
+	}
+
+	return utils.ExecWithEnv(ctx, command, env)
+}
+
+func writeHookResult(tempDir string, report hookResult) (string, error) {
+	file, err := os.CreateTemp(tempDir, "borgd-result-*.json")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	err = json.NewEncoder(file).Encode(report)
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
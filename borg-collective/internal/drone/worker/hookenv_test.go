@@ -0,0 +1,76 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/borg/api"
+)
+
+func TestRunResult_HookEnvironment(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx, result := newRunResult(context.Background(), "project", "/repo", tempDir)
+
+	serviceCtx, serviceResult := result.child(ctx, "project-db")
+	recordArchive(serviceCtx, api.ArchiveInfo{Name: "project-db-1", Stats: &api.ArchiveStats{OriginalSize: 10, Nfiles: 2}})
+	recordArchive(ctx, api.ArchiveInfo{Name: "project-app-1", Stats: &api.ArchiveStats{OriginalSize: 5, Nfiles: 1}})
+
+	assert.Contains(t, serviceResult.report("success", nil).env(), "BORGD_ARCHIVE=project-db-1")
+
+	out := tempDir + "/out"
+	err := result.runHostHook(
+		ctx,
+		[]string{"sh", "-c", `env | grep ^BORGD_ | grep -v RESULT_FILE | grep -v DURATION | sort > "$0"; cp "$BORGD_RESULT_FILE" "$0.json"`, out},
+		"failed",
+		errors.New("boom"),
+	)
+	assert.NoError(t, err)
+
+	env, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		"BORGD_ARCHIVE=project-db-1 project-app-1\n"+
+			"BORGD_BACKUP_NAME=project\n"+
+			"BORGD_COMPRESSED_SIZE=0\n"+
+			"BORGD_DEDUPLICATED_SIZE=0\n"+
+			"BORGD_ERROR=boom\n"+
+			"BORGD_NFILES=3\n"+
+			"BORGD_ORIGINAL_SIZE=15\n"+
+			"BORGD_REPOSITORY=/repo\n"+
+			"BORGD_STATUS=failed\n",
+		string(env),
+	)
+
+	resultJson, err := os.ReadFile(out + ".json")
+	assert.NoError(t, err)
+
+	var report hookResult
+	assert.NoError(t, json.Unmarshal(resultJson, &report))
+	assert.Equal(t, "failed", report.Status)
+	assert.Equal(t, "boom", report.Error)
+	assert.Len(t, report.Archives, 2)
+
+	files, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2, "result file should be removed after the hook")
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// jitterSchedule delays every activation of the wrapped schedule by a fixed
+// offset.
+type jitterSchedule struct {
+	schedule cron.Schedule
+	offset   time.Duration
+}
+
+func (j jitterSchedule) Next(t time.Time) time.Time {
+	return j.schedule.Next(t.Add(-j.offset)).Add(j.offset)
+}
+
+// withJitter wraps the schedule with a start offset in [0, jitter). The offset
+// is derived from the hostname and the job name, so it is different between
+// hosts but stays the same across restarts of the same host.
+func withJitter(schedule cron.Schedule, hostname, name string, jitter time.Duration) jitterSchedule {
+	return jitterSchedule{
+		schedule: schedule,
+		offset:   jitterOffset(hostname, name, jitter),
+	}
+}
+
+func jitterOffset(hostname, name string, jitter time.Duration) time.Duration {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(hostname))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(name))
+
+	// only full seconds, since that is the resolution of the scheduler
+	seconds := uint64(jitter / time.Second)
+	if seconds == 0 {
+		return 0
+	}
+
+	return time.Duration(hash.Sum64()%seconds) * time.Second
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitterSchedule(t *testing.T) {
+	schedule, err := cron.ParseStandard("0 3 * * *")
+	assert.NoError(t, err)
+
+	// the offset is derived from the hostname and job name only
+	jitter := 30 * time.Minute
+	jittered := withJitter(schedule, "host-a", "some-backup", jitter)
+
+	assert.Equal(t, 11*time.Minute+16*time.Second, jittered.offset)
+	assert.Equal(t, jittered.offset, withJitter(schedule, "host-a", "some-backup", jitter).offset)
+
+	now := time.Date(2025, 6, 10, 3, 0, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2025, 6, 10, 3, 11, 16, 0, time.Local), jittered.Next(now))
+
+	next := jittered.Next(now)
+	assert.Equal(t, time.Date(2025, 6, 11, 3, 11, 16, 0, time.Local), jittered.Next(next))
+}
+
+func TestJitterOffset_DiffersBetweenHosts(t *testing.T) {
+	jitter := 24 * time.Hour
+
+	offsets := make(map[time.Duration]struct{})
+	for _, host := range []string{"host-a", "host-b", "host-c", "host-d"} {
+		offsets[jitterOffset(host, "some-backup", jitter)] = struct{}{}
+	}
+
+	assert.Greater(t, len(offsets), 1)
+	assert.Equal(t, time.Duration(0), jitterOffset("host-a", "some-backup", 500*time.Millisecond))
+}
@@ -0,0 +1,295 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// JobInfo describes a job known to the worker, as listed by ListJobs.
+type JobInfo struct {
+	Name     string
+	Kind     string
+	Priority int
+	Next     time.Time
+	Services []string
+}
+
+func (k jobKind) String() string {
+	switch k {
+	case jobKindCompaction:
+		return "compaction"
+	case jobKindStatic:
+		return "static"
+	case jobKindContainer:
+		return "container"
+	}
+
+	return "unknown"
+}
+
+// ListJobs returns all jobs that RunOnce would run without a selection,
+// sorted by name.
+func (w *Worker) ListJobs() []JobInfo {
+	w.schedulerMutex.Lock()
+	defer w.schedulerMutex.Unlock()
+
+	now := time.Now()
+	jobs := make([]JobInfo, 0, len(w.jobs)+1)
+	for _, sj := range w.onceJobs() {
+		info := JobInfo{
+			Name:     sj.name,
+			Kind:     sj.kind.String(),
+			Priority: sj.priority,
+		}
+
+		if sj.schedule != nil {
+			info.Next = sj.schedule.Next(now)
+		}
+
+		if cpbj, ok := sj.job.(*containerProjectBackupJob); ok {
+			for _, ctnr := range cpbj.plan {
+				info.Services = append(info.Services, ctnr.ServiceName)
+			}
+		}
+
+		jobs = append(jobs, info)
+	}
+
+	return jobs
+}
+
+// OnceOptions select the jobs run by RunOnce.
+type OnceOptions struct {
+	// Only selects jobs by their name, or a single service of a container
+	// project by project/service. Projects of engines other than Docker are
+	// named engine:project. A selected project includes its services with
+	// their own schedule. Without a selection every job is run.
+	Only []string
+	// SkipCompaction excludes compaction when running every job.
+	SkipCompaction bool
+	// IgnoreWindows runs jobs even outside their allowed windows.
+	IgnoreWindows bool
+}
+
+// RunOnce runs the selected jobs once and waits for them to finish. Jobs
+// outside their allowed windows aren't run, unless IgnoreWindows is set.
+// Selected jobs are run after their selected predecessors. Returns an error if
+// any of the selected jobs failed or wasn't run.
+func (w *Worker) RunOnce(opts OnceOptions) error {
+	defer w.ctxCancel()
+
+	w.schedulerMutex.Lock()
+	selected, err := w.selectOnceJobs(opts.Only, opts.SkipCompaction)
+	w.schedulerMutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Only) > 0 {
+		log.Info().Ctx(w.ctx).Strs("only", opts.Only).Msg("executing selected backup jobs once")
+	} else {
+		log.Info().Ctx(w.ctx).Msg("executing all backup jobs once")
+	}
+
+	// dependents are started by the jobs they run after, not by the queue
+	w.queue.onFinished = w.recordFinished
+
+	ordered := newOrderedOnceJobs(selected)
+	resultsMutex := sync.Mutex{}
+	repository := w.borgClient.RepositoryLocation()
+
+	// start must be called with the results mutex held
+	var start func()
+	start = func() {
+		for ready := ordered.ready(); len(ready) > 0; ready = ordered.ready() {
+			for _, sj := range ready {
+				if !opts.IgnoreWindows && !sj.windows.Allows(time.Now()) {
+					log.Warn().
+						Ctx(w.ctx).
+						Str("job", sj.name).
+						Msg("not running job outside of allowed window, use --ignore-windows to override")
+
+					ordered.results[sj.name] = errOutsideWindow
+					continue
+				}
+
+				w.queue.enqueue(sj.name, repository, sj.priority, &recordingJob{
+					workerJob: sj.job,
+					record: func(err error) {
+						resultsMutex.Lock()
+						defer resultsMutex.Unlock()
+
+						ordered.results[sj.name] = err
+						start()
+					},
+				})
+			}
+		}
+	}
+
+	resultsMutex.Lock()
+	start()
+	resultsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.queue.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-w.ctx.Done():
+		w.shutdown()
+		return fmt.Errorf("interrupted before all jobs finished: %w", context.Cause(w.ctx))
+	}
+
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+
+	var errs []error
+	for _, sj := range selected {
+		err, found := ordered.results[sj.name]
+		if !found {
+			err = errors.New("did not finish")
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sj.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// onceJobs returns all scheduled jobs and compaction, even if it isn't
+// scheduled, sorted by name. Must be called with the scheduler mutex held.
+func (w *Worker) onceJobs() []*scheduledJob {
+	jobs := slices.Collect(maps.Values(w.jobs))
+	if _, found := w.jobs[jobKey{kind: jobKindCompaction, name: compactionJobName}]; !found {
+		jobs = append(jobs, &scheduledJob{
+			name:     compactionJobName,
+			kind:     jobKindCompaction,
+			priority: compactionPriority,
+			job:      newRepoCompactionJob(w.borgClient),
+		})
+	}
+
+	slices.SortFunc(jobs, func(a, b *scheduledJob) int {
+		return cmp.Compare(a.name, b.name)
+	})
+
+	return jobs
+}
+
+// selectOnceJobs must be called with the scheduler mutex held.
+func (w *Worker) selectOnceJobs(only []string, skipCompaction bool) ([]*scheduledJob, error) {
+	all := w.onceJobs()
+	if len(only) == 0 {
+		if skipCompaction {
+			all = slices.DeleteFunc(all, func(sj *scheduledJob) bool { return sj.kind == jobKindCompaction })
+		}
+
+		return all, nil
+	}
+
+	byName := make(map[string]*scheduledJob, len(all))
+	for _, sj := range all {
+		byName[sj.name] = sj
+	}
+
+	services := make(map[string][]string)
+	for _, selection := range only {
+		if _, found := byName[selection]; found {
+			services[selection] = nil
+			continue
+		}
+
+		name, service, found := strings.Cut(selection, "/")
+		sj, known := byName[name]
+		if !found || !known {
+			return nil, fmt.Errorf("unknown job %s", selection)
+		}
+
+		if sj.kind != jobKindContainer {
+			return nil, fmt.Errorf("job %s has no services", name)
+		}
+
+		if selected, found := services[name]; !found || selected != nil {
+			services[name] = append(selected, service)
+		}
+	}
+
+	// services of a selected project that are backed up on their own schedule
+	// are selected along with it
+	for _, sj := range all {
+		if sj.kind != jobKindContainer {
+			continue
+		}
+
+		projectName := containerJobName(sj.engine, sj.project)
+		if selection, found := services[projectName]; found && selection == nil && sj.name != projectName {
+			services[sj.name] = nil
+		}
+	}
+
+	selected := make([]*scheduledJob, 0, len(services))
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		sj := byName[name]
+		if sj.kind == jobKindCompaction && skipCompaction {
+			continue
+		}
+
+		if services[name] != nil {
+			job, err := sj.job.(*containerProjectBackupJob).withServices(services[name])
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %w", name, err)
+			}
+
+			sjCopy := *sj
+			sjCopy.job = job
+			sj = &sjCopy
+		}
+
+		selected = append(selected, sj)
+	}
+
+	return selected, nil
+}
+
+type recordingJob struct {
+	workerJob
+	record func(err error)
+}
+
+func (r *recordingJob) Run(ctx context.Context) error {
+	err := r.workerJob.Run(ctx)
+	r.record(err)
+
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+func TestWorkerSelectOnceJobs(t *testing.T) {
+	db := model.ContainerBackup{ServiceName: "db", BackupVolumes: []model.Volume{{Source: "/db"}}}
+	app := model.ContainerBackup{ServiceName: "app", BackupVolumes: []model.Volume{{Source: "/app"}}}
+	web := model.ContainerBackup{ServiceName: "web"}
+
+	worker := Worker{
+		jobs: jobsByKey(
+			&scheduledJob{name: "static", kind: jobKindStatic, job: jobFunc(func() {})},
+			&scheduledJob{name: "project", kind: jobKindContainer, project: "project", job: &containerProjectBackupJob{
+				project: model.ContainerBackupProject{
+					ProjectName: "project",
+					Containers:  map[string]model.ContainerBackup{"db": db, "app": app, "web": web},
+				},
+				plan: containerPlan{app, db},
+			}},
+		),
+	}
+
+	names := func(jobs []*scheduledJob) []string {
+		result := make([]string, 0, len(jobs))
+		for _, sj := range jobs {
+			result = append(result, sj.name)
+		}
+
+		return result
+	}
+
+	selected, err := worker.selectOnceJobs(nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"compaction", "project", "static"}, names(selected))
+
+	selected, err = worker.selectOnceJobs(nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project", "static"}, names(selected))
+
+	selected, err = worker.selectOnceJobs([]string{"static"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"static"}, names(selected))
+
+	selected, err = worker.selectOnceJobs([]string{"project/db"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project"}, names(selected))
+	assert.Equal(t, containerPlan{db}, selected[0].job.(*containerProjectBackupJob).plan)
+	assert.Equal(t, containerPlan{app, db}, worker.jobNamed("project").job.(*containerProjectBackupJob).plan)
+
+	selected, err = worker.selectOnceJobs([]string{"project/db", "project"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, containerPlan{app, db}, selected[0].job.(*containerProjectBackupJob).plan)
+
+	_, err = worker.selectOnceJobs([]string{"unknown"}, false)
+	assert.EqualError(t, err, "unknown job unknown")
+
+	_, err = worker.selectOnceJobs([]string{"static/service"}, false)
+	assert.EqualError(t, err, "job static has no services")
+
+	_, err = worker.selectOnceJobs([]string{"project/cache"}, false)
+	assert.EqualError(t, err, "job project: unknown service cache")
+
+	_, err = worker.selectOnceJobs([]string{"project/web"}, false)
+	assert.EqualError(t, err, "job project: service web has nothing to back up")
+}
+
+func TestWorkerSelectOnceJobs_ScheduledServices(t *testing.T) {
+	db := model.ContainerBackup{ServiceName: "db", BackupVolumes: []model.Volume{{Source: "/db"}}}
+	app := model.ContainerBackup{ServiceName: "app", BackupVolumes: []model.Volume{{Source: "/app"}}}
+
+	worker := Worker{
+		jobs: jobsByKey(
+			&scheduledJob{name: "project", kind: jobKindContainer, project: "project", job: &containerProjectBackupJob{plan: containerPlan{app}}},
+			&scheduledJob{name: "project/db", kind: jobKindContainer, project: "project", job: &containerProjectBackupJob{plan: containerPlan{db}}},
+			&scheduledJob{name: "podman:project", kind: jobKindContainer, engine: model.ContainerEnginePodman, project: "project", job: &containerProjectBackupJob{plan: containerPlan{app}}},
+		),
+	}
+
+	names := func(jobs []*scheduledJob) []string {
+		result := make([]string, 0, len(jobs))
+		for _, sj := range jobs {
+			result = append(result, sj.name)
+		}
+
+		return result
+	}
+
+	// the service with its own schedule isn't skipped when the project is
+	// selected
+	selected, err := worker.selectOnceJobs([]string{"project"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project", "project/db"}, names(selected))
+
+	selected, err = worker.selectOnceJobs([]string{"project", "project/db"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project", "project/db"}, names(selected))
+
+	selected, err = worker.selectOnceJobs([]string{"project/db"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project/db"}, names(selected))
+
+	selected, err = worker.selectOnceJobs([]string{"podman:project"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"podman:project"}, names(selected))
+}
@@ -0,0 +1,444 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vemilyus/borg-collective/internal/drone/borg"
+	"github.com/vemilyus/borg-collective/internal/drone/config"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/drone/snapshot"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
+	"github.com/vemilyus/borg-collective/internal/utils"
+)
+
+// Plan describes what the worker would do, without actually doing it.
+type Plan struct {
+	Repository string
+	Options    PlanOptions
+	Jobs       []JobPlan
+}
+
+// PlanOptions are the effective global options.
+type PlanOptions struct {
+	StateDir            string
+	CatchUpDelay        config.Duration
+	Jitter              config.Duration
+	ShutdownGracePeriod config.Duration
+}
+
+// JobPlan describes the effective configuration of a job, merged from the
+// config file and container labels, and the steps it would take when run.
+type JobPlan struct {
+	Name     string
+	Kind     string
+	Priority int
+	CatchUp  bool
+	Jitter   config.Duration
+	Timeout  *config.Duration `json:",omitempty"`
+	Windows  *window.Windows  `json:",omitempty"`
+	After    []string         `json:",omitempty"`
+	Requires []string         `json:",omitempty"`
+	Next     *time.Time       `json:",omitempty"`
+	Backup   any              `json:",omitempty"`
+	Steps    []PlanStep
+}
+
+type PlanAction string
+
+const (
+	PlanActionRun      PlanAction = "run"
+	PlanActionStart    PlanAction = "start"
+	PlanActionStop     PlanAction = "stop"
+	PlanActionPause    PlanAction = "pause"
+	PlanActionUnpause  PlanAction = "unpause"
+	PlanActionSnapshot PlanAction = "snapshot"
+	PlanActionExec     PlanAction = "exec"
+	PlanActionBorg     PlanAction = "borg"
+	PlanActionRestore  PlanAction = "restore"
+)
+
+// PlanStep is a single step of a job. Command is run on the host for run and
+// borg, and inside Container for exec.
+type PlanStep struct {
+	Action    PlanAction
+	Container string   `json:",omitempty"`
+	Command   []string `json:",omitempty"`
+	Note      string   `json:",omitempty"`
+}
+
+type jobPlanner interface {
+	dryRun(ctx context.Context) []PlanStep
+}
+
+// Plan returns the plan for all scheduled jobs.
+func (w *Worker) Plan(cfg config.Config) Plan {
+	w.schedulerMutex.Lock()
+	defer w.schedulerMutex.Unlock()
+
+	jobs := slices.SortedFunc(maps.Values(w.jobs), func(a, b *scheduledJob) int {
+		return cmp.Compare(a.name, b.name)
+	})
+
+	return w.plan(cfg, jobs, true)
+}
+
+// PlanOnce returns the plan for the jobs RunOnce would run.
+func (w *Worker) PlanOnce(cfg config.Config, opts OnceOptions) (Plan, error) {
+	w.schedulerMutex.Lock()
+	defer w.schedulerMutex.Unlock()
+
+	jobs, err := w.selectOnceJobs(opts.Only, opts.SkipCompaction)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	return w.plan(cfg, jobs, false), nil
+}
+
+// plan must be called with the scheduler mutex held.
+func (w *Worker) plan(cfg config.Config, jobs []*scheduledJob, scheduled bool) Plan {
+	result := Plan{
+		Repository: cfg.Repo.Location,
+		Options: PlanOptions{
+			StateDir:            cfg.StateDir(),
+			CatchUpDelay:        config.Duration(cfg.CatchUpDelay()),
+			Jitter:              config.Duration(cfg.Jitter()),
+			ShutdownGracePeriod: config.Duration(cfg.ShutdownGracePeriod()),
+		},
+		Jobs: make([]JobPlan, 0, len(jobs)),
+	}
+
+	now := time.Now()
+	for _, sj := range jobs {
+		jobPlan := JobPlan{
+			Name:     sj.name,
+			Kind:     sj.kind.String(),
+			Priority: sj.priority,
+			CatchUp:  sj.catchUp,
+			Jitter:   config.Duration(w.jitter),
+			After:    sj.after,
+			Requires: sj.requires,
+		}
+
+		if sj.jitter != nil {
+			jobPlan.Jitter = config.Duration(*sj.jitter)
+		}
+
+		if !sj.windows.IsEmpty() {
+			windows := sj.windows
+			jobPlan.Windows = &windows
+		}
+
+		if scheduled && sj.schedule != nil {
+			next := sj.schedule.Next(now)
+			jobPlan.Next = &next
+		}
+
+		switch job := sj.job.(type) {
+		case *staticBackupJob:
+			jobPlan.Backup = job.backup
+			jobPlan.Timeout = job.backup.Timeout
+		case *containerProjectBackupJob:
+			jobPlan.Backup = job.project
+			if job.project.Timeout != nil {
+				timeout := config.Duration(*job.project.Timeout)
+				jobPlan.Timeout = &timeout
+			}
+		}
+
+		if planner, ok := sj.job.(jobPlanner); ok {
+			jobPlan.Steps = planner.dryRun(w.ctx)
+		}
+
+		result.Jobs = append(result.Jobs, jobPlan)
+	}
+
+	return result
+}
+
+func (c *compactionJob) dryRun(_ context.Context) []PlanStep {
+	return []PlanStep{{Action: PlanActionBorg, Command: c.borgClient.CompactCommandLine()}}
+}
+
+func (s staticBackupJob) dryRun(_ context.Context) []PlanStep {
+	steps := make([]PlanStep, 0, 5)
+	if len(s.backup.PreCommand) > 0 {
+		steps = append(steps, PlanStep{Action: PlanActionRun, Command: s.backup.PreCommand})
+	}
+
+	archiveName := utils.ArchiveName(s.backup.Name)
+	if s.backup.Exec != nil {
+		if s.backup.Exec.Stdout != nil && *s.backup.Exec.Stdout {
+			steps = append(
+				steps,
+				PlanStep{Action: PlanActionRun, Command: s.backup.Exec.Command, Note: "output is piped into borg"},
+				PlanStep{Action: PlanActionBorg, Command: s.borgClient.CreateCommandLine(archiveName, []string{"-"})},
+			)
+		} else {
+			steps = append(
+				steps,
+				PlanStep{Action: PlanActionRun, Command: s.backup.Exec.Command},
+				PlanStep{Action: PlanActionBorg, Command: s.borgClient.CreateCommandLine(archiveName, s.backup.Exec.Paths)},
+			)
+		}
+	} else if s.backup.Paths != nil {
+		if s.backup.Paths.Snapshot != "" {
+			steps = append(steps, snapshotStep(s.backup.Paths.Snapshot))
+		}
+
+		steps = append(steps, PlanStep{Action: PlanActionBorg, Command: s.borgClient.CreateCommandLine(archiveName, s.backup.Paths.Paths)})
+	}
+
+	if len(s.backup.PostCommand) > 0 {
+		steps = append(steps, PlanStep{Action: PlanActionRun, Command: s.backup.PostCommand, Note: "only if the backup succeeded"})
+	}
+
+	if len(s.backup.FinallyCommand) > 0 {
+		steps = append(steps, PlanStep{Action: PlanActionRun, Command: s.backup.FinallyCommand, Note: "always"})
+	}
+
+	return steps
+}
+
+func (d *containerProjectBackupJob) dryRun(ctx context.Context) []PlanStep {
+	current := *d
+	current.project, current.plan = d.tasks.current(d.project, d.plan)
+	d = &current
+
+	steps := hookSteps(d.project.Hooks, d.project.Hooks.Pre, "")
+	for _, backupCtnr := range d.plan {
+		backupName := fmt.Sprintf("%s-%s", d.project.ProjectName, backupCtnr.ServiceName)
+
+		steps = append(steps, hookSteps(backupCtnr.Hooks, backupCtnr.Hooks.Pre, "")...)
+
+		switch backupCtnr.Mode {
+		case model.BackupModeDefault:
+			steps = append(steps, PlanStep{Action: PlanActionStart, Container: backupCtnr.ServiceName})
+			steps = append(steps, containerSteps(PlanActionStart, d.findDependencies(backupCtnr))...)
+		case model.BackupModeDependentOffline:
+			steps = append(steps, PlanStep{Action: PlanActionStart, Container: backupCtnr.ServiceName})
+			steps = append(steps, containerSteps(PlanActionStart, d.findDependencies(backupCtnr))...)
+			steps = append(steps, containerSteps(PlanActionStop, d.findDependents(backupCtnr))...)
+		case model.BackupModeOffline:
+			steps = append(steps, PlanStep{Action: PlanActionStop, Container: backupCtnr.ServiceName})
+		case model.BackupModeDependentPaused:
+			steps = append(steps, PlanStep{Action: PlanActionStart, Container: backupCtnr.ServiceName})
+			steps = append(steps, containerSteps(PlanActionStart, d.findDependencies(backupCtnr))...)
+			steps = append(steps, containerSteps(PlanActionPause, d.findDependents(backupCtnr))...)
+		case model.BackupModePaused:
+			steps = append(steps, PlanStep{Action: PlanActionPause, Container: backupCtnr.ServiceName})
+		}
+
+		if backupCtnr.CombineArchives && backupCtnr.ItemCount() > 1 {
+			steps = append(steps, d.dryRunCombined(ctx, backupCtnr, utils.ArchiveName(backupName))...)
+		} else {
+			steps = append(steps, d.dryRunItems(ctx, backupCtnr, backupName)...)
+		}
+
+		if d.project.Retention != nil {
+			for _, baseName := range archiveBaseNames(backupCtnr, backupName) {
+				steps = append(steps, PlanStep{
+					Action:  PlanActionBorg,
+					Command: d.borgClient.PruneCommandLine(utils.ArchiveGlob(baseName), borg.KeepRules(*d.project.Retention)),
+					Note:    "only if the backup succeeded",
+				})
+			}
+		}
+
+		steps = append(steps, hookSteps(backupCtnr.Hooks, backupCtnr.Hooks.Post, "only if the backup succeeded")...)
+		steps = append(steps, hookSteps(backupCtnr.Hooks, backupCtnr.Hooks.Finally, "always")...)
+	}
+
+	steps = append(steps, PlanStep{
+		Action: PlanActionRestore,
+		Note:   "containers are returned to the state they were in before the backup",
+	})
+
+	steps = append(steps, hookSteps(d.project.Hooks, d.project.Hooks.Post, "only if the backup succeeded")...)
+
+	return append(steps, hookSteps(d.project.Hooks, d.project.Hooks.Finally, "always")...)
+}
+
+// releaseSteps returns the steps that end the downtime of the containers
+// stopped or paused for the backup, once the snapshots exist or the backup of
+// the container finished.
+func (d *containerProjectBackupJob) releaseSteps(backupCtnr model.ContainerBackup) []PlanStep {
+	switch backupCtnr.Mode {
+	case model.BackupModeDependentOffline:
+		return containerSteps(PlanActionStart, d.findDependents(backupCtnr))
+	case model.BackupModeOffline:
+		return []PlanStep{{Action: PlanActionStart, Container: backupCtnr.ServiceName, Note: "if it was running before"}}
+	case model.BackupModeDependentPaused:
+		return containerSteps(PlanActionUnpause, d.findDependents(backupCtnr))
+	case model.BackupModePaused:
+		return []PlanStep{{Action: PlanActionUnpause, Container: backupCtnr.ServiceName}}
+	}
+
+	return nil
+}
+
+func snapshotStep(kind snapshot.Kind) PlanStep {
+	return PlanStep{Action: PlanActionSnapshot, Note: string(kind) + ", archived under the original paths"}
+}
+
+// dryRunItems returns the steps backing up every item of the container into
+// its own archive.
+func (d *containerProjectBackupJob) dryRunItems(ctx context.Context, backupCtnr model.ContainerBackup, backupName string) []PlanStep {
+	var steps []PlanStep
+	for _, exec := range backupCtnr.Execs {
+		archiveName := utils.ArchiveName(itemBackupName(backupCtnr, backupName, exec.ItemName()))
+
+		execStep := d.execStep(ctx, backupCtnr, exec)
+		if exec.Stdout {
+			if execStep.Note == "" {
+				execStep.Note = "output is piped into borg"
+				if exec.Check != nil {
+					execStep.Note += " and checked for completeness"
+				}
+
+				if len(exec.Restore) > 0 {
+					execStep.Note += ", restore it with: " + strings.Join(exec.Restore, " ")
+				}
+			}
+
+			steps = append(steps, execStep, PlanStep{Action: PlanActionBorg, Command: d.borgClient.CreateCommandLine(archiveName, []string{"-"})})
+		} else {
+			steps = append(steps, execStep, d.execBorgStep(backupCtnr, exec, archiveName))
+		}
+	}
+
+	if len(backupCtnr.BackupVolumes) == 0 {
+		return append(steps, d.releaseSteps(backupCtnr)...)
+	}
+
+	archiveName := utils.ArchiveName(itemBackupName(backupCtnr, backupName, model.VolumesItem))
+	borgStep := PlanStep{Action: PlanActionBorg, Command: d.borgClient.CreateCommandLine(archiveName, volumeSources(backupCtnr))}
+
+	if backupCtnr.Snapshot != "" {
+		// stopped and paused containers are released once the snapshots exist
+		steps = append(steps, snapshotStep(backupCtnr.Snapshot))
+		steps = append(steps, d.releaseSteps(backupCtnr)...)
+
+		return append(steps, borgStep)
+	}
+
+	steps = append(steps, borgStep)
+
+	return append(steps, d.releaseSteps(backupCtnr)...)
+}
+
+// dryRunCombined returns the steps backing up all items of the container into
+// a single archive.
+func (d *containerProjectBackupJob) dryRunCombined(ctx context.Context, backupCtnr model.ContainerBackup, archiveName string) []PlanStep {
+	var steps []PlanStep
+	if backupCtnr.Snapshot != "" {
+		steps = append(steps, snapshotStep(backupCtnr.Snapshot))
+	}
+
+	paths := volumeSources(backupCtnr)
+	for _, exec := range backupCtnr.Execs {
+		execStep := d.execStep(ctx, backupCtnr, exec)
+		if exec.Stdout {
+			dumpName := exec.ItemName() + ".dump"
+			if execStep.Note == "" {
+				execStep.Note = "output is written to " + dumpName
+			}
+
+			paths = append(paths, dumpName)
+		} else if execPaths, onHost := execHostPaths(backupCtnr, exec); onHost {
+			paths = append(paths, execPaths...)
+		} else {
+			tarName := exec.ItemName() + ".tar"
+			if execStep.Note == "" {
+				execStep.Note = "paths are read through the archive API into " + tarName
+			}
+
+			paths = append(paths, tarName)
+		}
+
+		steps = append(steps, execStep)
+	}
+
+	borgStep := PlanStep{Action: PlanActionBorg, Command: d.borgClient.CreateCommandLine(archiveName, paths)}
+	if backupCtnr.Snapshot != "" {
+		steps = append(steps, d.releaseSteps(backupCtnr)...)
+
+		return append(steps, borgStep)
+	}
+
+	steps = append(steps, borgStep)
+
+	return append(steps, d.releaseSteps(backupCtnr)...)
+}
+
+func (d *containerProjectBackupJob) execStep(ctx context.Context, backupCtnr model.ContainerBackup, exec model.ContainerExecBackup) PlanStep {
+	step := PlanStep{Action: PlanActionExec, Container: backupCtnr.ServiceName, Command: exec.Command}
+	expanded, err := d.engine.DisplayCommand(ctx, backupCtnr.ID, exec.Command)
+	if err != nil {
+		step.Note = fmt.Sprintf("failed to expand command: %v", err)
+	} else {
+		step.Command = expanded
+	}
+
+	return step
+}
+
+// execBorgStep returns the step backing up the paths of an exec without
+// stdout.
+func (d *containerProjectBackupJob) execBorgStep(
+	backupCtnr model.ContainerBackup,
+	exec model.ContainerExecBackup,
+	archiveName string,
+) PlanStep {
+	if paths, onHost := execHostPaths(backupCtnr, exec); onHost {
+		return PlanStep{Action: PlanActionBorg, Command: d.borgClient.CreateCommandLine(archiveName, paths)}
+	}
+
+	return PlanStep{
+		Action:  PlanActionBorg,
+		Command: d.borgClient.ImportTarCommandLine(archiveName),
+		Note:    "paths are read through the archive API: " + strings.Join(exec.Paths, ", "),
+	}
+}
+
+func hookSteps(hooks model.Hooks, command []string, note string) []PlanStep {
+	if len(command) == 0 {
+		return nil
+	}
+
+	if hooks.Container == "" {
+		return []PlanStep{{Action: PlanActionRun, Command: command, Note: note}}
+	}
+
+	return []PlanStep{{Action: PlanActionExec, Container: hooks.Container, Command: command, Note: note}}
+}
+
+func containerSteps(action PlanAction, containers []model.ContainerBackup) []PlanStep {
+	steps := make([]PlanStep, 0, len(containers))
+	for _, ctnr := range containers {
+		steps = append(steps, PlanStep{Action: action, Container: ctnr.ServiceName})
+	}
+
+	return steps
+}
@@ -0,0 +1,300 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/config"
+)
+
+const (
+	compactionJobName  = config.CompactionName
+	compactionPriority = math.MinInt32
+)
+
+// jobQueue decouples the cron scheduler from the execution of backup jobs.
+// Jobs for the same repository are run one at a time, jobs with a higher
+// priority are started first and no more than concurrency jobs run at once.
+//
+// Jobs don't run within ctx directly, so that cancelling it doesn't abort
+// running jobs. They are only cancelled by shutdown once the grace period is
+// exceeded.
+type jobQueue struct {
+	ctx         context.Context
+	jobsCtx     context.Context
+	cancelJobs  context.CancelFunc
+	mutex       sync.Mutex
+	idle        *sync.Cond
+	concurrency int
+	seq         uint64
+	pending     []*queuedJob
+	running     map[string]*queuedJob
+	busyRepos   map[string]struct{}
+	onFinished  func(name string, err error)
+	closed      bool
+}
+
+type queuedJob struct {
+	name       string
+	repository string
+	priority   int
+	seq        uint64
+	enqueued   time.Time
+	job        workerJob
+}
+
+func newJobQueue(ctx context.Context, concurrency int) *jobQueue {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobsCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+
+	q := &jobQueue{
+		ctx:         ctx,
+		jobsCtx:     jobsCtx,
+		cancelJobs:  cancelJobs,
+		concurrency: concurrency,
+		pending:     make([]*queuedJob, 0),
+		running:     make(map[string]*queuedJob),
+		busyRepos:   make(map[string]struct{}),
+	}
+
+	q.idle = sync.NewCond(&q.mutex)
+
+	return q
+}
+
+// enqueue adds the job to the queue, unless a run of the same job is already
+// pending or still in progress. Returns whether the job was actually added.
+func (q *jobQueue) enqueue(name, repository string, priority int, job workerJob) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		log.Info().
+			Ctx(q.ctx).
+			Str("job", name).
+			Msg("skipping job run, shutting down")
+
+		return false
+	}
+
+	if slices.ContainsFunc(q.pending, func(qj *queuedJob) bool { return qj.name == name }) {
+		log.Info().
+			Ctx(q.ctx).
+			Str("job", name).
+			Msg("coalescing job run with already pending run")
+
+		return false
+	}
+
+	if _, found := q.running[name]; found {
+		log.Info().
+			Ctx(q.ctx).
+			Str("job", name).
+			Msg("skipping job run, previous run still in progress")
+
+		return false
+	}
+
+	q.seq++
+	q.pending = append(q.pending, &queuedJob{
+		name:       name,
+		repository: repository,
+		priority:   priority,
+		seq:        q.seq,
+		enqueued:   time.Now(),
+		job:        job,
+	})
+
+	log.Debug().
+		Ctx(q.ctx).
+		Str("job", name).
+		Str("repository", repository).
+		Int("priority", priority).
+		Int("pending", len(q.pending)).
+		Msg("enqueued job")
+
+	q.dispatch()
+
+	return true
+}
+
+// wait blocks until no jobs are pending or running anymore.
+func (q *jobQueue) wait() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.pending) > 0 || len(q.running) > 0 {
+		q.idle.Wait()
+	}
+}
+
+// shutdown stops accepting jobs and drops all pending ones, then waits for
+// running jobs to finish. Jobs still running after gracePeriod are cancelled
+// and waited for again. Returns the names of the dropped and cancelled jobs.
+func (q *jobQueue) shutdown(gracePeriod time.Duration) (dropped []string, cancelled []string) {
+	q.mutex.Lock()
+	q.closed = true
+
+	for _, qj := range q.pending {
+		dropped = append(dropped, qj.name)
+	}
+
+	q.pending = q.pending[:0]
+
+	running := make([]string, 0, len(q.running))
+	for name := range q.running {
+		running = append(running, name)
+	}
+
+	q.idle.Broadcast()
+	q.mutex.Unlock()
+
+	if len(running) > 0 {
+		slices.Sort(running)
+
+		log.Info().
+			Ctx(q.ctx).
+			Strs("jobs", running).
+			Dur("gracePeriod", gracePeriod).
+			Msg("waiting for running jobs to finish")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(gracePeriod):
+		q.mutex.Lock()
+		for name := range q.running {
+			cancelled = append(cancelled, name)
+		}
+		q.mutex.Unlock()
+
+		slices.Sort(cancelled)
+
+		log.Warn().
+			Ctx(q.ctx).
+			Strs("jobs", cancelled).
+			Msg("grace period exceeded, cancelling running jobs")
+
+		q.cancelJobs()
+		<-done
+	}
+
+	q.cancelJobs()
+
+	return dropped, cancelled
+}
+
+// dispatch starts as many pending jobs as allowed, must be called with the
+// mutex held.
+func (q *jobQueue) dispatch() {
+	if q.closed {
+		return
+	}
+
+	slices.SortStableFunc(q.pending, func(a, b *queuedJob) int {
+		if a.priority != b.priority {
+			return cmp.Compare(b.priority, a.priority)
+		}
+
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	remaining := make([]*queuedJob, 0, len(q.pending))
+	for _, qj := range q.pending {
+		_, repoBusy := q.busyRepos[qj.repository]
+		if repoBusy || len(q.running) >= q.concurrency {
+			remaining = append(remaining, qj)
+			continue
+		}
+
+		q.running[qj.name] = qj
+		q.busyRepos[qj.repository] = struct{}{}
+
+		go q.run(qj)
+	}
+
+	q.pending = remaining
+}
+
+func (q *jobQueue) run(qj *queuedJob) {
+	log.Debug().
+		Ctx(q.ctx).
+		Str("job", qj.name).
+		Str("repository", qj.repository).
+		Dur("waited", time.Since(qj.enqueued)).
+		Msg("starting job")
+
+	started := time.Now()
+	var err error
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+
+		if err != nil {
+			log.Warn().
+				Ctx(q.ctx).
+				Err(err).
+				Str("job", qj.name).
+				Str("outcome", jobOutcome(err)).
+				Dur("duration", time.Since(started)).
+				Msg("job failed")
+		} else {
+			log.Debug().
+				Ctx(q.ctx).
+				Str("job", qj.name).
+				Str("outcome", jobOutcome(err)).
+				Dur("duration", time.Since(started)).
+				Msg("job finished")
+		}
+
+		if q.onFinished != nil {
+			q.onFinished(qj.name, err)
+		}
+
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+
+		delete(q.running, qj.name)
+		delete(q.busyRepos, qj.repository)
+
+		q.dispatch()
+
+		if len(q.pending) == 0 && len(q.running) == 0 {
+			q.idle.Broadcast()
+		}
+	}()
+
+	err = qj.job.Run(q.jobsCtx)
+}
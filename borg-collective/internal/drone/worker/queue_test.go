@@ -0,0 +1,165 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type jobFunc func()
+
+func (f jobFunc) Run(_ context.Context) error {
+	f()
+	return nil
+}
+
+func TestJobQueue_SerializesPerRepository(t *testing.T) {
+	queue := newJobQueue(context.Background(), 4)
+
+	var active, maxActive atomic.Int32
+	job := jobFunc(func() {
+		current := active.Add(1)
+		for {
+			prev := maxActive.Load()
+			if current <= prev || maxActive.CompareAndSwap(prev, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+	})
+
+	assert.True(t, queue.enqueue("a", "repo", 0, job))
+	assert.True(t, queue.enqueue("b", "repo", 0, job))
+	assert.True(t, queue.enqueue("c", "repo", 0, job))
+
+	queue.wait()
+
+	assert.Equal(t, int32(1), maxActive.Load())
+}
+
+func TestJobQueue_GlobalConcurrency(t *testing.T) {
+	queue := newJobQueue(context.Background(), 2)
+
+	var active, maxActive atomic.Int32
+	job := jobFunc(func() {
+		current := active.Add(1)
+		for {
+			prev := maxActive.Load()
+			if current <= prev || maxActive.CompareAndSwap(prev, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+	})
+
+	for _, repo := range []string{"repo-1", "repo-2", "repo-3", "repo-4"} {
+		assert.True(t, queue.enqueue(repo, repo, 0, job))
+	}
+
+	queue.wait()
+
+	assert.Equal(t, int32(2), maxActive.Load())
+}
+
+func TestJobQueue_Priority(t *testing.T) {
+	queue := newJobQueue(context.Background(), 1)
+
+	block := make(chan struct{})
+	assert.True(t, queue.enqueue("blocker", "repo", 0, jobFunc(func() { <-block })))
+
+	orderMutex := sync.Mutex{}
+	order := make([]string, 0, 3)
+	record := func(name string) workerJob {
+		return jobFunc(func() {
+			orderMutex.Lock()
+			defer orderMutex.Unlock()
+
+			order = append(order, name)
+		})
+	}
+
+	assert.True(t, queue.enqueue("low", "repo", -1, record("low")))
+	assert.True(t, queue.enqueue("normal", "repo", 0, record("normal")))
+	assert.True(t, queue.enqueue("high", "repo", 10, record("high")))
+
+	close(block)
+	queue.wait()
+
+	assert.Equal(t, []string{"high", "normal", "low"}, order)
+}
+
+func TestJobQueue_CoalesceAndSkip(t *testing.T) {
+	queue := newJobQueue(context.Background(), 1)
+
+	block := make(chan struct{})
+	var runs atomic.Int32
+	job := jobFunc(func() {
+		runs.Add(1)
+		<-block
+	})
+
+	assert.True(t, queue.enqueue("running", "repo", 0, job))
+	assert.True(t, queue.enqueue("pending", "repo", 0, job))
+
+	assert.False(t, queue.enqueue("running", "repo", 0, job))
+	assert.False(t, queue.enqueue("pending", "repo", 0, job))
+
+	close(block)
+	queue.wait()
+
+	assert.Equal(t, int32(2), runs.Load())
+}
+
+func TestJobQueue_Shutdown(t *testing.T) {
+	queue := newJobQueue(context.Background(), 1)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	var pendingRuns atomic.Int32
+	assert.True(t, queue.enqueue("running", "repo", 0, jobCtxFunc(func(ctx context.Context) error {
+		select {
+		case <-block:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})))
+	assert.True(t, queue.enqueue("pending", "repo", 0, jobFunc(func() { pendingRuns.Add(1) })))
+
+	dropped, cancelled := queue.shutdown(20 * time.Millisecond)
+
+	assert.Equal(t, []string{"pending"}, dropped)
+	assert.Equal(t, []string{"running"}, cancelled)
+	assert.Equal(t, int32(0), pendingRuns.Load())
+	assert.False(t, queue.enqueue("late", "repo", 0, jobFunc(func() {})))
+}
+
+type jobCtxFunc func(ctx context.Context) error
+
+func (f jobCtxFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vemilyus/borg-collective/internal/drone/container"
+)
+
+// stateTrackingEngine remembers whether a container was running before it was
+// first started or stopped during a backup, so that the state from before the
+// backup can be restored afterward.
+type stateTrackingEngine struct {
+	container.Engine
+
+	mutex   sync.Mutex
+	initial map[string]bool
+}
+
+func newStateTrackingEngine(engine container.Engine) *stateTrackingEngine {
+	return &stateTrackingEngine{
+		Engine:  engine,
+		initial: make(map[string]bool),
+	}
+}
+
+func (e *stateTrackingEngine) EnsureContainerRunning(ctx context.Context, containerID string) error {
+	err := e.record(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	return e.Engine.EnsureContainerRunning(ctx, containerID)
+}
+
+func (e *stateTrackingEngine) EnsureContainerStopped(ctx context.Context, containerID string) error {
+	err := e.record(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	return e.Engine.EnsureContainerStopped(ctx, containerID)
+}
+
+func (e *stateTrackingEngine) record(ctx context.Context, containerID string) error {
+	e.mutex.Lock()
+	_, found := e.initial[containerID]
+	e.mutex.Unlock()
+
+	if found {
+		return nil
+	}
+
+	running, err := e.Engine.IsContainerRunning(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, found = e.initial[containerID]; !found {
+		e.initial[containerID] = running
+	}
+
+	return nil
+}
+
+// wasRunning returns whether the container was running before it was first
+// started or stopped.
+func (e *stateTrackingEngine) wasRunning(containerID string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.initial[containerID]
+}
+
+// initialStates returns the state of every container from before it was
+// first touched, keyed by container ID.
+func (e *stateTrackingEngine) initialStates() map[string]bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	states := make(map[string]bool, len(e.initial))
+	for id, running := range e.initial {
+		states[id] = running
+	}
+
+	return states
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const stateFileName = "state.json"
+
+// jobState persists information about past job runs across restarts. If the
+// state directory cannot be used, the state is only kept in memory.
+type jobState struct {
+	mutex sync.Mutex
+	dir   string
+	data  jobStateData
+}
+
+type jobStateData struct {
+	LastSuccess map[string]time.Time `json:"lastSuccess"`
+}
+
+func (s *jobState) load(ctx context.Context, dir string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.dir == dir && s.data.LastSuccess != nil {
+		return
+	}
+
+	s.dir = dir
+	s.data = jobStateData{LastSuccess: make(map[string]time.Time)}
+
+	path := filepath.Join(dir, stateFileName)
+	stateBytes, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().
+				Ctx(ctx).
+				Err(err).
+				Str("path", path).
+				Msg("failed to read job state")
+		}
+
+		return
+	}
+
+	var data jobStateData
+	err = json.Unmarshal(stateBytes, &data)
+	if err != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(err).
+			Str("path", path).
+			Msg("failed to parse job state, starting over")
+
+		return
+	}
+
+	if data.LastSuccess != nil {
+		s.data = data
+	}
+
+	log.Debug().
+		Ctx(ctx).
+		Str("path", path).
+		Int("jobs", len(s.data.LastSuccess)).
+		Msg("loaded job state")
+}
+
+func (s *jobState) lastSuccess(name string) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	last, found := s.data.LastSuccess[name]
+	return last, found
+}
+
+func (s *jobState) recordSuccess(name string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.LastSuccess == nil {
+		s.data.LastSuccess = make(map[string]time.Time)
+	}
+
+	s.data.LastSuccess[name] = at
+
+	if s.dir == "" {
+		return
+	}
+
+	err := s.persist()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("path", filepath.Join(s.dir, stateFileName)).
+			Msg("failed to persist job state")
+	}
+}
+
+// persist must be called with the mutex held.
+func (s *jobState) persist() error {
+	err := os.MkdirAll(s.dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	stateBytes, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(s.dir, stateFileName+".*")
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	_, err = tmpFile.Write(stateBytes)
+	if err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filepath.Join(s.dir, stateFileName))
+}
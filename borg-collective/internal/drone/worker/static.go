@@ -20,7 +20,6 @@ import (
 	"encoding/json"
 	"errors"
 
-	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
 	"github.com/vemilyus/borg-collective/internal/drone/borg"
 	"github.com/vemilyus/borg-collective/internal/drone/config"
@@ -28,17 +27,17 @@ import (
 )
 
 type staticBackupJob struct {
-	ctx        context.Context
 	borgClient *borg.Client
 	backup     config.BackupConfig
+	tempDir    string
 }
 
-func (w *Worker) newStaticBackupJob(backup config.BackupConfig) cron.Job {
-	return &staticBackupJob{w.ctx, w.borgClient, backup}
+func (w *Worker) newStaticBackupJob(backup config.BackupConfig) workerJob {
+	return &staticBackupJob{w.borgClient, backup, w.tempDir}
 }
 
-func (s staticBackupJob) Run() {
-	startEvent := log.Info().Ctx(s.ctx)
+func (s staticBackupJob) Run(ctx context.Context) error {
+	startEvent := log.Info().Ctx(ctx)
 	if config.Verbose {
 		backupJson, _ := json.Marshal(s.backup)
 		startEvent.RawJSON("backup", backupJson)
@@ -47,38 +46,50 @@ func (s staticBackupJob) Run() {
 	}
 	startEvent.Msg("starting static backup")
 
+	ctx, result := newRunResult(ctx, s.backup.Name, s.borgClient.RepositoryLocation(), s.tempDir)
+
+	// post and finally commands still run when the backup itself timed out
+	backupCtx := ctx
+	if s.backup.Timeout != nil && *s.backup.Timeout > 0 {
+		var cancel context.CancelFunc
+		backupCtx, cancel = context.WithTimeout(ctx, s.backup.Timeout.Duration())
+		defer cancel()
+	}
+
 	var err error
 	if len(s.backup.PreCommand) > 0 {
-		err = utils.Exec(s.ctx, s.backup.PreCommand)
+		err = result.runHostHook(backupCtx, s.backup.PreCommand, hookStatusRunning, nil)
 	}
 
 	if err == nil {
 		if s.backup.Exec != nil {
-			err = s.runExecBackup()
+			err = s.runExecBackup(backupCtx)
 		} else {
-			err = s.runPathsBackup()
+			err = s.runPathsBackup(backupCtx)
 		}
 	}
 
 	if err != nil {
 		log.Warn().
-			Ctx(s.ctx).
+			Ctx(ctx).
 			Err(err).
 			Str("backup", s.backup.Name).
 			Msg("backup failed")
 	} else if len(s.backup.PostCommand) > 0 {
-		_ = utils.Exec(s.ctx, s.backup.PostCommand)
+		_ = result.runHostHook(ctx, s.backup.PostCommand, jobOutcome(nil), nil)
 	}
 
 	if len(s.backup.FinallyCommand) > 0 {
-		_ = utils.Exec(s.ctx, s.backup.FinallyCommand)
+		_ = result.runHostHook(ctx, s.backup.FinallyCommand, jobOutcome(err), err)
 	}
+
+	return err
 }
 
-func (s staticBackupJob) runExecBackup() error {
+func (s staticBackupJob) runExecBackup(ctx context.Context) error {
 	if log.Debug().Enabled() {
 		log.Debug().
-			Ctx(s.ctx).
+			Ctx(ctx).
 			Str("backup", s.backup.Name).
 			Msg("backing up exec result")
 	}
@@ -88,44 +99,44 @@ func (s staticBackupJob) runExecBackup() error {
 	}
 
 	if s.backup.Exec.Stdout != nil && *s.backup.Exec.Stdout {
-		output, err := utils.ExecWithOutput(s.ctx, s.backup.Exec.Command)
+		output, err := utils.ExecWithOutput(ctx, s.backup.Exec.Command)
 		if err != nil {
 			return err
 		}
 
-		result, err := s.borgClient.CreateWithInput(s.ctx, utils.ArchiveName(s.backup.Name), output)
+		result, err := s.borgClient.CreateWithInput(ctx, utils.ArchiveName(s.backup.Name), output)
 		if err != nil {
 			return err
 		}
 
 		if output.Error() != nil {
 			log.Warn().
-				Ctx(s.ctx).
+				Ctx(ctx).
 				Err(output.Error()).
 				Msg("exec command failed, backup may be incomplete")
 		}
 
-		logBackupComplete(s.ctx, s.backup.Name, result)
+		logBackupComplete(ctx, s.backup.Name, result)
 	} else {
 		if len(s.backup.Exec.Paths) == 0 {
 			return errors.New("no paths configured")
 		}
 
-		err := utils.Exec(s.ctx, s.backup.Exec.Command)
+		err := utils.Exec(ctx, s.backup.Exec.Command)
 		if err != nil {
 			return err
 		}
 
-		return backupPaths(s.ctx, s.borgClient, s.backup.Name, s.backup.Exec.Paths)
+		return backupPaths(ctx, s.borgClient, s.backup.Name, s.backup.Exec.Paths)
 	}
 
 	return nil
 }
 
-func (s staticBackupJob) runPathsBackup() error {
+func (s staticBackupJob) runPathsBackup(ctx context.Context) error {
 	if log.Debug().Enabled() {
 		log.Debug().
-			Ctx(s.ctx).
+			Ctx(ctx).
 			Str("backup", s.backup.Name).
 			Msg("backing up static paths")
 	}
@@ -134,5 +145,9 @@ func (s staticBackupJob) runPathsBackup() error {
 		return errors.New("no paths configured")
 	}
 
-	return backupPaths(s.ctx, s.borgClient, s.backup.Name, s.backup.Paths.Paths)
+	if s.backup.Paths.Snapshot != "" {
+		return backupSnapshots(ctx, s.borgClient, s.tempDir, s.backup.Name, s.backup.Paths.Snapshot, s.backup.Paths.Paths, nil)
+	}
+
+	return backupPaths(ctx, s.borgClient, s.backup.Name, s.backup.Paths.Paths)
 }
@@ -0,0 +1,133 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"maps"
+	"reflect"
+	"sync"
+
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+// swarmTasks holds the latest version of a container project, it's shared by
+// the jobs of the project. Swarm replaces the containers of tasks, e.g. when
+// they are rescheduled, the jobs pick up the containers of the current tasks
+// on their next run instead of being scheduled again.
+type swarmTasks struct {
+	mutex   sync.Mutex
+	project model.ContainerBackupProject
+}
+
+func newSwarmTasks(project model.ContainerBackupProject) *swarmTasks {
+	return &swarmTasks{project: project}
+}
+
+// replace takes over the updated project if it only differs by the tasks
+// running its Swarm services, returns false if the project has to be
+// scheduled again.
+func (t *swarmTasks) replace(updated model.ContainerBackupProject) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !sameExceptTasks(t.project, updated) {
+		return false
+	}
+
+	t.project = updated
+
+	return true
+}
+
+// current returns the project and the plan with the containers of the current
+// tasks of their Swarm services.
+func (t *swarmTasks) current(
+	project model.ContainerBackupProject,
+	plan containerPlan,
+) (model.ContainerBackupProject, containerPlan) {
+	if t == nil {
+		return project, plan
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	project.Containers = maps.Clone(project.Containers)
+	for name, ctnr := range project.Containers {
+		if ctnr.SwarmTask {
+			project.Containers[name] = t.project.Containers[name]
+		}
+	}
+
+	current := make(containerPlan, 0, len(plan))
+	for _, ctnr := range plan {
+		if ctnr.SwarmTask {
+			ctnr = t.project.Containers[ctnr.ServiceName]
+		}
+
+		current = append(current, ctnr)
+	}
+
+	return project, current
+}
+
+// sameExceptTasks reports whether the projects only differ by the containers
+// of the tasks of the same Swarm services.
+func sameExceptTasks(a, b model.ContainerBackupProject) bool {
+	if len(a.Containers) != len(b.Containers) {
+		return false
+	}
+
+	a.Containers = maps.Clone(a.Containers)
+	b.Containers = maps.Clone(b.Containers)
+	for name, ctnr := range b.Containers {
+		prev, found := a.Containers[name]
+		if !found || ctnr.SwarmServiceID == "" || ctnr.SwarmServiceID != prev.SwarmServiceID {
+			continue
+		}
+
+		a.Containers[name] = withoutTask(prev)
+		b.Containers[name] = withoutTask(ctnr)
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// withoutTask clears what differs between the containers of the tasks of a
+// Swarm service.
+func withoutTask(ctnr model.ContainerBackup) model.ContainerBackup {
+	ctnr.ID = ""
+	ctnr.UpperDirPath = ""
+	ctnr.AllVolumes = withoutVolumeSources(ctnr.AllVolumes)
+	ctnr.BackupVolumes = withoutVolumeSources(ctnr.BackupVolumes)
+
+	return ctnr
+}
+
+// withoutVolumeSources clears the names and sources of the volumes, tasks get
+// new anonymous volumes.
+func withoutVolumeSources(volumes []model.Volume) []model.Volume {
+	result := make([]model.Volume, 0, len(volumes))
+	for _, vol := range volumes {
+		result = append(result, model.Volume{Type: vol.Type, Destination: vol.Destination})
+	}
+
+	return result
+}
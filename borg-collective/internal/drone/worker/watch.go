@@ -0,0 +1,227 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vemilyus/borg-collective/internal/drone/config"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+)
+
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+// superviseConfigWatch passes the updates of the config watch on. A failed
+// watch is recreated with exponential backoff, and the config is reloaded
+// afterward, in case it changed while it wasn't watched.
+func (w *Worker) superviseConfigWatch(watch *config.Watch, updates chan<- config.Config) {
+	backoff := w.watchBackoff
+	for {
+		err := w.forwardConfigWatch(watch, updates)
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		for {
+			log.Warn().
+				Ctx(w.ctx).
+				Err(err).
+				Dur("backoff", backoff).
+				Msg("config watch failed, recreating it")
+
+			if !w.sleep(backoff) {
+				return
+			}
+
+			backoff = min(2*backoff, maxWatchBackoff)
+
+			watch, err = config.NewWatch(w.ctx, w.configPath)
+			if err == nil {
+				break
+			}
+		}
+
+		backoff = w.watchBackoff
+
+		cfg, err := config.LoadConfig(w.configPath)
+		if err != nil {
+			// the watch publishes the config once it can be loaded again
+			log.Warn().Ctx(w.ctx).Err(err).Msg("failed to reload config file")
+			continue
+		}
+
+		select {
+		case updates <- *cfg:
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// forwardConfigWatch passes the updates of a config watch on, until it fails
+// or the worker is stopped. Returns the error the watch failed with.
+func (w *Worker) forwardConfigWatch(watch *config.Watch, updates chan<- config.Config) error {
+	for {
+		select {
+		case cfg := <-watch.Updates():
+			select {
+			case updates <- cfg:
+			case <-w.ctx.Done():
+				return nil
+			}
+		case err := <-watch.Errors():
+			return err
+		case <-w.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// superviseContainerWatch passes the updates of the container watch of an
+// engine on. When the watch ends, e.g. because the daemon was restarted, it is
+// reconnected with exponential backoff, and the projects are resynced to catch
+// up on the events missed in the meantime.
+func (w *Worker) superviseContainerWatch(cc *docker.Client, updates chan<- model.ContainerBackupProject) {
+	backoff := w.watchBackoff
+	resync := false
+	for {
+		watchCtx, cancel := context.WithCancel(w.ctx)
+		connected, err := w.watchContainers(watchCtx, cc, resync, updates)
+		cancel()
+
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		if connected {
+			backoff = w.watchBackoff
+		}
+
+		log.Warn().
+			Ctx(w.ctx).
+			Err(err).
+			Str("engine", string(cc.Engine())).
+			Dur("backoff", backoff).
+			Msg("container watch ended, reconnecting")
+
+		if !w.sleep(backoff) {
+			return
+		}
+
+		backoff = min(2*backoff, maxWatchBackoff)
+		resync = true
+	}
+}
+
+// watchContainers watches the containers of an engine and passes the updates
+// on, until the watch ends or the context is done. With resync, the projects
+// that changed while the engine wasn't watched are passed on first. Returns
+// whether the watch was established and the error that ended it.
+func (w *Worker) watchContainers(
+	ctx context.Context,
+	cc *docker.Client,
+	resync bool,
+	updates chan<- model.ContainerBackupProject,
+) (bool, error) {
+	watch, err := cc.Watch(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if resync {
+		// the watch is started first, so no event after the resync is missed
+		projects, err := cc.Resync(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		log.Info().
+			Ctx(ctx).
+			Str("engine", string(cc.Engine())).
+			Int("changed", len(projects)).
+			Msg("reconnected container watch")
+
+		for _, project := range projects {
+			select {
+			case updates <- project:
+			case <-ctx.Done():
+				return true, nil
+			}
+		}
+	}
+
+	return true, w.forwardContainerWatch(ctx, cc.Engine(), watch, updates)
+}
+
+// forwardContainerWatch passes the updates of a container engine watch on and
+// logs its errors, until the watch is closed or the context is done. Returns
+// the last error of the watch, which usually is the one that ended it.
+func (w *Worker) forwardContainerWatch(
+	ctx context.Context,
+	engine model.ContainerEngine,
+	watch *docker.Watch,
+	updates chan<- model.ContainerBackupProject,
+) error {
+	var lastErr error
+	for {
+		select {
+		case project, ok := <-watch.Updates():
+			if !ok {
+				return lastErr
+			}
+
+			select {
+			case updates <- project:
+			case <-ctx.Done():
+				return nil
+			}
+		case err, ok := <-watch.Errors():
+			if !ok {
+				return lastErr
+			}
+
+			log.Warn().
+				Ctx(ctx).
+				Err(err).
+				Str("engine", string(engine)).
+				Msg("error while watching containers")
+
+			lastErr = err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sleep waits for the duration, returns false if the worker was stopped in the
+// meantime.
+func (w *Worker) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
@@ -18,7 +18,11 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
@@ -26,44 +30,123 @@ import (
 	"github.com/vemilyus/borg-collective/internal/drone/config"
 	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
 	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
 )
 
 type Worker struct {
 	configPath     string
 	borgClient     *borg.Client
-	dockerClient   *docker.Client
+	containers     map[model.ContainerEngine]*docker.Client
 	scheduler      *cron.Cron
 	schedulerMutex sync.Mutex
+	queue          *jobQueue
+	state          *jobState
 	ctx            context.Context
 	ctxCancel      context.CancelFunc
-	compactJobId   cron.EntryID
-	staticJobIds   []cron.EntryID
-	dockerJobIds   map[string]cron.EntryID
+	jobs           map[jobKey]*scheduledJob
+	hostname       string
+	jitter         time.Duration
+	delayMutex     sync.Mutex
+	catchUpDelay   time.Duration
+	delayTimers    map[string]*time.Timer
+	finished       map[string]map[string]bool
+	gracePeriod    time.Duration
+	tempDir        string
+	watchBackoff   time.Duration
+}
+
+type jobKind uint8
+
+const (
+	jobKindCompaction jobKind = 1 + iota
+	jobKindStatic
+	jobKindContainer
+)
+
+// jobKey identifies a scheduled job. Container projects of different engines
+// can have the same name.
+type jobKey struct {
+	kind   jobKind
+	engine model.ContainerEngine
+	name   string
+}
+
+type scheduledJob struct {
+	// name is unique among all jobs, it is used to queue the job, record its
+	// runs and to refer to it in After, Requires and run-once selections
+	name   string
+	kind   jobKind
+	engine model.ContainerEngine
+	// project is the name of the project of container jobs, services with
+	// their own schedule are separate jobs of the same project
+	project  string
+	entryId  cron.EntryID
+	schedule cron.Schedule
+	priority int
+	catchUp  bool
+	jitter   *time.Duration
+	windows  window.Windows
+	after    []string
+	requires []string
+	job      workerJob
+}
+
+func (sj *scheduledJob) key() jobKey {
+	return jobKey{kind: sj.kind, engine: sj.engine, name: sj.name}
+}
+
+// containerJobName returns the name of the job of a container project or of
+// one of its services. Docker projects are named like the project, for
+// compatibility with previously recorded runs and references, projects of
+// other engines are prefixed with the engine.
+func containerJobName(engine model.ContainerEngine, name string) string {
+	if engine == "" || engine == model.ContainerEngineDocker {
+		return name
+	}
+
+	return string(engine) + ":" + name
 }
 
 func NewWorker(
 	parentCtx context.Context,
 	configPath string,
 	borgClient *borg.Client,
-	dockerClient *docker.Client,
+	containerClients []*docker.Client,
 	scheduler *cron.Cron,
 ) *Worker {
 	if parentCtx == nil {
 		parentCtx = context.Background()
 	}
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to determine hostname")
+	}
+
 	wCtx, cancel := context.WithCancel(parentCtx)
 	s := &Worker{
 		configPath:   configPath,
 		borgClient:   borgClient,
-		dockerClient: dockerClient,
+		containers:   make(map[model.ContainerEngine]*docker.Client, len(containerClients)),
 		scheduler:    scheduler,
+		queue:        newJobQueue(wCtx, 1),
+		state:        &jobState{},
 		ctx:          wCtx,
 		ctxCancel:    cancel,
-		staticJobIds: make([]cron.EntryID, 0),
-		dockerJobIds: make(map[string]cron.EntryID),
+		jobs:         make(map[jobKey]*scheduledJob),
+		hostname:     hostname,
+		delayTimers:  make(map[string]*time.Timer),
+		finished:     make(map[string]map[string]bool),
+		gracePeriod:  time.Minute,
+		watchBackoff: minWatchBackoff,
 	}
 
+	for _, cc := range containerClients {
+		s.containers[cc.Engine()] = cc
+	}
+
+	s.queue.onFinished = s.jobFinished
+
 	return s
 }
 
@@ -75,91 +158,124 @@ func (w *Worker) Run() error {
 		return err
 	}
 
-	var dockerUpdates <-chan model.ContainerBackupProject
-	var dockerErrors <-chan error
-	if w.dockerClient != nil {
-		dockerWatch, err := w.dockerClient.Watch(w.ctx)
-		if err != nil {
-			return err
-		}
-
-		defer func() { _ = dockerWatch.Close() }()
+	// watches are supervised, so the scheduler keeps running while they are
+	// reconnected
+	configUpdates := make(chan config.Config)
+	go w.superviseConfigWatch(configWatch, configUpdates)
 
-		dockerUpdates = dockerWatch.Updates()
-		dockerErrors = dockerWatch.Errors()
+	containerUpdates := make(chan model.ContainerBackupProject)
+	for _, cc := range w.containers {
+		go w.superviseContainerWatch(cc, containerUpdates)
 	}
 
 	log.Info().Ctx(w.ctx).Msg("starting cron scheduler")
 
 	w.scheduler.Start()
-	defer w.scheduler.Stop()
+	defer w.shutdown()
+
+	w.catchUp()
+	go w.watchResume()
 
 	for {
 		select {
-		case cfg := <-configWatch.Updates():
+		case cfg := <-configUpdates:
 			w.borgClient.SetConfig(cfg)
+			w.Configure(cfg)
 			w.ScheduleRepoCompaction(cfg)
 			w.ScheduleStaticBackups(cfg.Backups)
-		case err = <-configWatch.Errors():
-			return err
-		case proj := <-dockerUpdates:
-			if proj.Engine == model.ContainerEngineDocker {
-				err = w.scheduleDockerBackup(proj)
-				if err != nil {
-					log.Warn().
-						Ctx(w.ctx).
-						Err(err).
-						Msg("failed to schedule Docker backup project")
-				}
+		case proj := <-containerUpdates:
+			err = w.scheduleContainerBackup(proj)
+			if err != nil {
+				log.Warn().
+					Ctx(w.ctx).
+					Err(err).
+					Str("engine", string(proj.Engine)).
+					Msg("failed to schedule container backup project")
 			}
-		case err = <-dockerErrors:
-			return err
 		case <-w.ctx.Done():
 			return nil
 		}
 	}
 }
 
-func (w *Worker) RunOnce() error {
-	defer w.ctxCancel()
+// shutdown stops scheduling new job runs and gives running jobs the
+// configured grace period to finish, before cancelling them.
+func (w *Worker) shutdown() {
+	log.Info().Ctx(w.ctx).Msg("shutting down, no new jobs will be started")
+
+	<-w.scheduler.Stop().Done()
+	w.stopDelayedRuns()
+
+	w.schedulerMutex.Lock()
+	gracePeriod := w.gracePeriod
+	w.schedulerMutex.Unlock()
 
-	log.Info().Ctx(w.ctx).Msg("executing all backup jobs once")
+	dropped, cancelled := w.queue.shutdown(gracePeriod)
 
-	for _, entry := range w.scheduler.Entries() {
-		entry.WrappedJob.Run()
+	event := log.Info()
+	if len(cancelled) > 0 {
+		event = log.Warn()
 	}
 
-	_ = w.borgClient.Compact()
+	event.
+		Ctx(w.ctx).
+		Strs("dropped", dropped).
+		Strs("cancelled", cancelled).
+		Msg("shutdown complete")
+}
 
-	return nil
+func (w *Worker) Configure(cfg config.Config) {
+	w.delayMutex.Lock()
+	w.catchUpDelay = cfg.CatchUpDelay()
+	w.delayMutex.Unlock()
+
+	w.schedulerMutex.Lock()
+	w.jitter = cfg.Jitter()
+	w.gracePeriod = cfg.ShutdownGracePeriod()
+	w.tempDir = cfg.TempDir()
+	w.schedulerMutex.Unlock()
+
+	w.state.load(w.ctx, cfg.StateDir())
 }
 
 func (w *Worker) ScheduleRepoCompaction(cfg config.Config) {
 	w.schedulerMutex.Lock()
 	defer w.schedulerMutex.Unlock()
 
-	if w.compactJobId != 0 {
-		w.scheduler.Remove(w.compactJobId)
-		w.compactJobId = 0
-	}
+	w.unscheduleKind(jobKindCompaction)
 
 	compactionSchedule := cfg.Repo.CompactionSchedule()
-	if compactionSchedule != nil {
-		w.compactJobId = w.scheduler.Schedule(compactionSchedule, newRepoCompactionJob(w.borgClient))
+	if compactionSchedule != nil || len(cfg.Repo.CompactionAfter) > 0 || len(cfg.Repo.CompactionRequires) > 0 {
+		w.schedule(&scheduledJob{
+			name:     compactionJobName,
+			kind:     jobKindCompaction,
+			schedule: compactionSchedule,
+			priority: compactionPriority,
+			after:    cfg.Repo.CompactionAfter,
+			requires: cfg.Repo.CompactionRequires,
+			job:      newRepoCompactionJob(w.borgClient),
+		})
 	}
+
+	w.breakDependencyCycles()
 }
 
 func (w *Worker) ScheduleStaticBackups(backups []config.BackupConfig) {
 	w.schedulerMutex.Lock()
 	defer w.schedulerMutex.Unlock()
 
-	for _, jobId := range w.staticJobIds[:] {
-		w.scheduler.Remove(jobId)
-	}
-
-	w.staticJobIds = make([]cron.EntryID, 0)
+	w.unscheduleKind(jobKindStatic)
 
 	for _, backup := range backups {
+		if w.isProjectScheduled(backup.Name) {
+			log.Error().
+				Ctx(w.ctx).
+				Str("backup", backup.Name).
+				Msg("not scheduling static backup, its name is already used by a container backup project")
+
+			continue
+		}
+
 		job := w.newStaticBackupJob(backup)
 
 		backupJson, _ := json.Marshal(backup)
@@ -168,40 +284,68 @@ func (w *Worker) ScheduleStaticBackups(backups []config.BackupConfig) {
 			RawJSON("backup", backupJson).
 			Msg("scheduling static backup")
 
-		jobId := w.scheduler.Schedule(backup.Schedule(), job)
-		w.staticJobIds = append(w.staticJobIds, jobId)
+		w.schedule(&scheduledJob{
+			name:     backup.Name,
+			kind:     jobKindStatic,
+			schedule: backup.Schedule(),
+			priority: backup.Priority,
+			catchUp:  backup.CatchUp,
+			jitter:   (*time.Duration)(backup.Jitter),
+			windows:  backup.Windows(),
+			after:    backup.After,
+			requires: backup.Requires,
+			job:      job,
+		})
 	}
+
+	w.breakDependencyCycles()
 }
 
 func (w *Worker) ScheduleContainerBackups(backups []model.ContainerBackupProject) error {
 	for _, cbp := range backups {
-		if cbp.Engine == model.ContainerEngineDocker {
-			err := w.scheduleDockerBackup(cbp)
-			if err != nil {
-				return err
-			}
+		err := w.scheduleContainerBackup(cbp)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (w *Worker) scheduleDockerBackup(cbp model.ContainerBackupProject) error {
+func (w *Worker) scheduleContainerBackup(cbp model.ContainerBackupProject) error {
 	w.schedulerMutex.Lock()
 	defer w.schedulerMutex.Unlock()
 
-	jobId, found := w.dockerJobIds[cbp.ProjectName]
-	if found {
+	projectName := containerJobName(cbp.Engine, cbp.ProjectName)
+	if previous, found := w.jobs[jobKey{kind: jobKindContainer, engine: cbp.Engine, name: projectName}]; found {
+		if projectJob, ok := previous.job.(*containerProjectBackupJob); ok && projectJob.tasks.replace(cbp) {
+			log.Info().
+				Ctx(w.ctx).
+				Str("engine", string(cbp.Engine)).
+				Str("projectName", cbp.ProjectName).
+				Msg("swarm tasks of container backup project replaced, keeping its schedule")
+
+			return nil
+		}
+
 		log.Info().
 			Ctx(w.ctx).
+			Str("engine", string(cbp.Engine)).
 			Str("projectName", cbp.ProjectName).
 			Msg("unscheduling container backup project")
 
-		w.scheduler.Remove(jobId)
-		delete(w.dockerJobIds, cbp.ProjectName)
+		w.unscheduleProject(cbp.Engine, cbp.ProjectName)
 	}
 
 	if len(cbp.Containers) > 0 {
+		if cbp.ProjectName == compactionJobName {
+			return fmt.Errorf("project name %s is reserved for repository compaction", cbp.ProjectName)
+		}
+
+		if _, found := w.jobs[jobKey{kind: jobKindStatic, name: cbp.ProjectName}]; found {
+			return fmt.Errorf("project name %s is already used by a static backup", cbp.ProjectName)
+		}
+
 		job, err := w.newContainerProjectBackupJob(cbp)
 		if err != nil {
 			return err
@@ -213,9 +357,197 @@ func (w *Worker) scheduleDockerBackup(cbp model.ContainerBackupProject) error {
 			RawJSON("project", cbpJson).
 			Msg("scheduling container backup project")
 
-		jobId = w.scheduler.Schedule(cbp.Schedule, job)
-		w.dockerJobIds[cbp.ProjectName] = jobId
+		projectJob := job.(*containerProjectBackupJob)
+		for _, backupCtnr := range projectJob.plan {
+			if backupCtnr.Schedule == nil {
+				continue
+			}
+
+			serviceJob, err := projectJob.withServices([]string{backupCtnr.ServiceName})
+			if err != nil {
+				w.unscheduleProject(cbp.Engine, cbp.ProjectName)
+				return err
+			}
+
+			log.Info().
+				Ctx(w.ctx).
+				Str("projectName", cbp.ProjectName).
+				Str("service", backupCtnr.ServiceName).
+				Msg("scheduling container backup service on its own schedule")
+
+			w.schedule(&scheduledJob{
+				name:     projectName + "/" + backupCtnr.ServiceName,
+				kind:     jobKindContainer,
+				engine:   cbp.Engine,
+				project:  cbp.ProjectName,
+				schedule: backupCtnr.Schedule,
+				priority: cbp.Priority,
+				catchUp:  cbp.CatchUp,
+				jitter:   cbp.Jitter,
+				windows:  cbp.Windows,
+				job:      serviceJob,
+			})
+		}
+
+		w.schedule(&scheduledJob{
+			name:     projectName,
+			kind:     jobKindContainer,
+			engine:   cbp.Engine,
+			project:  cbp.ProjectName,
+			schedule: cbp.Schedule,
+			priority: cbp.Priority,
+			catchUp:  cbp.CatchUp,
+			jitter:   cbp.Jitter,
+			windows:  cbp.Windows,
+			after:    cbp.After,
+			requires: cbp.Requires,
+			job:      projectJob.withoutScheduledServices(),
+		})
+
+		if cycle := w.dependencyCycle(); cycle != nil {
+			w.unscheduleProject(cbp.Engine, cbp.ProjectName)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	return nil
+}
+
+// schedule must be called with the scheduler mutex held. Jobs without a
+// schedule are only run after their predecessors. A job scheduled before with
+// the same key is replaced.
+func (w *Worker) schedule(sj *scheduledJob) {
+	if previous, found := w.jobs[sj.key()]; found {
+		w.unschedule(previous)
+	}
+
+	w.jobs[sj.key()] = sj
+
+	if sj.schedule == nil {
+		return
+	}
+
+	jitter := w.jitter
+	if sj.jitter != nil {
+		jitter = *sj.jitter
+	}
+
+	if jitter > 0 {
+		jittered := withJitter(sj.schedule, w.hostname, sj.name, jitter)
+		sj.schedule = jittered
+
+		log.Debug().
+			Ctx(w.ctx).
+			Str("job", sj.name).
+			Dur("offset", jittered.offset).
+			Msg("delaying job start by jitter offset")
+	}
+
+	sj.entryId = w.scheduler.Schedule(sj.schedule, cron.FuncJob(func() { w.enqueue(sj) }))
+}
+
+// unschedule must be called with the scheduler mutex held.
+func (w *Worker) unschedule(sj *scheduledJob) {
+	if sj.schedule != nil {
+		w.scheduler.Remove(sj.entryId)
+	}
+
+	delete(w.jobs, sj.key())
+}
+
+// unscheduleProject unschedules the container project of the engine and the
+// services with their own schedule, must be called with the scheduler mutex
+// held.
+func (w *Worker) unscheduleProject(engine model.ContainerEngine, projectName string) {
+	for _, sj := range w.jobs {
+		if sj.kind == jobKindContainer && sj.engine == engine && sj.project == projectName {
+			w.unschedule(sj)
+		}
+	}
+}
+
+// jobNamed returns the job with the given name, or nil if there is none. Must
+// be called with the scheduler mutex held.
+func (w *Worker) jobNamed(name string) *scheduledJob {
+	for _, sj := range w.jobs {
+		if sj.name == name {
+			return sj
+		}
 	}
 
 	return nil
 }
+
+// isProjectScheduled returns whether a container project of any engine with
+// the given name is scheduled. Must be called with the scheduler mutex held.
+func (w *Worker) isProjectScheduled(projectName string) bool {
+	for _, sj := range w.jobs {
+		if sj.kind == jobKindContainer && sj.project == projectName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unscheduleKind must be called with the scheduler mutex held.
+func (w *Worker) unscheduleKind(kind jobKind) {
+	for _, sj := range w.jobs {
+		if sj.kind == kind {
+			w.unschedule(sj)
+		}
+	}
+}
+
+// enqueue adds the job to the queue if it is due within an allowed window.
+// Otherwise the run is deferred to the start of the next allowed window or
+// skipped, depending on the window policy of the job.
+func (w *Worker) enqueue(sj *scheduledJob) bool {
+	now := time.Now()
+	if !sj.windows.Allows(now) {
+		w.outsideWindow(sj, now)
+		return false
+	}
+
+	return w.queue.enqueue(sj.name, w.borgClient.RepositoryLocation(), sj.priority, sj.job)
+}
+
+func (w *Worker) outsideWindow(sj *scheduledJob, now time.Time) {
+	if sj.windows.Policy == window.PolicySkip {
+		log.Info().
+			Ctx(w.ctx).
+			Str("job", sj.name).
+			Msg("skipping job run outside of allowed window")
+
+		return
+	}
+
+	next := sj.windows.Next(now)
+	if next.IsZero() {
+		log.Warn().
+			Ctx(w.ctx).
+			Str("job", sj.name).
+			Msg("skipping job run, no allowed window within the next week")
+
+		return
+	}
+
+	log.Info().
+		Ctx(w.ctx).
+		Str("job", sj.name).
+		Time("next", next).
+		Msg("deferring job run to next allowed window")
+
+	w.enqueueAfter(sj.name, next.Sub(now))
+}
+
+func (w *Worker) jobFinished(name string, err error) {
+	w.recordFinished(name, err)
+	w.runDependents(name, err)
+}
+
+func (w *Worker) recordFinished(name string, err error) {
+	if err == nil {
+		w.state.recordSuccess(name, time.Now())
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (C) 2025 Alex Katlein
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/vemilyus/borg-collective/internal/drone/config"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker"
+	"github.com/vemilyus/borg-collective/internal/drone/container/docker/dockertest"
+	"github.com/vemilyus/borg-collective/internal/drone/container/model"
+	"github.com/vemilyus/borg-collective/internal/drone/window"
+)
+
+func TestWorkerOutsideWindow(t *testing.T) {
+	now := time.Now()
+	blackout := fmt.Sprintf("%s-%s", now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+
+	newWorker := func(policy string) (*Worker, *scheduledJob) {
+		windows, err := window.ParseWindows(nil, []string{blackout}, policy)
+		assert.NoError(t, err)
+		assert.False(t, windows.Allows(now))
+
+		sj := &scheduledJob{name: "backup", windows: windows, job: jobFunc(func() {})}
+
+		return &Worker{
+			ctx:         context.Background(),
+			jobs:        jobsByKey(sj),
+			delayTimers: make(map[string]*time.Timer),
+		}, sj
+	}
+
+	worker, sj := newWorker("skip")
+	worker.outsideWindow(sj, now)
+	assert.Empty(t, worker.delayTimers)
+
+	worker, sj = newWorker("defer")
+	worker.outsideWindow(sj, now)
+	assert.Contains(t, worker.delayTimers, "backup")
+	worker.stopDelayedRuns()
+
+	windows, err := window.ParseWindows(nil, []string{"00:00-24:00"}, "defer")
+	assert.NoError(t, err)
+
+	worker, sj = newWorker("defer")
+	sj.windows = windows
+	worker.outsideWindow(sj, now)
+	assert.Empty(t, worker.delayTimers)
+}
+
+func TestWorkerScheduleContainerBackup_ServiceSchedules(t *testing.T) {
+	engine, _ := newFakeEngine(t)
+	worker := &Worker{
+		ctx:        context.Background(),
+		borgClient: newMissingRepoClient(t),
+		containers: map[model.ContainerEngine]*docker.Client{model.ContainerEngineDocker: engine},
+		scheduler:  cron.New(),
+		jobs:       make(map[jobKey]*scheduledJob),
+	}
+
+	hourly, err := cron.ParseStandard("@hourly")
+	assert.NoError(t, err)
+
+	nightly, err := cron.ParseStandard("0 3 * * *")
+	assert.NoError(t, err)
+
+	project := model.ContainerBackupProject{
+		Engine:      model.ContainerEngineDocker,
+		ProjectName: "paperless",
+		Schedule:    nightly,
+		Containers: map[string]model.ContainerBackup{
+			"db": {
+				ServiceName: "db",
+				Schedule:    hourly,
+				Mode:        model.BackupModeDependentPaused,
+				Execs:       []model.ContainerExecBackup{{Command: []string{"pg_dumpall"}, Stdout: true}},
+			},
+			"server": {
+				ServiceName:   "server",
+				Mode:          model.BackupModeDefault,
+				Dependencies:  []string{"db"},
+				BackupVolumes: []model.Volume{{Source: "/media"}},
+			},
+		},
+	}
+
+	assert.NoError(t, worker.scheduleContainerBackup(project))
+	assert.Equal(t, []string{"paperless", "paperless/db"}, jobNames(worker))
+
+	services := func(name string) []string {
+		var result []string
+		for _, ctnr := range worker.jobNamed(name).job.(*containerProjectBackupJob).plan {
+			result = append(result, ctnr.ServiceName)
+		}
+
+		return result
+	}
+
+	assert.Equal(t, []string{"server"}, services("paperless"))
+	assert.Equal(t, []string{"db"}, services("paperless/db"))
+	assert.Equal(t, hourly, worker.jobNamed("paperless/db").schedule)
+
+	// dependents of the service are still paused while it's backed up
+	dbJob := worker.jobNamed("paperless/db").job.(*containerProjectBackupJob)
+	assert.Equal(t, "server", dbJob.findDependents(dbJob.plan[0])[0].ServiceName)
+
+	// the service jobs are replaced along with the project
+	delete(project.Containers, "db")
+	project.Containers["server"] = model.ContainerBackup{ServiceName: "server", Mode: model.BackupModeDefault, BackupVolumes: []model.Volume{{Source: "/media"}}}
+
+	assert.NoError(t, worker.scheduleContainerBackup(project))
+	assert.Equal(t, []string{"paperless"}, jobNames(worker))
+	assert.Len(t, worker.scheduler.Entries(), 1)
+}
+
+func TestWorkerScheduleContainerBackup_NameCollisions(t *testing.T) {
+	engine, _ := newFakeEngine(t)
+	worker := &Worker{
+		ctx:        context.Background(),
+		borgClient: newMissingRepoClient(t),
+		containers: map[model.ContainerEngine]*docker.Client{
+			model.ContainerEngineDocker: engine,
+			model.ContainerEnginePodman: engine,
+		},
+		scheduler: cron.New(),
+		jobs:      make(map[jobKey]*scheduledJob),
+	}
+
+	nightly, err := cron.ParseStandard("0 3 * * *")
+	assert.NoError(t, err)
+
+	project := func(engine model.ContainerEngine, name string) model.ContainerBackupProject {
+		return model.ContainerBackupProject{
+			Engine:      engine,
+			ProjectName: name,
+			Schedule:    nightly,
+			Containers: map[string]model.ContainerBackup{
+				"app": {ServiceName: "app", Mode: model.BackupModeDefault, BackupVolumes: []model.Volume{{Source: "/app"}}},
+			},
+		}
+	}
+
+	// projects of different engines don't replace each other
+	assert.NoError(t, worker.scheduleContainerBackup(project(model.ContainerEngineDocker, "paperless")))
+	assert.NoError(t, worker.scheduleContainerBackup(project(model.ContainerEnginePodman, "paperless")))
+	assert.NoError(t, worker.scheduleContainerBackup(project(model.ContainerEngineDocker, "paperless")))
+	assert.Equal(t, []string{"paperless", "podman:paperless"}, jobNames(worker))
+	assert.Len(t, worker.scheduler.Entries(), 2)
+
+	// static backups and projects can't have the same name
+	worker.ScheduleStaticBackups([]config.BackupConfig{{Name: "paperless"}, {Name: "gitea"}})
+	assert.Equal(t, []string{"gitea", "paperless", "podman:paperless"}, jobNames(worker))
+
+	err = worker.scheduleContainerBackup(project(model.ContainerEngineDocker, "gitea"))
+	assert.ErrorContains(t, err, "already used by a static backup")
+
+	err = worker.scheduleContainerBackup(project(model.ContainerEngineDocker, compactionJobName))
+	assert.ErrorContains(t, err, "reserved for repository compaction")
+
+	assert.Equal(t, []string{"gitea", "paperless", "podman:paperless"}, jobNames(worker))
+	assert.Len(t, worker.scheduler.Entries(), 2)
+}
+
+func TestWorkerScheduleContainerBackup_SwarmTaskReplaced(t *testing.T) {
+	engine, _ := newFakeEngine(t)
+	worker := &Worker{
+		ctx:        context.Background(),
+		borgClient: newMissingRepoClient(t),
+		containers: map[model.ContainerEngine]*docker.Client{model.ContainerEngineDocker: engine},
+		scheduler:  cron.New(),
+		jobs:       make(map[jobKey]*scheduledJob),
+	}
+
+	nightly, err := cron.ParseStandard("0 3 * * *")
+	assert.NoError(t, err)
+
+	project := func(taskID string, mode model.BackupMode) model.ContainerBackupProject {
+		return model.ContainerBackupProject{
+			Engine:      model.ContainerEngineDocker,
+			ProjectName: "paperless",
+			Schedule:    nightly,
+			Containers: map[string]model.ContainerBackup{
+				"db": {
+					ID:             taskID,
+					ServiceName:    "db",
+					Mode:           mode,
+					SwarmTask:      true,
+					SwarmServiceID: "db-id",
+					BackupVolumes:  []model.Volume{{Type: "volume", Name: taskID, Source: "/volumes/" + taskID, Destination: "/data"}},
+				},
+			},
+		}
+	}
+
+	assert.NoError(t, worker.scheduleContainerBackup(project("first", model.BackupModeDefault)))
+	scheduled := worker.jobNamed("paperless")
+
+	// a new task of the same service keeps the schedule, the next run uses its
+	// container
+	assert.NoError(t, worker.scheduleContainerBackup(project("second", model.BackupModeDefault)))
+	assert.Same(t, scheduled, worker.jobNamed("paperless"))
+	assert.Len(t, worker.scheduler.Entries(), 1)
+
+	projectJob := scheduled.job.(*containerProjectBackupJob)
+	current, plan := projectJob.tasks.current(projectJob.project, projectJob.plan)
+	assert.Equal(t, "second", current.Containers["db"].ID)
+	assert.Equal(t, "/volumes/second", plan[0].BackupVolumes[0].Source)
+
+	// other changes schedule the project again
+	assert.NoError(t, worker.scheduleContainerBackup(project("second", model.BackupModeDependentPaused)))
+	assert.NotSame(t, scheduled, worker.jobNamed("paperless"))
+	assert.Len(t, worker.scheduler.Entries(), 1)
+}
+
+func TestWorkerSchedule_Replace(t *testing.T) {
+	worker := &Worker{
+		ctx:       context.Background(),
+		scheduler: cron.New(),
+		jobs:      make(map[jobKey]*scheduledJob),
+	}
+
+	hourly, err := cron.ParseStandard("@hourly")
+	assert.NoError(t, err)
+
+	worker.schedule(&scheduledJob{name: "backup", kind: jobKindStatic, schedule: hourly, job: jobFunc(func() {})})
+	worker.schedule(&scheduledJob{name: "backup", kind: jobKindStatic, schedule: hourly, job: jobFunc(func() {})})
+
+	// the entry of the replaced job doesn't keep firing
+	assert.Len(t, worker.scheduler.Entries(), 1)
+	assert.Equal(t, worker.jobNamed("backup").entryId, worker.scheduler.Entries()[0].ID)
+}
+
+func TestWorkerSuperviseConfigWatch(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.toml")
+
+	// editors save by writing a temporary file and renaming it over the config
+	save := func(location string) {
+		tmpFile := filepath.Join(dir, ".config.toml.tmp")
+		assert.NoError(t, os.WriteFile(tmpFile, []byte(fmt.Sprintf("[Repo]\nLocation = %q\n", location)), 0o644))
+		assert.NoError(t, os.Rename(tmpFile, cfgFile))
+	}
+
+	save("/srv/borg/initial")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	worker := &Worker{ctx: ctx, configPath: cfgFile, watchBackoff: 10 * time.Millisecond}
+
+	watch, err := config.NewWatch(ctx, cfgFile)
+	assert.NoError(t, err)
+
+	updates := make(chan config.Config)
+	go worker.superviseConfigWatch(watch, updates)
+
+	nextLocation := func() string {
+		select {
+		case cfg := <-updates:
+			return cfg.Repo.Location
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for config update")
+		}
+
+		return ""
+	}
+
+	// the watch of the replaced file fails, the recreated watch publishes the
+	// reloaded config
+	save("/srv/borg/renamed")
+	assert.Equal(t, "/srv/borg/renamed", nextLocation())
+
+	// and keeps watching the new file
+	save("/srv/borg/again")
+	assert.Equal(t, "/srv/borg/again", nextLocation())
+}
+
+func TestWorkerSuperviseContainerWatch(t *testing.T) {
+	engine, server := newFakeEngine(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	worker := &Worker{ctx: ctx, watchBackoff: 10 * time.Millisecond}
+
+	labels := func(service string) map[string]string {
+		return map[string]string{
+			model.LabelBorgdEnabled: "true",
+			model.LabelProjectName:  "paperless",
+			model.LabelProjectWhen:  "0 3 * * *",
+			model.LabelServiceName:  service,
+		}
+	}
+
+	server.AddContainer(dockertest.Container{ID: "server", Name: "paperless-server-1", Labels: labels("server")})
+
+	_, err := engine.ReadProjects(ctx)
+	assert.NoError(t, err)
+
+	updates := make(chan model.ContainerBackupProject)
+	go worker.superviseContainerWatch(engine, updates)
+
+	nextUpdate := func() []string {
+		select {
+		case project := <-updates:
+			return slices.Sorted(maps.Keys(project.Containers))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for project update")
+		}
+
+		return nil
+	}
+
+	// wait for the watch to be established
+	assert.Eventually(t, func() bool {
+		server.AddContainer(dockertest.Container{ID: "db", Name: "paperless-db-1", Labels: labels("db")})
+		select {
+		case project := <-updates:
+			return len(project.Containers) == 2
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the engine is restarted, the change while it was stopped is caught by
+	// resyncing after reconnecting
+	server.StopEngine()
+	server.RemoveContainer("db")
+	time.Sleep(50 * time.Millisecond)
+	server.StartEngine()
+
+	assert.Equal(t, []string{"server"}, nextUpdate())
+
+	// and the reconnected watch is passed on
+	server.RemoveContainer("server")
+	assert.Empty(t, nextUpdate())
+}
@@ -17,6 +17,7 @@ package logging
 
 import (
 	"fmt"
+	"io"
 	golog "log"
 	"os"
 	"strings"
@@ -38,7 +39,12 @@ func (z *zerologLogger) Write(p []byte) (n int, err error) {
 }
 
 func InitLogging() {
-	consoleWriter := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339, NoColor: true}
+	InitLoggingTo(os.Stdout)
+}
+
+// InitLoggingTo is like InitLogging, but writes the log output to out.
+func InitLoggingTo(out io.Writer) {
+	consoleWriter := zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: true}
 	consoleWriter.FormatLevel = func(i interface{}) string {
 		return strings.ToUpper(fmt.Sprintf("| %5s |", i))
 	}
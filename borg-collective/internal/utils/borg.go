@@ -18,12 +18,22 @@ package utils
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 )
 
 var normalizationRegexp = regexp.MustCompile("[^_a-zA-Z0-9]+")
 
+const archiveTimestampLayout = "20060102150405"
+
 func ArchiveName(baseName string) string {
 	normalizedName := normalizationRegexp.ReplaceAllString(baseName, "_")
-	return fmt.Sprintf("%s-%s", normalizedName, time.Now().Format("20060102150405"))
+	return fmt.Sprintf("%s-%s", normalizedName, time.Now().Format(archiveTimestampLayout))
+}
+
+// ArchiveGlob returns the borg glob matching the archives ArchiveName creates
+// for the base name, and no others.
+func ArchiveGlob(baseName string) string {
+	normalizedName := normalizationRegexp.ReplaceAllString(baseName, "_")
+	return normalizedName + "-" + strings.Repeat("[0-9]", len(archiveTimestampLayout))
 }
@@ -23,19 +23,49 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/vemilyus/borg-collective/internal/drone/config"
 )
 
+// terminationGracePeriod is how long a process may take to exit after it was
+// sent SIGTERM because its context was done, before it is killed.
+const terminationGracePeriod = 30 * time.Second
+
+// Command creates a command that is sent SIGTERM instead of being killed right
+// away when the context is done, so it gets a chance to exit cleanly.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if ctx == nil {
+		return exec.Command(name, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = terminationGracePeriod
+
+	return cmd
+}
+
 func Exec(ctx context.Context, command []string) error {
+	return ExecWithEnv(ctx, command, nil)
+}
+
+// ExecWithEnv is like Exec, but adds env to the environment of the command.
+func ExecWithEnv(ctx context.Context, command []string, env []string) error {
 	log.Info().
 		Ctx(ctx).
 		Strs("command", command).
 		Msg("executing command")
 
-	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd := Command(ctx, command[0], command[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	if ctx.Value("test") == true {
 		cmd.Stderr = os.Stderr
@@ -107,7 +137,7 @@ func ExecWithOutput(ctx context.Context, command []string) (ErrorReader, error)
 		Strs("command", command).
 		Msg("executing command with output")
 
-	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd := Command(ctx, command[0], command[1:]...)
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
 
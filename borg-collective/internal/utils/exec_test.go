@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -70,3 +71,17 @@ func TestUtilsExecWithOutput(t *testing.T) {
 	var exitErr *exec.ExitError
 	assert.ErrorAs(t, errors.Unwrap(failingOutput.Error()), &exitErr)
 }
+
+func TestUtilsExec_TerminatesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := Exec(ctx, []string{"bash", "-c", "trap 'exit 3' TERM; while true; do sleep 0.1; done"})
+	assert.Error(t, err)
+
+	var exitErr *exec.ExitError
+	assert.ErrorAs(t, errors.Unwrap(err), &exitErr)
+
+	// exit code 3 means that the process received SIGTERM and exited on its own
+	assert.Equal(t, 3, exitErr.ExitCode())
+}
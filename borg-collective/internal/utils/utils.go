@@ -35,6 +35,21 @@ func SplitCommandLine(input string) []string {
 	return result
 }
 
+// JoinCommandLine is the inverse of SplitCommandLine, arguments containing
+// whitespace or quotes are quoted.
+func JoinCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n\r\"'") {
+			quoted[i] = arg
+		} else {
+			quoted[i] = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
 func unescape(s string) string {
 	s = strings.ReplaceAll(s, "\\\"", "\"")
 	s = strings.ReplaceAll(s, "\\'", "'")
@@ -34,3 +34,9 @@ func TestUtilsSplitCommandLine(t *testing.T) {
 	complexIncludingEnvVar := `echo "this is ${ENV_VAR} value"`
 	assert.Equal(t, []string{"echo", "this is ${ENV_VAR} value"}, SplitCommandLine(complexIncludingEnvVar))
 }
+
+func TestUtilsJoinCommandLine(t *testing.T) {
+	args := []string{"sh", "-c", `echo "it's a value" > /tmp/out`, ""}
+	assert.Equal(t, `sh -c "echo \"it's a value\" > /tmp/out" ""`, JoinCommandLine(args))
+	assert.Equal(t, args[:3], SplitCommandLine(JoinCommandLine(args)))
+}